@@ -0,0 +1,95 @@
+// Package v1alpha1 contains the API of the importer in the import.open-cluster-management.io group.
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	operatorv1 "open-cluster-management.io/api/operator/v1"
+)
+
+const (
+	// AnnotationImportConfig is set on the source cluster or the ManagedCluster with the name of
+	// the ImportConfig to import the cluster with.
+	AnnotationImportConfig = "import.open-cluster-management.io/import-config"
+
+	// DefaultImportConfigName is the name of the ImportConfig used by the clusters which are
+	// not selected by any other ImportConfig.
+	DefaultImportConfigName = "default"
+)
+
+var ImportConfigGVR = schema.GroupVersionResource{
+	Group:    "import.open-cluster-management.io",
+	Version:  "v1alpha1",
+	Resource: "importconfigs",
+}
+
+// ImportConfig is the cluster scoped configuration to import a set of clusters, the settings
+// which are not set in the ImportConfig fall back to the flags of the importer.
+type ImportConfig struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec ImportConfigSpec `json:"spec"`
+}
+
+type ImportConfigSpec struct {
+	// ClusterSelector selects the clusters by the labels of the source cluster or the ManagedCluster.
+	// +optional
+	ClusterSelector *metav1.LabelSelector `json:"clusterSelector,omitempty"`
+
+	// AgentNamespace is the namespace to deploy the agents on the cluster.
+	// +optional
+	AgentNamespace string `json:"agentNamespace,omitempty"`
+
+	// KlusterletName is the name of the klusterlet.
+	// +optional
+	KlusterletName string `json:"klusterletName,omitempty"`
+
+	// Mode is the install mode of the klusterlet, Default, Hosted or SingletonHosted.
+	// +optional
+	Mode operatorv1.InstallMode `json:"mode,omitempty"`
+
+	// Images is the images of the klusterlet.
+	// +optional
+	Images *Images `json:"images,omitempty"`
+
+	// RegistrationFeatureGates is the feature gates of the registration agent.
+	// +optional
+	RegistrationFeatureGates []operatorv1.FeatureGate `json:"registrationFeatureGates,omitempty"`
+
+	// WorkFeatureGates is the feature gates of the work agent.
+	// +optional
+	WorkFeatureGates []operatorv1.FeatureGate `json:"workFeatureGates,omitempty"`
+
+	// HubAPIServer is the URL of the hub apiserver in the bootstrap kubeconfig, when the
+	// clusters reach the hub with another address.
+	// +optional
+	HubAPIServer string `json:"hubAPIServer,omitempty"`
+}
+
+type Images struct {
+	// Registry is the registry of the images.
+	// +optional
+	Registry string `json:"registry,omitempty"`
+
+	// Version is the tag of all the images in the registry. A digest is not allowed since it is
+	// specific to an image, the digests are set in the full pull specs of the images.
+	// +optional
+	Version string `json:"version,omitempty"`
+
+	// Registration is the full pull spec of the registration image, it overrides the registry and the version.
+	// +optional
+	Registration string `json:"registration,omitempty"`
+
+	// Work is the full pull spec of the work image, it overrides the registry and the version.
+	// +optional
+	Work string `json:"work,omitempty"`
+
+	// Operator is the full pull spec of the registration-operator image, it overrides the registry and the version.
+	// +optional
+	Operator string `json:"operator,omitempty"`
+
+	// PullSecret is the namespace/name of the dockerconfigjson secret on the hub to pull the images.
+	// +optional
+	PullSecret string `json:"pullSecret,omitempty"`
+}
@@ -4,56 +4,110 @@ import (
 	"context"
 	"encoding/base64"
 	"fmt"
+	"time"
 
 	"github.com/openshift/library-go/pkg/controller/factory"
 	"github.com/openshift/library-go/pkg/operator/events"
 	"github.com/openshift/library-go/pkg/operator/resource/resourceapply"
+	"github.com/openshift/library-go/pkg/operator/resource/resourcemerge"
+	v1alpha1 "github.com/qiujian16/capi-importer/pkg/apis/v1alpha1"
 	"github.com/qiujian16/capi-importer/pkg/join"
 	"github.com/qiujian16/capi-importer/pkg/provider"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/klog/v2"
 	clusterclient "open-cluster-management.io/api/client/cluster/clientset/versioned"
 	clusterinformerv1 "open-cluster-management.io/api/client/cluster/informers/externalversions/cluster/v1"
 	clusterlisterv1 "open-cluster-management.io/api/client/cluster/listers/cluster/v1"
-	operatorv1 "open-cluster-management.io/api/operator/v1"
+	clusterv1 "open-cluster-management.io/api/cluster/v1"
 )
 
+const (
+	klusterletName = "klusterlet"
+	agentNamespace = "open-cluster-management-agent"
+
+	// joinTimeout is how long to wait for the agent to join the hub after the klusterlet is applied,
+	// before the klusterlet is applied again.
+	joinTimeout = 10 * time.Minute
+
+	// joinPollInterval is the interval to check whether the agent joins the hub, in case no event
+	// of the ManagedCluster is received.
+	joinPollInterval = 30 * time.Second
+
+	// spokeTimeout is the timeout of the requests to the spoke when detaching the cluster,
+	// the spoke may already be unreachable when its source cluster is deleted.
+	spokeTimeout = 10 * time.Second
+)
+
+// ImageConfig is the configuration of the klusterlet images deployed on the imported clusters
+type ImageConfig struct {
+	// Registry is the registry of the klusterlet images
+	Registry string
+	// Versions is the tags or digests of the klusterlet images in the registry
+	Versions join.ImageVersions
+	// Images is the full pull specs of the klusterlet images, they override the registry and the versions
+	Images join.Images
+	// PullSecret is the namespace/name of the dockerconfigjson secret on the hub, it is copied to
+	// the imported clusters to pull the images.
+	PullSecret string
+}
+
+// KlusterletConfig is the configuration of the klusterlet deployed for the imported clusters
+type KlusterletConfig struct {
+	// Mode is the install mode of the klusterlet, Default, Hosted or SingletonHosted
+	Mode string
+	// HostingKubeConfig is the kubeconfig of the cluster to deploy the klusterlet on in the hosted modes
+	HostingKubeConfig clientcmd.ClientConfig
+}
+
 type controller struct {
-	kubeClient      kubernetes.Interface
-	clusterClient   clusterclient.Interface
-	clusterLister   clusterlisterv1.ManagedClusterLister
-	bootstrapConfig join.BootstrapConfig
-	cache           resourceapply.ResourceCache
-	providers       map[string]provider.ClusterProvider
+	kubeClient       kubernetes.Interface
+	clusterClient    clusterclient.Interface
+	clusterLister    clusterlisterv1.ManagedClusterLister
+	configLister     cache.GenericLister
+	bootstrapConfig  join.BootstrapConfig
+	imageConfig      ImageConfig
+	klusterletConfig KlusterletConfig
+	cache            resourceapply.ResourceCache
+	providers        map[string]provider.ClusterProvider
 }
 
 func NewController(
 	kubeClient kubernetes.Interface,
 	clusterClient clusterclient.Interface,
 	clusterInformer clusterinformerv1.ManagedClusterInformer,
+	configInformer informers.GenericInformer,
 	bootstrapConfig join.BootstrapConfig,
+	imageConfig ImageConfig,
+	klusterletConfig KlusterletConfig,
 	recorder events.Recorder,
 	providers ...provider.ClusterProvider) factory.Controller {
 
 	c := &controller{
-		kubeClient:      kubeClient,
-		clusterLister:   clusterInformer.Lister(),
-		clusterClient:   clusterClient,
-		cache:           resourceapply.NewResourceCache(),
-		providers:       map[string]provider.ClusterProvider{},
-		bootstrapConfig: bootstrapConfig,
+		kubeClient:       kubeClient,
+		clusterLister:    clusterInformer.Lister(),
+		clusterClient:    clusterClient,
+		cache:            resourceapply.NewResourceCache(),
+		providers:        map[string]provider.ClusterProvider{},
+		bootstrapConfig:  bootstrapConfig,
+		imageConfig:      imageConfig,
+		klusterletConfig: klusterletConfig,
 	}
 
-	ctrl := factory.New().WithInformersQueueKeysFunc(func(obj runtime.Object) []string {
-		// todo add provider name and capi ref in the key
-		key, _ := cache.MetaNamespaceKeyFunc(obj)
-		return []string{key}
-	}, clusterInformer.Informer())
+	ctrl := factory.New().WithInformersQueueKeysFunc(managedClusterQueueKeys, clusterInformer.Informer())
+
+	// the ImportConfig informer is nil when the CRD is not installed on the hub
+	if configInformer != nil {
+		c.configLister = configInformer.Lister()
+		ctrl = ctrl.WithInformersQueueKeysFunc(c.importConfigQueueKeys, configInformer.Informer())
+	}
 
 	for _, p := range providers {
 		ctrl = ctrl.WithInformersQueueKeysFunc(p.Key, p)
@@ -63,91 +117,517 @@ func NewController(
 	return ctrl.WithSync(c.sync).ToController("importer", recorder)
 }
 
+// managedClusterQueueKeys maps the ManagedCluster to the key of its cluster in the provider,
+// ManagedClusters which are not imported by the importer are ignored.
+func managedClusterQueueKeys(obj runtime.Object) []string {
+	ref, ok := provider.RefFromAnnotation(obj)
+	if !ok {
+		return []string{}
+	}
+	return []string{ref.Key()}
+}
+
 func (n *controller) sync(ctx context.Context, controllerContext factory.SyncContext) error {
 	logger := klog.FromContext(ctx)
 	key := controllerContext.QueueKey()
 	logger.V(4).Info("Reconciling cluster provider", "queueKey", key)
 
-	providerName, clusterKey, err := provider.ParseKey(key)
+	ref, err := provider.ParseKey(key)
 	if err != nil {
 		return err
 	}
 
-	p, ok := n.providers[providerName]
+	p, ok := n.providers[ref.Provider]
 	if !ok {
-		return fmt.Errorf("provider %s does not exist", providerName)
+		return fmt.Errorf("provider %s does not exist", ref.Provider)
 	}
+	clusterName := ref.Name
 
-	_, clusterName, err := cache.SplitMetaNamespaceKey(clusterKey)
+	deleted, err := p.Deleted(ref)
 	if err != nil {
 		return err
 	}
+	if deleted {
+		return n.detach(ctx, p, ref, controllerContext.Recorder())
+	}
 
-	cluster, err := n.clusterLister.Get(clusterName)
-	if err != nil && !errors.IsNotFound(err) {
+	labels, err := p.Labels(ref)
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
 		return err
 	}
 
-	// cluster is imported already, do nothing
-	if err == nil && meta.IsStatusConditionTrue(cluster.Status.Conditions, "Imported") {
+	id, err := clusterID(p, ref)
+	if errors.IsNotFound(err) {
 		return nil
 	}
-	cluster = cluster.DeepCopy()
+	if err != nil {
+		return err
+	}
 
-	bootstrapper := join.NewTokenBootStrapper(n.bootstrapConfig, n.kubeClient)
-	bootstrapKubeConfig, err := bootstrapper.KubeConfigRaw()
+	cluster, err := n.ensureManagedCluster(ctx, ref, labels, id)
 	if err != nil {
 		return err
 	}
 
-	kubeConfig, err := p.KubeConfig(clusterKey)
+	if f, ok := p.(provider.Finalizer); ok {
+		if err := f.AddFinalizer(ctx, ref); err != nil {
+			return err
+		}
+	}
+
+	// cluster is imported already, only rotate the bootstrap kubeconfig and mirror the status
+	// which may be changed by the hub
+	if meta.IsStatusConditionTrue(cluster.Status.Conditions, conditionImported) {
+		if err := n.rotateBootstrapKubeConfig(ctx, controllerContext, p, ref, cluster); err != nil {
+			return err
+		}
+		return reportStatus(ctx, p, ref, cluster)
+	}
+
+	kubeConfig, err := p.KubeConfig(ref)
 	if errors.IsNotFound(err) {
 		return nil
 	}
 	if err != nil {
+		return n.importFailed(ctx, p, ref, cluster, &join.PhaseError{Phase: phaseCredentialsFetched, Err: err})
+	}
+
+	config, err := n.importConfig(p, ref, cluster)
+	if err != nil {
+		return n.importFailed(ctx, p, ref, cluster, err)
+	}
+
+	// the klusterlet is applied already, wait for the agent to join the hub instead of applying
+	// it again.
+	if meta.IsStatusConditionTrue(cluster.Status.Conditions, conditionKlusterletApplied) {
+		return n.waitForJoin(ctx, controllerContext, p, ref, cluster, kubeConfig, config)
+	}
+
+	klusterletConfig := n.klusterletConfigOf(p, ref)
+	values := n.klusterletValues(clusterName, klusterletConfig, config)
+
+	bootstrapConfig := n.bootstrapConfigOf(config)
+	bootstrapper, err := join.NewCachedBootStrapper(bootstrapConfig, n.kubeClient, clusterName)
+	if err != nil {
+		return err
+	}
+	bootstrapKubeConfig, err := bootstrapper.KubeConfigRaw()
+	if err != nil {
+		return n.importFailed(ctx, p, ref, cluster, err)
+	}
+
+	values.Hub = join.Hub{
+		KubeConfig: base64.StdEncoding.EncodeToString(bootstrapKubeConfig),
+		HostAlias:  bootstrapConfig.HostAlias(),
+	}
+
+	values.ImagePullSecret, err = n.imagePullSecret(ctx, n.pullSecret(config))
+	if err != nil {
+		return n.importFailed(ctx, p, ref, cluster, err)
+	}
+
+	recorded, err := n.recordKlusterlet(ctx, cluster, klusterletConfig, values)
+	if err != nil {
+		return n.importFailed(ctx, p, ref, cluster, err)
+	}
+	cluster = recorded
+
+	builder := join.NewBuilder().
+		WithSpokeKubeConfig(kubeConfig).
+		WithHostingKubeConfig(klusterletConfig.HostingKubeConfig).
+		WithValues(values)
+	report, err := builder.ApplyImport(ctx, controllerContext.Recorder())
+	for _, result := range report.Failed() {
+		controllerContext.Recorder().Warningf("ManifestApplyFailed", "failed to apply %s on cluster %s: %v",
+			result, clusterName, result.Error)
+	}
+	if err != nil {
+		return n.importFailed(ctx, p, ref, cluster, err)
+	}
+
+	updated := cluster.DeepCopy()
+	setImportConditions(&updated.Status.Conditions, cluster.Generation, nil)
+	if _, err := n.updateImportStatus(ctx, p, ref, cluster, updated); err != nil {
+		return err
+	}
+	controllerContext.Queue().AddAfter(key, joinPollInterval)
+	return nil
+}
+
+// waitForJoin marks the cluster imported once the agent joins the hub and the cluster is available.
+// The cluster is requeued until then, and the conditions of the klusterlet on the spoke are reported
+// if the agent does not join in time.
+func (n *controller) waitForJoin(ctx context.Context, controllerContext factory.SyncContext, p provider.ClusterProvider,
+	ref provider.ClusterRef, cluster *clusterv1.ManagedCluster, kubeConfig clientcmd.ClientConfig,
+	config *v1alpha1.ImportConfig) error {
+	applied := meta.FindStatusCondition(cluster.Status.Conditions, conditionKlusterletApplied)
+	timeout := time.Since(applied.LastTransitionTime.Time) > joinTimeout
+	if !joined(cluster.Status.Conditions) && !timeout {
+		controllerContext.Queue().AddAfter(ref.Key(), joinPollInterval)
+		return nil
+	}
+
+	klusterletMessage := ""
+	if timeout {
+		klusterletConditions, err := n.klusterletConditions(ctx, p, ref, cluster, kubeConfig, config)
+		if err != nil {
+			klusterletMessage = fmt.Sprintf("failed to get the klusterlet: %v", err)
+		} else {
+			klusterletMessage = formatConditions(klusterletConditions)
+		}
+	}
+
+	updated := cluster.DeepCopy()
+	setJoinConditions(&updated.Status.Conditions, cluster.Generation, timeout, klusterletMessage)
+	if _, err := n.updateImportStatus(ctx, p, ref, cluster, updated); err != nil {
 		return err
 	}
 
-	values := join.Values{
-		ClusterName:    clusterName,
-		AgentNamespace: "open-cluster-management-agent",
-		Hub: join.Hub{
-			KubeConfig: base64.StdEncoding.EncodeToString(bootstrapKubeConfig),
-		},
-		Klusterlet: join.Klusterlet{
-			Name: "klusterlet",
-		},
-		Registry: "quay.io/open-cluster-management-io",
-		BundleVersion: join.BundleVersion{
-			RegistrationImageVersion: "latest",
-			WorkImageVersion:         "latest",
-			OperatorImageVersion:     "latest",
-		},
-		RegistrationFeatures: []operatorv1.FeatureGate{},
-		WorkFeatures:         []operatorv1.FeatureGate{},
-	}
-
-	builder := join.NewBuilder().WithSpokeKubeConfig(kubeConfig).WithValues(values)
-	err = builder.ApplyImport(ctx, controllerContext.Recorder())
-
-	if err != nil {
-		meta.SetStatusCondition(&cluster.Status.Conditions, metav1.Condition{
-			Type:    "Imported",
-			Status:  metav1.ConditionFalse,
-			Reason:  "ImportError",
-			Message: fmt.Sprintf("Failed to import with err %v", err),
-		})
-	} else {
-		meta.SetStatusCondition(&cluster.Status.Conditions, metav1.Condition{
-			Type:    "Imported",
-			Status:  metav1.ConditionTrue,
-			Reason:  "ImportSucceed",
-			Message: "Import succeeds",
-		})
-	}
-	_, updateErr := n.clusterClient.ClusterV1().ManagedClusters().UpdateStatus(ctx, cluster, metav1.UpdateOptions{})
-	if updateErr != nil {
+	if !joined(cluster.Status.Conditions) {
+		controllerContext.Recorder().Warningf("JoinTimeout", "cluster %s does not join the hub in %s", ref.Name, joinTimeout)
+		return fmt.Errorf("cluster %s does not join the hub in %s", ref.Name, joinTimeout)
+	}
+	return evict(ctx, p, ref)
+}
+
+// klusterletConditions returns the conditions of the klusterlet recorded for the cluster
+func (n *controller) klusterletConditions(ctx context.Context, p provider.ClusterProvider, ref provider.ClusterRef,
+	cluster *clusterv1.ManagedCluster, kubeConfig clientcmd.ClientConfig, config *v1alpha1.ImportConfig) ([]metav1.Condition, error) {
+	klusterletConfig, values, err := n.recordedKlusterlet(p, ref, cluster, config)
+	if err != nil {
+		return nil, err
+	}
+	return join.NewBuilder().
+		WithSpokeKubeConfig(kubeConfig).
+		WithHostingKubeConfig(klusterletConfig.HostingKubeConfig).
+		WithTimeout(spokeTimeout).
+		WithValues(values).
+		KlusterletConditions(ctx)
+}
+
+// importFailed records the error of the import in the conditions of the ManagedCluster, the
+// error is returned to retry the import.
+func (n *controller) importFailed(
+	ctx context.Context, p provider.ClusterProvider, ref provider.ClusterRef, cluster *clusterv1.ManagedCluster, err error) error {
+	updated := cluster.DeepCopy()
+	setImportConditions(&updated.Status.Conditions, cluster.Generation, err)
+	if _, updateErr := n.updateImportStatus(ctx, p, ref, cluster, updated); updateErr != nil {
 		return updateErr
 	}
 	return err
 }
+
+// rotateBootstrapKubeConfig rotates the bootstrap kubeconfig of the imported cluster before it expires,
+// the bootstrap secret of the klusterlet is updated in place so the agent is able to bootstrap again.
+// The new kubeconfig is cached only after it is delivered, so a failed delivery is retried.
+func (n *controller) rotateBootstrapKubeConfig(ctx context.Context, controllerContext factory.SyncContext,
+	p provider.ClusterProvider, ref provider.ClusterRef, cluster *clusterv1.ManagedCluster) error {
+	config, err := n.importConfig(p, ref, cluster)
+	if err != nil {
+		return err
+	}
+	bootstrapConfig := n.bootstrapConfigOf(config)
+	bootstrapper, err := join.NewCachedBootStrapper(bootstrapConfig, n.kubeClient, ref.Name)
+	if err != nil {
+		return err
+	}
+
+	rotateAt, err := bootstrapper.RotateAt()
+	if err != nil {
+		return err
+	}
+	if wait := time.Until(rotateAt); wait > 0 {
+		controllerContext.Queue().AddAfter(ref.Key(), wait)
+		return nil
+	}
+
+	kubeConfig, err := p.KubeConfig(ref)
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	klusterletConfig, values, err := n.recordedKlusterlet(p, ref, cluster, config)
+	if err != nil {
+		return err
+	}
+	bootstrapKubeConfig, err := bootstrapper.Issue()
+	if err != nil {
+		return err
+	}
+	values.Hub = join.Hub{
+		KubeConfig: base64.StdEncoding.EncodeToString(bootstrapKubeConfig),
+		HostAlias:  bootstrapConfig.HostAlias(),
+	}
+	_, err = join.NewBuilder().
+		WithSpokeKubeConfig(kubeConfig).
+		WithHostingKubeConfig(klusterletConfig.HostingKubeConfig).
+		WithTimeout(spokeTimeout).
+		WithValues(values).
+		ApplyBootstrapKubeConfig(ctx, controllerContext.Recorder())
+	if err != nil {
+		return err
+	}
+	if err := bootstrapper.Store(bootstrapKubeConfig); err != nil {
+		return err
+	}
+	controllerContext.Recorder().Eventf("BootstrapKubeConfigRotated", "bootstrap kubeconfig of cluster %s is rotated", ref.Name)
+
+	if err := evict(ctx, p, ref); err != nil {
+		return err
+	}
+
+	rotateAt, err = bootstrapper.RotateAt()
+	if err != nil {
+		return err
+	}
+	controllerContext.Queue().AddAfter(ref.Key(), time.Until(rotateAt))
+	return nil
+}
+
+// clusterID returns the id of the cluster in the provider, or empty if the provider does not
+// identify its clusters other than by the name.
+func clusterID(p provider.ClusterProvider, ref provider.ClusterRef) (string, error) {
+	identifier, ok := p.(provider.Identifier)
+	if !ok {
+		return "", nil
+	}
+	return identifier.ID(ref)
+}
+
+// ensureManagedCluster creates the ManagedCluster of the imported cluster if it does not exist, so the
+// klusterlet is able to register without the ManagedCluster being pre-created on the hub. The
+// infrastructure labels from the provider are kept on the ManagedCluster. An existing ManagedCluster
+// is only updated if it is created by the importer for the same source cluster. If the source cluster
+// is recreated with a new id, the import status is reset so the new cluster is imported again.
+func (n *controller) ensureManagedCluster(
+	ctx context.Context, ref provider.ClusterRef, labels map[string]string, id string) (*clusterv1.ManagedCluster, error) {
+	cluster, err := n.clusterLister.Get(ref.Name)
+	if errors.IsNotFound(err) {
+		cluster = &clusterv1.ManagedCluster{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   ref.Name,
+				Labels: labels,
+				Annotations: map[string]string{
+					provider.AnnotationClusterRef: ref.Key(),
+				},
+			},
+			Spec: clusterv1.ManagedClusterSpec{
+				HubAcceptsClient: true,
+			},
+		}
+		if len(id) > 0 {
+			cluster.Annotations[provider.AnnotationClusterID] = id
+		}
+		return n.clusterClient.ClusterV1().ManagedClusters().Create(ctx, cluster, metav1.CreateOptions{})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// do not take over the cluster created by others or imported from another provider, since the
+	// cluster taken over would be deleted once the source cluster is removed.
+	owner, ok := cluster.Annotations[provider.AnnotationClusterRef]
+	if !ok {
+		return nil, fmt.Errorf("managed cluster %s exists and is not imported by the importer", ref.Name)
+	}
+	if owner != ref.Key() {
+		return nil, fmt.Errorf("managed cluster %s is owned by %s", ref.Name, owner)
+	}
+
+	modified := false
+	cluster = cluster.DeepCopy()
+	resourcemerge.MergeMap(&modified, &cluster.Labels, labels)
+	// the labels of the provider are pruned, e.g. the region of a cluster moved to a provider
+	// without the notion of region.
+	for _, key := range provider.LabelKeys {
+		if _, ok := labels[key]; ok {
+			continue
+		}
+		if _, ok := cluster.Labels[key]; ok {
+			delete(cluster.Labels, key)
+			modified = true
+		}
+	}
+	// the cluster imported before its id is recorded is not recreated
+	recorded, hasID := cluster.Annotations[provider.AnnotationClusterID]
+	recreated := hasID && recorded != id
+	if len(id) > 0 && recorded != id {
+		cluster.Annotations[provider.AnnotationClusterID] = id
+		modified = true
+	}
+	if !modified {
+		return cluster, nil
+	}
+	cluster, err = n.clusterClient.ClusterV1().ManagedClusters().Update(ctx, cluster, metav1.UpdateOptions{})
+	if err != nil || !recreated {
+		return cluster, err
+	}
+	return n.resetImport(ctx, cluster)
+}
+
+// resetImport removes the conditions of the import from the ManagedCluster, so the cluster is
+// imported again from the first phase.
+func (n *controller) resetImport(ctx context.Context, cluster *clusterv1.ManagedCluster) (*clusterv1.ManagedCluster, error) {
+	cluster = cluster.DeepCopy()
+	meta.RemoveStatusCondition(&cluster.Status.Conditions, conditionImported)
+	for _, p := range importPhases {
+		meta.RemoveStatusCondition(&cluster.Status.Conditions, p.condition)
+	}
+	return n.clusterClient.ClusterV1().ManagedClusters().UpdateStatus(ctx, cluster, metav1.UpdateOptions{})
+}
+
+// detach cleans up the klusterlet on the spoke if it is still reachable, then removes the
+// ManagedCluster and its namespace from the hub and revokes the bootstrap token. The finalizer
+// on the source cluster is removed at last, so the source cluster is kept until detached.
+func (n *controller) detach(ctx context.Context, p provider.ClusterProvider, ref provider.ClusterRef, recorder events.Recorder) error {
+	cluster, err := n.clusterLister.Get(ref.Name)
+	switch {
+	case errors.IsNotFound(err):
+	case err != nil:
+		return err
+	case cluster.Annotations[provider.AnnotationClusterRef] != ref.Key():
+		// the ManagedCluster is not imported from this cluster, leave it alone
+		return n.removeFinalizer(ctx, p, ref)
+	}
+
+	config, err := n.importConfig(p, ref, cluster)
+	if err != nil {
+		return err
+	}
+
+	if err := n.detachKlusterlet(ctx, p, ref, cluster, config, recorder); err != nil {
+		return err
+	}
+
+	if cluster != nil {
+		err = n.clusterClient.ClusterV1().ManagedClusters().Delete(ctx, ref.Name, metav1.DeleteOptions{})
+		if err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+		recorder.Eventf("ManagedClusterDeleted", "managed cluster %s is deleted", ref.Name)
+	}
+
+	if err := n.deleteClusterNamespace(ctx, ref.Name); err != nil {
+		return err
+	}
+
+	bootstrapper, err := join.NewCachedBootStrapper(n.bootstrapConfigOf(config), n.kubeClient, ref.Name)
+	if err != nil {
+		return err
+	}
+	if err := bootstrapper.Revoke(); err != nil {
+		return err
+	}
+
+	if err := evict(ctx, p, ref); err != nil {
+		return err
+	}
+
+	return n.removeFinalizer(ctx, p, ref)
+}
+
+// detachKlusterlet deletes the klusterlet recorded on the ManagedCluster from the spoke if it is
+// still reachable, or from the hosting cluster in the hosted modes.
+func (n *controller) detachKlusterlet(ctx context.Context, p provider.ClusterProvider, ref provider.ClusterRef,
+	cluster *clusterv1.ManagedCluster, config *v1alpha1.ImportConfig, recorder events.Recorder) error {
+	logger := klog.FromContext(ctx)
+	klusterletConfig, values, err := n.recordedKlusterlet(p, ref, cluster, config)
+	if err != nil {
+		recorder.Warningf("KlusterletNotDetached", "skip cleaning up the klusterlet of cluster %s: %v", ref.Name, err)
+		return nil
+	}
+	builder := join.NewBuilder().
+		WithHostingKubeConfig(klusterletConfig.HostingKubeConfig).
+		WithTimeout(spokeTimeout).
+		WithValues(values)
+
+	if !values.Klusterlet.Hosted() {
+		kubeConfig, err := p.KubeConfig(ref)
+		if errors.IsNotFound(err) {
+			logger.V(4).Info("Kubeconfig of the cluster is not found, skip cleaning up the spoke", "cluster", ref)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		builder = builder.WithSpokeKubeConfig(kubeConfig)
+	}
+
+	err = builder.ApplyDetach(ctx, recorder)
+	// retry on the errors returned by the apiserver, other errors mean the cluster is not
+	// reachable any more and there is nothing to clean up on it. The credentials of a deleted
+	// cluster are usually revoked during the teardown, so the cluster is not accessible any more
+	// on unauthorized and forbidden errors either.
+	_, isStatus := err.(errors.APIStatus)
+	if isStatus && !errors.IsUnauthorized(err) && !errors.IsForbidden(err) {
+		return err
+	}
+	if err != nil {
+		logger.Info("Cluster is not reachable, skip cleaning up the klusterlet", "cluster", ref, "err", err)
+	}
+	return nil
+}
+
+// evict drops the kubeconfig of the cluster kept by the provider once the importer is done with it.
+func evict(ctx context.Context, p provider.ClusterProvider, ref provider.ClusterRef) error {
+	e, ok := p.(provider.CredentialEvicter)
+	if !ok {
+		return nil
+	}
+	return e.Evict(ctx, ref)
+}
+
+// imagePullSecret returns the base64 encoded docker config json of the image pull secret on the hub
+func (n *controller) imagePullSecret(ctx context.Context, pullSecret string) (string, error) {
+	if len(pullSecret) == 0 {
+		return "", nil
+	}
+	namespace, name, err := cache.SplitMetaNamespaceKey(pullSecret)
+	if err != nil {
+		return "", err
+	}
+	secret, err := n.kubeClient.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+	data, ok := secret.Data[corev1.DockerConfigJsonKey]
+	if !ok {
+		return "", fmt.Errorf("missing key %q in image pull secret %s", corev1.DockerConfigJsonKey, pullSecret)
+	}
+	return base64.StdEncoding.EncodeToString(data), nil
+}
+
+// deleteClusterNamespace deletes the namespace created by the hub for the cluster, namespaces
+// which are not labeled with the cluster name are not touched.
+func (n *controller) deleteClusterNamespace(ctx context.Context, clusterName string) error {
+	ns, err := n.kubeClient.CoreV1().Namespaces().Get(ctx, clusterName, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if ns.Labels[clusterv1.ClusterNameLabelKey] != clusterName || ns.DeletionTimestamp != nil {
+		return nil
+	}
+
+	err = n.kubeClient.CoreV1().Namespaces().Delete(ctx, clusterName, metav1.DeleteOptions{})
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+func (n *controller) removeFinalizer(ctx context.Context, p provider.ClusterProvider, ref provider.ClusterRef) error {
+	f, ok := p.(provider.Finalizer)
+	if !ok {
+		return nil
+	}
+	return f.RemoveFinalizer(ctx, ref)
+}
@@ -0,0 +1,724 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/openshift/library-go/pkg/operator/events"
+	"github.com/qiujian16/capi-importer/pkg/join"
+	"github.com/qiujian16/capi-importer/pkg/provider"
+	authv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	"k8s.io/client-go/util/workqueue"
+	clusterfake "open-cluster-management.io/api/client/cluster/clientset/versioned/fake"
+	clusterlisterv1 "open-cluster-management.io/api/client/cluster/listers/cluster/v1"
+	clusterv1 "open-cluster-management.io/api/cluster/v1"
+	operatorv1 "open-cluster-management.io/api/operator/v1"
+)
+
+const testNamespace = "open-cluster-management"
+
+// testProvider is a provider with a single cluster whose state is set by the tests
+type testProvider struct {
+	deleted    bool
+	labels     map[string]string
+	kubeConfig clientcmd.ClientConfig
+}
+
+func (p *testProvider) AddEventHandler(_ cache.ResourceEventHandler) (cache.ResourceEventHandlerRegistration, error) {
+	return nil, nil
+}
+
+func (p *testProvider) HasSynced() bool {
+	return true
+}
+
+func (p *testProvider) Key(_ runtime.Object) []string {
+	return []string{}
+}
+
+func (p *testProvider) KubeConfig(ref provider.ClusterRef) (clientcmd.ClientConfig, error) {
+	if p.kubeConfig == nil {
+		return nil, errors.NewNotFound(schema.GroupResource{Resource: "clusters"}, ref.Name)
+	}
+	return p.kubeConfig, nil
+}
+
+func (p *testProvider) Labels(_ provider.ClusterRef) (map[string]string, error) {
+	return p.labels, nil
+}
+
+func (p *testProvider) Deleted(_ provider.ClusterRef) (bool, error) {
+	return p.deleted, nil
+}
+
+func (p *testProvider) Name() string {
+	return "test"
+}
+
+func (p *testProvider) Start(_ context.Context) {}
+
+var testRef = provider.ClusterRef{Provider: "test", Namespace: "ns1", Name: "cluster1"}
+
+func newManagedCluster(annotations map[string]string, conditions ...metav1.Condition) *clusterv1.ManagedCluster {
+	return &clusterv1.ManagedCluster{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        testRef.Name,
+			Annotations: annotations,
+		},
+		Status: clusterv1.ManagedClusterStatus{
+			Conditions: conditions,
+		},
+	}
+}
+
+func importedBy(ref provider.ClusterRef) map[string]string {
+	return map[string]string{provider.AnnotationClusterRef: ref.Key()}
+}
+
+func withID(annotations map[string]string, id string) map[string]string {
+	annotations[provider.AnnotationClusterID] = id
+	return annotations
+}
+
+func newTestController(t *testing.T, p provider.ClusterProvider, clusters []runtime.Object, kubeObjs ...runtime.Object) (
+	*controller, *clusterfake.Clientset, *kubefake.Clientset) {
+	clusterClient := clusterfake.NewSimpleClientset(clusters...)
+	kubeClient := kubefake.NewSimpleClientset(kubeObjs...)
+
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, cluster := range clusters {
+		if err := indexer.Add(cluster); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return &controller{
+		kubeClient:    kubeClient,
+		clusterClient: clusterClient,
+		clusterLister: clusterlisterv1.NewManagedClusterLister(indexer),
+		bootstrapConfig: join.BootstrapConfig{
+			CA:              []byte("ca"),
+			HubAPIServer:    "https://hub.example.com:6443",
+			SANamespace:     testNamespace,
+			SAName:          "bootstrap",
+			Strategy:        join.BootstrapStrategyToken,
+			TokenExpiration: time.Hour,
+		},
+		imageConfig: ImageConfig{
+			Registry: "quay.io/open-cluster-management",
+			Versions: join.ImageVersions{Registration: "v0.12.0", Work: "v0.12.0", Operator: "v0.12.0"},
+		},
+		klusterletConfig: KlusterletConfig{Mode: string(operatorv1.InstallModeDefault)},
+		providers:        map[string]provider.ClusterProvider{p.Name(): p},
+	}, clusterClient, kubeClient
+}
+
+// fakeQueue records the keys requeued after a delay
+type fakeQueue struct {
+	workqueue.RateLimitingInterface
+	requeued map[string]time.Duration
+}
+
+func (q *fakeQueue) AddAfter(item interface{}, duration time.Duration) {
+	q.requeued[item.(string)] = duration
+}
+
+// fakeSyncContext is the sync context of a key, the key is not requeued by the sync if the delay
+// of the key in the queue is not set.
+type fakeSyncContext struct {
+	key      string
+	queue    *fakeQueue
+	recorder events.Recorder
+}
+
+func newFakeSyncContext(key string) *fakeSyncContext {
+	return &fakeSyncContext{
+		key:      key,
+		queue:    &fakeQueue{requeued: map[string]time.Duration{}},
+		recorder: events.NewInMemoryRecorder("test"),
+	}
+}
+
+func (c *fakeSyncContext) Queue() workqueue.RateLimitingInterface { return c.queue }
+func (c *fakeSyncContext) QueueKey() string                       { return c.key }
+func (c *fakeSyncContext) Recorder() events.Recorder              { return c.recorder }
+
+// unreachableKubeConfig is the kubeconfig of a spoke refusing the connections
+func unreachableKubeConfig() clientcmd.ClientConfig {
+	return kubeConfigOf("https://127.0.0.1:1")
+}
+
+func kubeConfigOf(server string) clientcmd.ClientConfig {
+	return clientcmd.NewDefaultClientConfig(clientcmdapi.Config{
+		Clusters:       map[string]*clientcmdapi.Cluster{"spoke": {Server: server}},
+		AuthInfos:      map[string]*clientcmdapi.AuthInfo{"spoke": {Token: "token"}},
+		Contexts:       map[string]*clientcmdapi.Context{"spoke": {Cluster: "spoke", AuthInfo: "spoke"}},
+		CurrentContext: "spoke",
+	}, &clientcmd.ConfigOverrides{})
+}
+
+// fakeAPIServer is an apiserver which records the requests and fails them with the status
+type fakeAPIServer struct {
+	*httptest.Server
+	lock     sync.Mutex
+	requests []string
+}
+
+func newFakeAPIServer(t *testing.T, code int, reason metav1.StatusReason) *fakeAPIServer {
+	h := &fakeAPIServer{}
+	h.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.lock.Lock()
+		h.requests = append(h.requests, fmt.Sprintf("%s %s", r.Method, r.URL.Path))
+		h.lock.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		fmt.Fprintf(w, `{"kind":"Status","apiVersion":"v1","status":"Failure","reason":%q,"code":%d}`, reason, code)
+	}))
+	t.Cleanup(h.Close)
+	return h
+}
+
+func (h *fakeAPIServer) reset() {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.requests = nil
+}
+
+// requested returns true if the request is received since the last reset
+func (h *fakeAPIServer) requested(request string) bool {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	for _, r := range h.requests {
+		if r == request {
+			return true
+		}
+	}
+	return false
+}
+
+// withTokenRequests makes the fake client issue tokens for the token requests of the service
+// accounts, the tracker of the fake client does not support them.
+func withTokenRequests(client *kubefake.Clientset) {
+	client.PrependReactor("create", "serviceaccounts", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		if action.GetSubresource() != "token" {
+			return false, nil, nil
+		}
+		return true, &authv1.TokenRequest{Status: authv1.TokenRequestStatus{Token: "token"}}, nil
+	})
+}
+
+// condition returns the condition set at the time
+func condition(conditionType string, status metav1.ConditionStatus, reason string, at time.Time) metav1.Condition {
+	return metav1.Condition{
+		Type:               conditionType,
+		Status:             status,
+		Reason:             reason,
+		LastTransitionTime: metav1.NewTime(at),
+	}
+}
+
+// updatedCluster returns the ManagedCluster of the last status update
+func updatedCluster(t *testing.T, clusterClient *clusterfake.Clientset) *clusterv1.ManagedCluster {
+	updates := filterActions(clusterClient.Actions(), "update", "managedclusters")
+	for i := len(updates) - 1; i >= 0; i-- {
+		if updates[i].GetSubresource() == "status" {
+			return updates[i].(clienttesting.UpdateAction).GetObject().(*clusterv1.ManagedCluster)
+		}
+	}
+	t.Fatalf("expected the status of the managed cluster is updated")
+	return nil
+}
+
+// filterActions returns the actions of the verb on the resource
+func filterActions(actions []clienttesting.Action, verb, resource string) []clienttesting.Action {
+	var filtered []clienttesting.Action
+	for _, action := range actions {
+		if action.GetVerb() == verb && action.GetResource().Resource == resource {
+			filtered = append(filtered, action)
+		}
+	}
+	return filtered
+}
+
+func TestManagedClusterQueueKeys(t *testing.T) {
+	cases := []struct {
+		name        string
+		annotations map[string]string
+		expected    []string
+	}{
+		{
+			name:     "cluster not imported by the importer",
+			expected: []string{},
+		},
+		{
+			name:        "invalid cluster ref",
+			annotations: map[string]string{provider.AnnotationClusterRef: "cluster1"},
+			expected:    []string{},
+		},
+		{
+			name:        "capi cluster",
+			annotations: map[string]string{provider.AnnotationClusterRef: "capi/ns1/cluster1"},
+			expected:    []string{"capi/ns1/cluster1"},
+		},
+		{
+			name:        "cluster service cluster",
+			annotations: map[string]string{provider.AnnotationClusterRef: "clusterservice/cluster1"},
+			expected:    []string{"clusterservice/cluster1"},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			cluster := &clusterv1.ManagedCluster{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "cluster1",
+					Annotations: c.annotations,
+				},
+			}
+			keys := managedClusterQueueKeys(cluster)
+			if !reflect.DeepEqual(keys, c.expected) {
+				t.Errorf("expected keys %v, but got %v", c.expected, keys)
+			}
+		})
+	}
+}
+
+func TestEnsureManagedCluster(t *testing.T) {
+	labels := map[string]string{provider.LabelCloud: provider.CloudAmazon}
+
+	imported := metav1.Condition{Type: conditionImported, Status: metav1.ConditionTrue, Reason: reasonImportSucceed}
+
+	cases := []struct {
+		name               string
+		clusters           []runtime.Object
+		id                 string
+		expectedErr        bool
+		expectedActions    []string
+		expectedConditions int
+	}{
+		{
+			name:            "create the managed cluster",
+			id:              "id1",
+			expectedActions: []string{"create"},
+		},
+		{
+			name:            "update the labels of the managed cluster imported by the importer",
+			clusters:        []runtime.Object{newManagedCluster(importedBy(testRef))},
+			expectedActions: []string{"update"},
+		},
+		{
+			name:               "record the id of the cluster imported before",
+			clusters:           []runtime.Object{newManagedCluster(importedBy(testRef), imported)},
+			id:                 "id1",
+			expectedActions:    []string{"update"},
+			expectedConditions: 1,
+		},
+		{
+			name:               "keep the import of the same cluster",
+			clusters:           []runtime.Object{newManagedCluster(withID(importedBy(testRef), "id1"), imported)},
+			id:                 "id1",
+			expectedActions:    []string{"update"},
+			expectedConditions: 1,
+		},
+		{
+			name:            "reset the import of the recreated cluster",
+			clusters:        []runtime.Object{newManagedCluster(withID(importedBy(testRef), "id1"), imported)},
+			id:              "id2",
+			expectedActions: []string{"update", "update"},
+		},
+		{
+			name:        "do not take over the managed cluster created by others",
+			clusters:    []runtime.Object{newManagedCluster(nil)},
+			expectedErr: true,
+		},
+		{
+			name: "do not take over the managed cluster imported from another cluster",
+			clusters: []runtime.Object{newManagedCluster(importedBy(provider.ClusterRef{
+				Provider: "test", Namespace: "ns2", Name: "cluster1"}))},
+			expectedErr: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ctrl, clusterClient, _ := newTestController(t, &testProvider{}, c.clusters)
+			cluster, err := ctrl.ensureManagedCluster(context.TODO(), testRef, labels, c.id)
+			if c.expectedErr != (err != nil) {
+				t.Fatalf("expected error %v, but got %v", c.expectedErr, err)
+			}
+
+			var verbs []string
+			for _, action := range clusterClient.Actions() {
+				verbs = append(verbs, action.GetVerb())
+			}
+			if !reflect.DeepEqual(verbs, c.expectedActions) {
+				t.Errorf("expected actions %v, but got %v", c.expectedActions, verbs)
+			}
+			if err != nil {
+				return
+			}
+
+			if cluster.Annotations[provider.AnnotationClusterRef] != testRef.Key() {
+				t.Errorf("expected the managed cluster is annotated with %s, but got %v", testRef.Key(), cluster.Annotations)
+			}
+			if cluster.Labels[provider.LabelCloud] != provider.CloudAmazon {
+				t.Errorf("expected the labels %v are set, but got %v", labels, cluster.Labels)
+			}
+			if len(c.id) > 0 && cluster.Annotations[provider.AnnotationClusterID] != c.id {
+				t.Errorf("expected the id %s is recorded, but got %v", c.id, cluster.Annotations)
+			}
+			if len(cluster.Status.Conditions) != c.expectedConditions {
+				t.Errorf("expected %d conditions, but got %v", c.expectedConditions, cluster.Status.Conditions)
+			}
+		})
+	}
+}
+
+func TestEnsureManagedClusterLabels(t *testing.T) {
+	cluster := newManagedCluster(importedBy(testRef))
+	cluster.Labels = map[string]string{
+		provider.LabelCloud:              provider.CloudIBM,
+		provider.LabelRegion:             "dal10",
+		provider.LabelInfrastructureKind: "IBMPowerVSCluster",
+		"env":                            "prod",
+	}
+	ctrl, _, _ := newTestController(t, &testProvider{}, []runtime.Object{cluster})
+
+	// the region is not returned by the provider any more, the labels set by others are kept
+	cluster, err := ctrl.ensureManagedCluster(context.TODO(), testRef, map[string]string{
+		provider.LabelCloud:              provider.CloudIBM,
+		provider.LabelInfrastructureKind: "IBMPowerVSCluster",
+	}, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := map[string]string{
+		provider.LabelCloud:              provider.CloudIBM,
+		provider.LabelInfrastructureKind: "IBMPowerVSCluster",
+		"env":                            "prod",
+	}
+	if !reflect.DeepEqual(cluster.Labels, expected) {
+		t.Errorf("expected labels %v, but got %v", expected, cluster.Labels)
+	}
+}
+
+func TestDetach(t *testing.T) {
+	clusterNamespace := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   testRef.Name,
+			Labels: map[string]string{clusterv1.ClusterNameLabelKey: testRef.Name},
+		},
+	}
+	bootstrapSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "cluster1-bootstrap-kubeconfig", Namespace: testNamespace},
+	}
+
+	cases := []struct {
+		name            string
+		clusters        []runtime.Object
+		expectedDeleted bool
+	}{
+		{
+			name:            "detach the cluster imported by the importer",
+			clusters:        []runtime.Object{newManagedCluster(importedBy(testRef))},
+			expectedDeleted: true,
+		},
+		{
+			name:     "keep the managed cluster created by others",
+			clusters: []runtime.Object{newManagedCluster(nil)},
+		},
+		{
+			name:            "managed cluster is deleted already",
+			expectedDeleted: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ctrl, clusterClient, kubeClient := newTestController(t, &testProvider{deleted: true}, c.clusters,
+				clusterNamespace, bootstrapSecret)
+			err := ctrl.detach(context.TODO(), ctrl.providers["test"], testRef, events.NewInMemoryRecorder("test"))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			deletedClusters := filterActions(clusterClient.Actions(), "delete", "managedclusters")
+			deletedNamespaces := filterActions(kubeClient.Actions(), "delete", "namespaces")
+			deletedSecrets := filterActions(kubeClient.Actions(), "delete", "secrets")
+			if !c.expectedDeleted {
+				if len(deletedClusters)+len(deletedNamespaces)+len(deletedSecrets) > 0 {
+					t.Errorf("expected nothing is deleted, but got %v %v %v", deletedClusters, deletedNamespaces, deletedSecrets)
+				}
+				return
+			}
+
+			if len(deletedClusters) != len(c.clusters) {
+				t.Errorf("expected the managed cluster is deleted, but got %v", deletedClusters)
+			}
+			if len(deletedNamespaces) != 1 {
+				t.Errorf("expected the namespace of the cluster is deleted, but got %v", deletedNamespaces)
+			}
+			if _, err := kubeClient.CoreV1().Secrets(testNamespace).Get(
+				context.TODO(), bootstrapSecret.Name, metav1.GetOptions{}); !errors.IsNotFound(err) {
+				t.Errorf("expected the bootstrap kubeconfig is revoked, but got %v", err)
+			}
+		})
+	}
+}
+
+func TestSyncJoin(t *testing.T) {
+	now := time.Now()
+	applied := condition(conditionKlusterletApplied, metav1.ConditionTrue, "KlusterletApplied", now)
+	waiting := condition(conditionImported, metav1.ConditionFalse, reasonWaitingForJoin, now)
+	joinedConditions := []metav1.Condition{
+		condition(clusterv1.ManagedClusterConditionJoined, metav1.ConditionTrue, "Joined", now),
+		condition(clusterv1.ManagedClusterConditionAvailable, metav1.ConditionTrue, "Available", now),
+	}
+
+	cases := []struct {
+		name               string
+		conditions         []metav1.Condition
+		expectedErr        bool
+		expectedRequeue    bool
+		expectedConditions map[string]string
+	}{
+		{
+			name:            "wait for the agent to join the hub",
+			conditions:      []metav1.Condition{applied, waiting},
+			expectedRequeue: true,
+		},
+		{
+			name:       "agent joins the hub",
+			conditions: append([]metav1.Condition{applied, waiting}, joinedConditions...),
+			expectedConditions: map[string]string{
+				conditionImported:          reasonImportSucceed,
+				conditionKlusterletApplied: "KlusterletApplied",
+			},
+		},
+		{
+			name: "agent does not join the hub in time",
+			conditions: []metav1.Condition{
+				condition(conditionKlusterletApplied, metav1.ConditionTrue, "KlusterletApplied", now.Add(-joinTimeout-time.Minute)),
+				waiting,
+			},
+			expectedErr: true,
+			expectedConditions: map[string]string{
+				conditionImported:          reasonJoinTimeout,
+				conditionKlusterletApplied: reasonJoinTimeout,
+			},
+		},
+		{
+			name: "apply the klusterlet again after the join timeout",
+			conditions: []metav1.Condition{
+				condition(conditionKlusterletApplied, metav1.ConditionFalse, reasonJoinTimeout, now),
+				condition(conditionImported, metav1.ConditionFalse, reasonJoinTimeout, now),
+			},
+			expectedErr: true,
+			expectedConditions: map[string]string{
+				conditionImported:      reasonImportError,
+				"ImportSpokeConnected": "SpokeUnreachable",
+				// the conditions of the phases after the failed one are left as they are
+				conditionKlusterletApplied: reasonJoinTimeout,
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			p := &testProvider{kubeConfig: unreachableKubeConfig()}
+			ctrl, clusterClient, kubeClient := newTestController(t, p,
+				[]runtime.Object{newManagedCluster(importedBy(testRef), c.conditions...)})
+			withTokenRequests(kubeClient)
+			syncContext := newFakeSyncContext(testRef.Key())
+
+			err := ctrl.sync(context.TODO(), syncContext)
+			if c.expectedErr != (err != nil) {
+				t.Fatalf("expected error %t, but got %v", c.expectedErr, err)
+			}
+
+			_, requeued := syncContext.queue.requeued[testRef.Key()]
+			if requeued != c.expectedRequeue {
+				t.Errorf("expected requeued %t, but got %v", c.expectedRequeue, syncContext.queue.requeued)
+			}
+			if c.expectedRequeue && syncContext.queue.requeued[testRef.Key()] != joinPollInterval {
+				t.Errorf("expected requeued after %s, but got %v", joinPollInterval, syncContext.queue.requeued)
+			}
+
+			if len(c.expectedConditions) == 0 {
+				if updates := filterActions(clusterClient.Actions(), "update", "managedclusters"); len(updates) > 0 {
+					t.Errorf("expected the managed cluster is not updated, but got %v", updates)
+				}
+				return
+			}
+			cluster := updatedCluster(t, clusterClient)
+			for conditionType, reason := range c.expectedConditions {
+				if condition := meta.FindStatusCondition(cluster.Status.Conditions, conditionType); condition == nil || condition.Reason != reason {
+					t.Errorf("expected condition %s with reason %s, but got %v", conditionType, reason, condition)
+				}
+			}
+		})
+	}
+}
+
+func TestSyncRotation(t *testing.T) {
+	imported := condition(conditionImported, metav1.ConditionTrue, reasonImportSucceed, time.Now())
+	cachedKubeConfig := []byte(`
+apiVersion: v1
+kind: Config
+clusters:
+- name: hub
+  cluster:
+    server: https://hub.example.com:6443
+users:
+- name: bootstrap
+  user:
+    token: cached
+`)
+	cached := func(issuedAt time.Time) *corev1.Secret {
+		return &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "cluster1-bootstrap-kubeconfig",
+				Namespace: testNamespace,
+				Annotations: map[string]string{
+					"import.open-cluster-management.io/issued-at":  issuedAt.UTC().Format(time.RFC3339),
+					"import.open-cluster-management.io/expiration": issuedAt.Add(time.Hour).UTC().Format(time.RFC3339),
+				},
+			},
+			Data: map[string][]byte{"kubeconfig": cachedKubeConfig},
+		}
+	}
+
+	cases := []struct {
+		name            string
+		issuedAt        time.Time
+		kubeConfig      clientcmd.ClientConfig
+		expectedErr     bool
+		expectedRequeue bool
+		expectedIssued  bool
+	}{
+		{
+			name:            "bootstrap kubeconfig is not due to rotate",
+			issuedAt:        time.Now(),
+			kubeConfig:      unreachableKubeConfig(),
+			expectedRequeue: true,
+		},
+		{
+			name:     "kubeconfig of the cluster is not available",
+			issuedAt: time.Now().Add(-50 * time.Minute),
+		},
+		{
+			name:           "rotated bootstrap kubeconfig is not delivered",
+			issuedAt:       time.Now().Add(-50 * time.Minute),
+			kubeConfig:     unreachableKubeConfig(),
+			expectedErr:    true,
+			expectedIssued: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			secret := cached(c.issuedAt)
+			p := &testProvider{kubeConfig: c.kubeConfig}
+			ctrl, _, kubeClient := newTestController(t, p,
+				[]runtime.Object{newManagedCluster(importedBy(testRef), imported)}, secret)
+			withTokenRequests(kubeClient)
+			syncContext := newFakeSyncContext(testRef.Key())
+
+			err := ctrl.sync(context.TODO(), syncContext)
+			if c.expectedErr != (err != nil) {
+				t.Fatalf("expected error %t, but got %v", c.expectedErr, err)
+			}
+
+			wait, requeued := syncContext.queue.requeued[testRef.Key()]
+			if requeued != c.expectedRequeue {
+				t.Errorf("expected requeued %t, but got %v", c.expectedRequeue, syncContext.queue.requeued)
+			}
+			// the kubeconfig is rotated at 0.8 of its lifetime
+			if requeued && (wait <= 40*time.Minute || wait > 48*time.Minute) {
+				t.Errorf("expected requeued before the rotation, but got %s", wait)
+			}
+
+			issued := len(filterActions(kubeClient.Actions(), "create", "serviceaccounts")) > 0
+			if issued != c.expectedIssued {
+				t.Errorf("expected issued %t, but got %t", c.expectedIssued, issued)
+			}
+
+			// the cached kubeconfig is kept until the rotated one is delivered
+			current, err := kubeClient.CoreV1().Secrets(testNamespace).Get(context.TODO(), secret.Name, metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(current, secret) {
+				t.Errorf("expected the cached kubeconfig is not changed, but got %v", current)
+			}
+		})
+	}
+}
+
+func TestDetachUnreachableSpoke(t *testing.T) {
+	cases := []struct {
+		name          string
+		code          int
+		reason        metav1.StatusReason
+		expectedError bool
+	}{
+		{
+			name:   "credentials of the spoke are revoked",
+			code:   http.StatusUnauthorized,
+			reason: metav1.StatusReasonUnauthorized,
+		},
+		{
+			name:   "permissions on the spoke are revoked",
+			code:   http.StatusForbidden,
+			reason: metav1.StatusReasonForbidden,
+		},
+		{
+			name:          "spoke fails the request",
+			code:          http.StatusConflict,
+			reason:        metav1.StatusReasonConflict,
+			expectedError: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			spoke := newFakeAPIServer(t, c.code, c.reason)
+			p := &testProvider{deleted: true, kubeConfig: kubeConfigOf(spoke.URL)}
+			ctrl, clusterClient, _ := newTestController(t, p, []runtime.Object{newManagedCluster(importedBy(testRef))})
+			err := ctrl.detach(context.TODO(), p, testRef, events.NewInMemoryRecorder("test"))
+			if c.expectedError {
+				if err == nil {
+					t.Errorf("expected the detach is retried")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(spoke.requests) == 0 {
+				t.Errorf("expected the klusterlet is cleaned up on the spoke")
+			}
+			if deleted := filterActions(clusterClient.Actions(), "delete", "managedclusters"); len(deleted) != 1 {
+				t.Errorf("expected the managed cluster is deleted, but got %v", deleted)
+			}
+		})
+	}
+}
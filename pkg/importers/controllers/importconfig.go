@@ -0,0 +1,248 @@
+package controllers
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	v1alpha1 "github.com/qiujian16/capi-importer/pkg/apis/v1alpha1"
+	"github.com/qiujian16/capi-importer/pkg/join"
+	"github.com/qiujian16/capi-importer/pkg/provider"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	clusterv1 "open-cluster-management.io/api/cluster/v1"
+	operatorv1 "open-cluster-management.io/api/operator/v1"
+)
+
+// importConfigQueueKeys requeues the clusters which are not imported yet when an ImportConfig
+// changes, the clusters imported already keep the klusterlet they are imported with.
+func (n *controller) importConfigQueueKeys(_ runtime.Object) []string {
+	clusters, err := n.clusterLister.List(labels.Everything())
+	if err != nil {
+		return []string{}
+	}
+
+	keys := []string{}
+	for _, cluster := range clusters {
+		if meta.IsStatusConditionTrue(cluster.Status.Conditions, conditionImported) {
+			continue
+		}
+		keys = append(keys, managedClusterQueueKeys(cluster)...)
+	}
+	return keys
+}
+
+// importConfig returns the ImportConfig of the cluster, or nil if the cluster has none. The labels
+// and annotations of both the source cluster and the ManagedCluster are used to select the config.
+func (n *controller) importConfig(
+	p provider.ClusterProvider, ref provider.ClusterRef, cluster *clusterv1.ManagedCluster) (*v1alpha1.ImportConfig, error) {
+	if n.configLister == nil {
+		return nil, nil
+	}
+
+	var objs []metav1.Object
+	if g, ok := p.(provider.ObjectGetter); ok {
+		obj, err := g.Object(ref)
+		switch {
+		case errors.IsNotFound(err):
+		case err != nil:
+			return nil, err
+		default:
+			objs = append(objs, obj)
+		}
+	}
+	if cluster != nil {
+		objs = append(objs, cluster)
+	}
+
+	list, err := n.configLister.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	configs := make([]*v1alpha1.ImportConfig, 0, len(list))
+	for _, obj := range list {
+		u, ok := obj.(*unstructured.Unstructured)
+		if !ok {
+			return nil, fmt.Errorf("import config %T is not an unstructured object", obj)
+		}
+		config := &v1alpha1.ImportConfig{}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, config); err != nil {
+			return nil, fmt.Errorf("invalid import config %s: %v", u.GetName(), err)
+		}
+		configs = append(configs, config)
+	}
+
+	config, err := selectImportConfig(configs, objs...)
+	if err != nil || config == nil {
+		return config, err
+	}
+	if err := validateImages(config.Spec.Images); err != nil {
+		return nil, fmt.Errorf("invalid import config %s: %v", config.Name, err)
+	}
+	return config, nil
+}
+
+// validateImages rejects a digest in the version shared by all the images, the digest of an image
+// does not match the other images.
+func validateImages(images *v1alpha1.Images) error {
+	if images == nil {
+		return nil
+	}
+	if strings.ContainsAny(images.Version, ":@") {
+		return fmt.Errorf("images version %q is not a tag, set the digests in the pull specs of the images", images.Version)
+	}
+	return nil
+}
+
+// selectImportConfig picks the config named by the annotation of the objects at first, then the
+// first config in the order of the names whose selector matches the labels of any object, and the
+// default config at last.
+func selectImportConfig(configs []*v1alpha1.ImportConfig, objs ...metav1.Object) (*v1alpha1.ImportConfig, error) {
+	for _, obj := range objs {
+		name, ok := obj.GetAnnotations()[v1alpha1.AnnotationImportConfig]
+		if !ok {
+			continue
+		}
+		for _, config := range configs {
+			if config.Name == name {
+				return config, nil
+			}
+		}
+		return nil, fmt.Errorf("import config %s of cluster %s is not found", name, obj.GetName())
+	}
+
+	sort.Slice(configs, func(i, j int) bool {
+		return configs[i].Name < configs[j].Name
+	})
+
+	var defaultConfig *v1alpha1.ImportConfig
+	for _, config := range configs {
+		if config.Name == v1alpha1.DefaultImportConfigName {
+			defaultConfig = config
+			continue
+		}
+		if config.Spec.ClusterSelector == nil {
+			continue
+		}
+		selector, err := metav1.LabelSelectorAsSelector(config.Spec.ClusterSelector)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cluster selector of import config %s: %v", config.Name, err)
+		}
+		for _, obj := range objs {
+			if selector.Matches(labels.Set(obj.GetLabels())) {
+				return config, nil
+			}
+		}
+	}
+	return defaultConfig, nil
+}
+
+// klusterletConfigOf returns the klusterlet config of the cluster, the providers hosting the
+// klusterlets of their clusters override the mode and the hosting cluster of the importer.
+func (n *controller) klusterletConfigOf(p provider.ClusterProvider, ref provider.ClusterRef) KlusterletConfig {
+	h, ok := p.(provider.KlusterletHost)
+	if !ok {
+		return n.klusterletConfig
+	}
+	mode, hostingKubeConfig := h.HostedKlusterlet(ref)
+	return KlusterletConfig{Mode: string(mode), HostingKubeConfig: hostingKubeConfig}
+}
+
+// klusterletValues returns the values of the klusterlet shared by import and detach, the settings
+// in the ImportConfig of the cluster override the klusterlet config.
+func (n *controller) klusterletValues(
+	clusterName string, klusterletConfig KlusterletConfig, config *v1alpha1.ImportConfig) join.Values {
+	values := join.Values{
+		ClusterName:    clusterName,
+		AgentNamespace: agentNamespace,
+		Klusterlet: join.Klusterlet{
+			Name: klusterletName,
+			Mode: klusterletConfig.Mode,
+		},
+		Images:               n.imageConfig.images(nil),
+		RegistrationFeatures: []operatorv1.FeatureGate{},
+		WorkFeatures:         []operatorv1.FeatureGate{},
+	}
+
+	if config != nil {
+		spec := config.Spec
+		if len(spec.AgentNamespace) > 0 {
+			values.AgentNamespace = spec.AgentNamespace
+		}
+		if len(spec.KlusterletName) > 0 {
+			values.Klusterlet.Name = spec.KlusterletName
+		}
+		if len(spec.Mode) > 0 {
+			values.Klusterlet.Mode = string(spec.Mode)
+		}
+		if spec.RegistrationFeatureGates != nil {
+			values.RegistrationFeatures = spec.RegistrationFeatureGates
+		}
+		if spec.WorkFeatureGates != nil {
+			values.WorkFeatures = spec.WorkFeatureGates
+		}
+		values.Images = n.imageConfig.images(spec.Images)
+	}
+
+	// klusterlets of the clusters share the same hosting cluster in the hosted modes
+	if values.Klusterlet.Hosted() {
+		values.Klusterlet.Name = fmt.Sprintf("%s-%s", values.Klusterlet.Name, clusterName)
+	}
+	return values
+}
+
+// bootstrapConfigOf returns the bootstrap config of the cluster, with the hub apiserver in the
+// ImportConfig if it is set.
+func (n *controller) bootstrapConfigOf(config *v1alpha1.ImportConfig) join.BootstrapConfig {
+	bootstrapConfig := n.bootstrapConfig
+	if config != nil && len(config.Spec.HubAPIServer) > 0 {
+		bootstrapConfig.HubAPIServer = config.Spec.HubAPIServer
+	}
+	return bootstrapConfig
+}
+
+// pullSecret returns the namespace/name of the image pull secret of the cluster
+func (n *controller) pullSecret(config *v1alpha1.ImportConfig) string {
+	if config != nil && config.Spec.Images != nil && len(config.Spec.Images.PullSecret) > 0 {
+		return config.Spec.Images.PullSecret
+	}
+	return n.imageConfig.PullSecret
+}
+
+// images returns the pull specs of the images. The registry or the version set in the ImportConfig
+// takes precedence over the full pull specs of the flags, and the full pull specs set in the
+// ImportConfig take precedence over everything else.
+func (c ImageConfig) images(config *v1alpha1.Images) join.Images {
+	if config == nil {
+		return join.ComposeImages(c.Registry, c.Versions, c.Images)
+	}
+
+	registry, versions, overrides := c.Registry, c.Versions, c.Images
+	if len(config.Registry) > 0 || len(config.Version) > 0 {
+		overrides = join.Images{}
+	}
+	if len(config.Registry) > 0 {
+		registry = config.Registry
+	}
+	if len(config.Version) > 0 {
+		versions = join.ImageVersions{
+			Registration: config.Version,
+			Work:         config.Version,
+			Operator:     config.Version,
+		}
+	}
+	if len(config.Registration) > 0 {
+		overrides.Registration = config.Registration
+	}
+	if len(config.Work) > 0 {
+		overrides.Work = config.Work
+	}
+	if len(config.Operator) > 0 {
+		overrides.Operator = config.Operator
+	}
+	return join.ComposeImages(registry, versions, overrides)
+}
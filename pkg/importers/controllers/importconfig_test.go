@@ -0,0 +1,217 @@
+package controllers
+
+import (
+	"reflect"
+	"testing"
+
+	v1alpha1 "github.com/qiujian16/capi-importer/pkg/apis/v1alpha1"
+	"github.com/qiujian16/capi-importer/pkg/join"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newImportConfig(name string, selector *metav1.LabelSelector) *v1alpha1.ImportConfig {
+	return &v1alpha1.ImportConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec:       v1alpha1.ImportConfigSpec{ClusterSelector: selector},
+	}
+}
+
+func newObject(labels, annotations map[string]string) metav1.Object {
+	return &metav1.ObjectMeta{Name: "cluster1", Labels: labels, Annotations: annotations}
+}
+
+func TestSelectImportConfig(t *testing.T) {
+	cases := []struct {
+		name        string
+		configs     []*v1alpha1.ImportConfig
+		objs        []metav1.Object
+		expected    string
+		expectedErr bool
+	}{
+		{
+			name: "no config",
+			objs: []metav1.Object{newObject(nil, nil)},
+		},
+		{
+			name: "default config",
+			configs: []*v1alpha1.ImportConfig{
+				newImportConfig("default", nil),
+				newImportConfig("aws", &metav1.LabelSelector{MatchLabels: map[string]string{"cloud": "Amazon"}}),
+			},
+			objs:     []metav1.Object{newObject(map[string]string{"cloud": "Azure"}, nil)},
+			expected: "default",
+		},
+		{
+			name: "selected by labels",
+			configs: []*v1alpha1.ImportConfig{
+				newImportConfig("default", nil),
+				newImportConfig("aws", &metav1.LabelSelector{MatchLabels: map[string]string{"cloud": "Amazon"}}),
+			},
+			objs: []metav1.Object{
+				newObject(nil, nil),
+				newObject(map[string]string{"cloud": "Amazon"}, nil),
+			},
+			expected: "aws",
+		},
+		{
+			name: "first matching config by name",
+			configs: []*v1alpha1.ImportConfig{
+				newImportConfig("b", &metav1.LabelSelector{}),
+				newImportConfig("a", &metav1.LabelSelector{}),
+			},
+			objs:     []metav1.Object{newObject(nil, nil)},
+			expected: "a",
+		},
+		{
+			name: "selected by annotation",
+			configs: []*v1alpha1.ImportConfig{
+				newImportConfig("aws", &metav1.LabelSelector{MatchLabels: map[string]string{"cloud": "Amazon"}}),
+				newImportConfig("team1", nil),
+			},
+			objs: []metav1.Object{
+				newObject(map[string]string{"cloud": "Amazon"}, map[string]string{v1alpha1.AnnotationImportConfig: "team1"}),
+			},
+			expected: "team1",
+		},
+		{
+			name:    "annotated config not found",
+			configs: []*v1alpha1.ImportConfig{newImportConfig("default", nil)},
+			objs: []metav1.Object{
+				newObject(nil, map[string]string{v1alpha1.AnnotationImportConfig: "team1"}),
+			},
+			expectedErr: true,
+		},
+		{
+			name: "invalid selector",
+			configs: []*v1alpha1.ImportConfig{
+				newImportConfig("invalid", &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+					{Key: "cloud", Operator: "Unknown"},
+				}}),
+			},
+			objs:        []metav1.Object{newObject(nil, nil)},
+			expectedErr: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			config, err := selectImportConfig(c.configs, c.objs...)
+			if c.expectedErr {
+				if err == nil {
+					t.Errorf("expected error, but got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			name := ""
+			if config != nil {
+				name = config.Name
+			}
+			if name != c.expected {
+				t.Errorf("expected config %q, but got %q", c.expected, name)
+			}
+		})
+	}
+}
+
+func TestImageConfigImages(t *testing.T) {
+	imageConfig := ImageConfig{
+		Registry: "quay.io/open-cluster-management",
+		Versions: join.ImageVersions{
+			Registration: "v0.12.0",
+			Work:         "v0.12.0",
+			Operator:     "v0.12.0",
+		},
+		Images: join.Images{
+			Work: "example.com/work:dev",
+		},
+	}
+
+	cases := []struct {
+		name     string
+		config   *v1alpha1.Images
+		expected join.Images
+	}{
+		{
+			name: "flags",
+			expected: join.Images{
+				Registration: "quay.io/open-cluster-management/registration:v0.12.0",
+				Work:         "example.com/work:dev",
+				Operator:     "quay.io/open-cluster-management/registration-operator:v0.12.0",
+			},
+		},
+		{
+			name:   "registry of the config",
+			config: &v1alpha1.Images{Registry: "mirror.example.com/ocm"},
+			expected: join.Images{
+				Registration: "mirror.example.com/ocm/registration:v0.12.0",
+				Work:         "mirror.example.com/ocm/work:v0.12.0",
+				Operator:     "mirror.example.com/ocm/registration-operator:v0.12.0",
+			},
+		},
+		{
+			name:   "version and image of the config",
+			config: &v1alpha1.Images{Version: "v0.13.0", Operator: "example.com/operator:dev"},
+			expected: join.Images{
+				Registration: "quay.io/open-cluster-management/registration:v0.13.0",
+				Work:         "quay.io/open-cluster-management/work:v0.13.0",
+				Operator:     "example.com/operator:dev",
+			},
+		},
+		{
+			name:   "only the pull secret in the config",
+			config: &v1alpha1.Images{PullSecret: "ns1/pull-secret"},
+			expected: join.Images{
+				Registration: "quay.io/open-cluster-management/registration:v0.12.0",
+				Work:         "example.com/work:dev",
+				Operator:     "quay.io/open-cluster-management/registration-operator:v0.12.0",
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			images := imageConfig.images(c.config)
+			if !reflect.DeepEqual(images, c.expected) {
+				t.Errorf("expected %#v, but got %#v", c.expected, images)
+			}
+		})
+	}
+}
+
+func TestValidateImages(t *testing.T) {
+	cases := []struct {
+		name        string
+		images      *v1alpha1.Images
+		expectedErr bool
+	}{
+		{
+			name: "no images",
+		},
+		{
+			name:   "tag",
+			images: &v1alpha1.Images{Version: "v0.13.0"},
+		},
+		{
+			name:   "digests of the images",
+			images: &v1alpha1.Images{Registration: "quay.io/ocm/registration@sha256:abc", Work: "quay.io/ocm/work@sha256:def"},
+		},
+		{
+			name:        "digest shared by the images",
+			images:      &v1alpha1.Images{Version: "sha256:abc"},
+			expectedErr: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := validateImages(c.images)
+			if c.expectedErr != (err != nil) {
+				t.Errorf("expected error %v, but got %v", c.expectedErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/openshift/library-go/pkg/operator/resource/resourcemerge"
+	v1alpha1 "github.com/qiujian16/capi-importer/pkg/apis/v1alpha1"
+	"github.com/qiujian16/capi-importer/pkg/join"
+	"github.com/qiujian16/capi-importer/pkg/provider"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/clientcmd"
+	clusterv1 "open-cluster-management.io/api/cluster/v1"
+)
+
+const (
+	// annotationKlusterletMode records the install mode of the klusterlet applied for the cluster
+	annotationKlusterletMode = "import.open-cluster-management.io/klusterlet-mode"
+	// annotationKlusterletName records the name of the klusterlet applied for the cluster
+	annotationKlusterletName = "import.open-cluster-management.io/klusterlet-name"
+	// annotationAgentNamespace records the namespace of the agent applied for the cluster
+	annotationAgentNamespace = "import.open-cluster-management.io/agent-namespace"
+	// annotationHostingCluster records the apiserver of the cluster hosting the klusterlet in the
+	// hosted modes.
+	annotationHostingCluster = "import.open-cluster-management.io/hosting-cluster"
+)
+
+// recordKlusterlet records the klusterlet on the ManagedCluster before it is applied, so the klusterlet
+// is found by the later syncs and the detach even if the ImportConfig or the klusterlet config of the
+// importer is changed after the import.
+func (n *controller) recordKlusterlet(ctx context.Context, cluster *clusterv1.ManagedCluster,
+	klusterletConfig KlusterletConfig, values join.Values) (*clusterv1.ManagedCluster, error) {
+	record := map[string]string{
+		annotationKlusterletMode: values.Klusterlet.Mode,
+		annotationKlusterletName: values.Klusterlet.Name,
+		annotationAgentNamespace: values.AgentNamespace,
+	}
+	if values.Klusterlet.Hosted() {
+		server, err := hostingServer(klusterletConfig.HostingKubeConfig)
+		if err != nil {
+			return nil, err
+		}
+		record[annotationHostingCluster] = server
+	}
+
+	modified := false
+	cluster = cluster.DeepCopy()
+	if _, ok := cluster.Annotations[annotationHostingCluster]; ok && !values.Klusterlet.Hosted() {
+		delete(cluster.Annotations, annotationHostingCluster)
+		modified = true
+	}
+	resourcemerge.MergeMap(&modified, &cluster.Annotations, record)
+	if !modified {
+		return cluster, nil
+	}
+	return n.clusterClient.ClusterV1().ManagedClusters().Update(ctx, cluster, metav1.UpdateOptions{})
+}
+
+// recordedKlusterlet returns the klusterlet config and the values of the klusterlet recorded on the
+// ManagedCluster. The klusterlet of the cluster imported before it is recorded is built from the
+// current configs. An error is returned if the recorded hosting cluster is not configured any more.
+func (n *controller) recordedKlusterlet(p provider.ClusterProvider, ref provider.ClusterRef,
+	cluster *clusterv1.ManagedCluster, config *v1alpha1.ImportConfig) (KlusterletConfig, join.Values, error) {
+	klusterletConfig := n.klusterletConfigOf(p, ref)
+	values := n.klusterletValues(ref.Name, klusterletConfig, config)
+	if cluster == nil {
+		return klusterletConfig, values, nil
+	}
+	mode, ok := cluster.Annotations[annotationKlusterletMode]
+	if !ok {
+		return klusterletConfig, values, nil
+	}
+
+	values.Klusterlet.Mode = mode
+	values.Klusterlet.Name = cluster.Annotations[annotationKlusterletName]
+	values.AgentNamespace = cluster.Annotations[annotationAgentNamespace]
+	if !values.Klusterlet.Hosted() {
+		return KlusterletConfig{Mode: mode}, values, nil
+	}
+
+	hostingKubeConfig, err := n.hostingKubeConfig(p, ref, cluster.Annotations[annotationHostingCluster])
+	if err != nil {
+		return klusterletConfig, values, err
+	}
+	return KlusterletConfig{Mode: mode, HostingKubeConfig: hostingKubeConfig}, values, nil
+}
+
+// hostingKubeConfig returns the kubeconfig of the hosting cluster with the apiserver, among the
+// hosting clusters of the provider and the importer.
+func (n *controller) hostingKubeConfig(
+	p provider.ClusterProvider, ref provider.ClusterRef, server string) (clientcmd.ClientConfig, error) {
+	candidates := []clientcmd.ClientConfig{n.klusterletConfig.HostingKubeConfig}
+	if h, ok := p.(provider.KlusterletHost); ok {
+		_, hostingKubeConfig := h.HostedKlusterlet(ref)
+		candidates = append([]clientcmd.ClientConfig{hostingKubeConfig}, candidates...)
+	}
+
+	for _, candidate := range candidates {
+		if candidate == nil {
+			continue
+		}
+		candidateServer, err := hostingServer(candidate)
+		if err != nil {
+			return nil, err
+		}
+		if candidateServer == server {
+			return candidate, nil
+		}
+	}
+	return nil, fmt.Errorf("hosting cluster %q of the klusterlet of cluster %s is not configured", server, ref.Name)
+}
+
+// hostingServer returns the apiserver of the hosting cluster, which identifies the hosting cluster
+// in the record of the klusterlet.
+func hostingServer(hostingKubeConfig clientcmd.ClientConfig) (string, error) {
+	if hostingKubeConfig == nil {
+		return "", nil
+	}
+	config, err := hostingKubeConfig.ClientConfig()
+	if err != nil {
+		return "", err
+	}
+	return config.Host, nil
+}
@@ -0,0 +1,111 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/openshift/library-go/pkg/operator/events"
+	"github.com/qiujian16/capi-importer/pkg/provider"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clienttesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/clientcmd"
+	clusterv1 "open-cluster-management.io/api/cluster/v1"
+	operatorv1 "open-cluster-management.io/api/operator/v1"
+)
+
+// hostedTestProvider is a test provider whose klusterlets are deployed on a hosting cluster
+type hostedTestProvider struct {
+	testProvider
+	mode              operatorv1.InstallMode
+	hostingKubeConfig clientcmd.ClientConfig
+}
+
+func (p *hostedTestProvider) HostedKlusterlet(_ provider.ClusterRef) (operatorv1.InstallMode, clientcmd.ClientConfig) {
+	return p.mode, p.hostingKubeConfig
+}
+
+func TestHostedKlusterlet(t *testing.T) {
+	hosting := newFakeAPIServer(t, http.StatusNotFound, metav1.StatusReasonNotFound)
+	p := &hostedTestProvider{
+		testProvider:      testProvider{kubeConfig: unreachableKubeConfig()},
+		mode:              operatorv1.InstallModeHosted,
+		hostingKubeConfig: kubeConfigOf(hosting.URL),
+	}
+	klusterletPath := "/apis/operator.open-cluster-management.io/v1/klusterlets/klusterlet-cluster1"
+	expectedRecord := map[string]string{
+		annotationKlusterletMode: string(operatorv1.InstallModeHosted),
+		annotationKlusterletName: "klusterlet-cluster1",
+		annotationAgentNamespace: agentNamespace,
+		annotationHostingCluster: hosting.URL,
+	}
+
+	// the klusterlet is recorded before it is applied, the apply fails on the fake hosting cluster
+	ctrl, clusterClient, kubeClient := newTestController(t, p, []runtime.Object{newManagedCluster(importedBy(testRef))})
+	withTokenRequests(kubeClient)
+	_ = ctrl.sync(context.TODO(), newFakeSyncContext(testRef.Key()))
+
+	var recorded *clusterv1.ManagedCluster
+	for _, action := range filterActions(clusterClient.Actions(), "update", "managedclusters") {
+		if action.GetSubresource() == "" {
+			recorded = action.(clienttesting.UpdateAction).GetObject().(*clusterv1.ManagedCluster)
+		}
+	}
+	if recorded == nil {
+		t.Fatalf("expected the klusterlet is recorded on the managed cluster")
+	}
+	for key, value := range expectedRecord {
+		if recorded.Annotations[key] != value {
+			t.Errorf("expected annotation %s=%s, but got %v", key, value, recorded.Annotations)
+		}
+	}
+
+	// the klusterlet of the provider is not hosted any more after the import, the recorded
+	// klusterlet on the hosting cluster is still checked after the join timeout
+	p.mode = operatorv1.InstallModeDefault
+	hosting.reset()
+	applied := condition(conditionKlusterletApplied, metav1.ConditionTrue, "KlusterletApplied", time.Now().Add(-joinTimeout-time.Minute))
+	ctrl, _, _ = newTestController(t, p, []runtime.Object{newManagedCluster(recorded.Annotations, applied)})
+	if err := ctrl.sync(context.TODO(), newFakeSyncContext(testRef.Key())); err == nil {
+		t.Errorf("expected the join timeout error")
+	}
+	if !hosting.requested("GET " + klusterletPath) {
+		t.Errorf("expected the recorded klusterlet is checked on the hosting cluster")
+	}
+
+	// the recorded klusterlet is deleted from the hosting cluster
+	p.deleted = true
+	hosting.reset()
+	ctrl, _, _ = newTestController(t, p, []runtime.Object{newManagedCluster(recorded.Annotations)})
+	if err := ctrl.detach(context.TODO(), p, testRef, events.NewInMemoryRecorder("test")); err != nil {
+		t.Fatal(err)
+	}
+	if !hosting.requested("DELETE " + klusterletPath) {
+		t.Errorf("expected the recorded klusterlet is deleted from the hosting cluster")
+	}
+
+	// the cleanup is skipped if the recorded hosting cluster is not configured any more, the
+	// managed cluster is still detached
+	p.hostingKubeConfig = unreachableKubeConfig()
+	hosting.reset()
+	ctrl, clusterClient, _ = newTestController(t, p, []runtime.Object{newManagedCluster(recorded.Annotations)})
+	recorder := events.NewInMemoryRecorder("test")
+	if err := ctrl.detach(context.TODO(), p, testRef, recorder); err != nil {
+		t.Fatal(err)
+	}
+	skipped := false
+	for _, event := range recorder.Events() {
+		skipped = skipped || event.Reason == "KlusterletNotDetached"
+	}
+	if !skipped {
+		t.Errorf("expected the cleanup of the klusterlet is skipped, but got events %v", recorder.Events())
+	}
+	if len(hosting.requests) > 0 {
+		t.Errorf("expected no requests to the hosting cluster, but got %v", hosting.requests)
+	}
+	if deleted := filterActions(clusterClient.Actions(), "delete", "managedclusters"); len(deleted) != 1 {
+		t.Errorf("expected the managed cluster is deleted, but got %v", deleted)
+	}
+}
@@ -0,0 +1,227 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/qiujian16/capi-importer/pkg/join"
+	"github.com/qiujian16/capi-importer/pkg/provider"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clusterv1 "open-cluster-management.io/api/cluster/v1"
+)
+
+const (
+	conditionImported = "Imported"
+
+	reasonImportSucceed = "ImportSucceed"
+	reasonImportError   = "ImportError"
+
+	// reasonWaitingForJoin is the reason of the Imported condition when the klusterlet is applied
+	// and the importer is waiting for the agent to join the hub.
+	reasonWaitingForJoin = "WaitingForJoin"
+
+	// reasonJoinTimeout is the reason when the agent does not join the hub in time after the
+	// klusterlet is applied, the klusterlet is then applied again.
+	reasonJoinTimeout = "JoinTimeout"
+
+	// reasonAgentNotRegistered is the reason of the import status when the klusterlet is applied but
+	// the agent has not joined the hub yet.
+	reasonAgentNotRegistered = "AgentNotRegistered"
+
+	conditionKlusterletApplied = "ImportKlusterletApplied"
+
+	// phaseCredentialsFetched is the phase to fetch the kubeconfig of the cluster from the provider
+	phaseCredentialsFetched join.Phase = "CredentialsFetched"
+)
+
+// importPhase is a phase of the import, reported as a condition of the ManagedCluster with stable reasons
+type importPhase struct {
+	phase           join.Phase
+	condition       string
+	succeededReason string
+	failedReason    string
+}
+
+// importPhases is the phases of the import in order, the agent joining the hub is reported by the
+// ManagedClusterJoined condition set by the hub.
+var importPhases = []importPhase{
+	{
+		phase:           phaseCredentialsFetched,
+		condition:       "ImportCredentialsFetched",
+		succeededReason: "KubeConfigFetched",
+		failedReason:    "KubeConfigFetchFailed",
+	},
+	{
+		phase:           join.PhaseSpokeConnected,
+		condition:       "ImportSpokeConnected",
+		succeededReason: "SpokeReachable",
+		failedReason:    "SpokeUnreachable",
+	},
+	{
+		phase:           join.PhaseCRDApplied,
+		condition:       "ImportCRDApplied",
+		succeededReason: "CRDApplied",
+		failedReason:    "CRDApplyFailed",
+	},
+	{
+		phase:           join.PhaseManifestsApplied,
+		condition:       "ImportManifestsApplied",
+		succeededReason: "ManifestsApplied",
+		failedReason:    "ManifestsApplyFailed",
+	},
+	{
+		phase:           join.PhaseOperatorDeployed,
+		condition:       "ImportOperatorDeployed",
+		succeededReason: "OperatorDeployed",
+		failedReason:    "OperatorDeployFailed",
+	},
+	{
+		phase:           join.PhaseKlusterletApplied,
+		condition:       conditionKlusterletApplied,
+		succeededReason: "KlusterletApplied",
+		failedReason:    "KlusterletApplyFailed",
+	},
+}
+
+// setImportConditions sets the conditions of the import phases and the Imported condition from the
+// error of the import. The phases before the failed one are succeeded, and the conditions of the
+// phases after it are left as they are. The cluster is not imported until the agent joins the hub,
+// even if all the phases succeed.
+func setImportConditions(conditions *[]metav1.Condition, generation int64, err error) {
+	failed := join.Phase("")
+	var phaseErr *join.PhaseError
+	if errors.As(err, &phaseErr) {
+		failed = phaseErr.Phase
+	}
+
+	for _, p := range importPhases {
+		condition := metav1.Condition{
+			Type:               p.condition,
+			Status:             metav1.ConditionTrue,
+			Reason:             p.succeededReason,
+			Message:            fmt.Sprintf("%s succeeds", p.phase),
+			ObservedGeneration: generation,
+		}
+		if p.phase == failed {
+			condition.Status = metav1.ConditionFalse
+			condition.Reason = p.failedReason
+			condition.Message = phaseErr.Err.Error()
+			meta.SetStatusCondition(conditions, condition)
+			break
+		}
+		// the errors not in any phase happen on the hub after the credentials are fetched, e.g.
+		// the bootstrap kubeconfig or the values of the klusterlet are invalid, the phases on the
+		// spoke are not started.
+		if err != nil && failed == "" && p.phase != phaseCredentialsFetched {
+			break
+		}
+		meta.SetStatusCondition(conditions, condition)
+	}
+
+	imported := metav1.Condition{
+		Type:               conditionImported,
+		Status:             metav1.ConditionFalse,
+		Reason:             reasonWaitingForJoin,
+		Message:            "Klusterlet is applied, waiting for the agent to join the hub",
+		ObservedGeneration: generation,
+	}
+	if err != nil {
+		imported.Reason = reasonImportError
+		imported.Message = fmt.Sprintf("Failed to import with err %v", err)
+	}
+	meta.SetStatusCondition(conditions, imported)
+}
+
+// joined returns true when the agent joins the hub and the cluster is available
+func joined(conditions []metav1.Condition) bool {
+	return meta.IsStatusConditionTrue(conditions, clusterv1.ManagedClusterConditionJoined) &&
+		meta.IsStatusConditionTrue(conditions, clusterv1.ManagedClusterConditionAvailable)
+}
+
+// setJoinConditions sets the Imported condition once the agent joins the hub. If the agent does
+// not join in time, the klusterlet is marked as not applied with the conditions of the klusterlet
+// on the spoke, so it is applied again.
+func setJoinConditions(conditions *[]metav1.Condition, generation int64, timeout bool, klusterletMessage string) {
+	switch {
+	case joined(*conditions):
+		meta.SetStatusCondition(conditions, metav1.Condition{
+			Type:               conditionImported,
+			Status:             metav1.ConditionTrue,
+			Reason:             reasonImportSucceed,
+			Message:            "Import succeeds",
+			ObservedGeneration: generation,
+		})
+	case timeout:
+		message := fmt.Sprintf("The agent does not join the hub in %s: %s", joinTimeout, klusterletMessage)
+		for _, conditionType := range []string{conditionKlusterletApplied, conditionImported} {
+			meta.SetStatusCondition(conditions, metav1.Condition{
+				Type:               conditionType,
+				Status:             metav1.ConditionFalse,
+				Reason:             reasonJoinTimeout,
+				Message:            message,
+				ObservedGeneration: generation,
+			})
+		}
+	}
+}
+
+// formatConditions formats the conditions of the klusterlet into the message of a condition
+func formatConditions(conditions []metav1.Condition) string {
+	if len(conditions) == 0 {
+		return "klusterlet has no conditions"
+	}
+	messages := make([]string, 0, len(conditions))
+	for _, c := range conditions {
+		messages = append(messages, fmt.Sprintf("%s=%s (%s: %s)", c.Type, c.Status, c.Reason, c.Message))
+	}
+	return strings.Join(messages, "; ")
+}
+
+// importStatus returns the status of the import mirrored to the source cluster, which is the
+// condition of the first phase not succeeded yet.
+func importStatus(conditions []metav1.Condition) provider.ImportStatus {
+	for _, p := range importPhases {
+		condition := meta.FindStatusCondition(conditions, p.condition)
+		if condition == nil {
+			return provider.ImportStatus{Phase: string(p.phase)}
+		}
+		if condition.Status != metav1.ConditionTrue {
+			return provider.ImportStatus{Phase: string(p.phase), Reason: condition.Reason, Message: condition.Message}
+		}
+	}
+
+	status := provider.ImportStatus{Phase: clusterv1.ManagedClusterConditionJoined, Reason: reasonAgentNotRegistered}
+	if joined := meta.FindStatusCondition(conditions, clusterv1.ManagedClusterConditionJoined); joined != nil {
+		status.Reason = joined.Reason
+		status.Message = joined.Message
+	}
+	return status
+}
+
+// reportStatus mirrors the import status of the ManagedCluster to the source cluster
+func reportStatus(ctx context.Context, p provider.ClusterProvider, ref provider.ClusterRef, cluster *clusterv1.ManagedCluster) error {
+	r, ok := p.(provider.StatusReporter)
+	if !ok {
+		return nil
+	}
+	return r.ReportStatus(ctx, ref, importStatus(cluster.Status.Conditions))
+}
+
+// updateImportStatus updates the status of the ManagedCluster if the conditions are changed, and
+// mirrors the status to the source cluster.
+func (n *controller) updateImportStatus(
+	ctx context.Context, p provider.ClusterProvider, ref provider.ClusterRef,
+	existing, cluster *clusterv1.ManagedCluster) (*clusterv1.ManagedCluster, error) {
+	if !equality.Semantic.DeepEqual(existing.Status, cluster.Status) {
+		updated, err := n.clusterClient.ClusterV1().ManagedClusters().UpdateStatus(ctx, cluster, metav1.UpdateOptions{})
+		if err != nil {
+			return nil, err
+		}
+		cluster = updated
+	}
+	return cluster, reportStatus(ctx, p, ref, cluster)
+}
@@ -0,0 +1,197 @@
+package controllers
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/qiujian16/capi-importer/pkg/join"
+	"github.com/qiujian16/capi-importer/pkg/provider"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clusterv1 "open-cluster-management.io/api/cluster/v1"
+)
+
+func TestSetImportConditions(t *testing.T) {
+	cases := []struct {
+		name     string
+		err      error
+		expected map[string]metav1.ConditionStatus
+	}{
+		{
+			name: "klusterlet applied",
+			expected: map[string]metav1.ConditionStatus{
+				"ImportCredentialsFetched": metav1.ConditionTrue,
+				"ImportSpokeConnected":     metav1.ConditionTrue,
+				"ImportCRDApplied":         metav1.ConditionTrue,
+				"ImportManifestsApplied":   metav1.ConditionTrue,
+				"ImportOperatorDeployed":   metav1.ConditionTrue,
+				"ImportKlusterletApplied":  metav1.ConditionTrue,
+				conditionImported:          metav1.ConditionFalse,
+			},
+		},
+		{
+			name: "operator deploy fails",
+			err:  &join.PhaseError{Phase: join.PhaseOperatorDeployed, Err: fmt.Errorf("forbidden")},
+			expected: map[string]metav1.ConditionStatus{
+				"ImportCredentialsFetched": metav1.ConditionTrue,
+				"ImportSpokeConnected":     metav1.ConditionTrue,
+				"ImportCRDApplied":         metav1.ConditionTrue,
+				"ImportManifestsApplied":   metav1.ConditionTrue,
+				"ImportOperatorDeployed":   metav1.ConditionFalse,
+				"ImportKlusterletApplied":  "",
+				conditionImported:          metav1.ConditionFalse,
+			},
+		},
+		{
+			name: "manifests apply fails",
+			err:  &join.PhaseError{Phase: join.PhaseManifestsApplied, Err: fmt.Errorf("forbidden")},
+			expected: map[string]metav1.ConditionStatus{
+				"ImportCredentialsFetched": metav1.ConditionTrue,
+				"ImportSpokeConnected":     metav1.ConditionTrue,
+				"ImportCRDApplied":         metav1.ConditionTrue,
+				"ImportManifestsApplied":   metav1.ConditionFalse,
+				"ImportOperatorDeployed":   "",
+				"ImportKlusterletApplied":  "",
+				conditionImported:          metav1.ConditionFalse,
+			},
+		},
+		{
+			name: "error on the hub",
+			err:  fmt.Errorf("image pull secret not found"),
+			expected: map[string]metav1.ConditionStatus{
+				"ImportCredentialsFetched": metav1.ConditionTrue,
+				"ImportSpokeConnected":     "",
+				conditionImported:          metav1.ConditionFalse,
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var conditions []metav1.Condition
+			setImportConditions(&conditions, 2, c.err)
+			for conditionType, status := range c.expected {
+				condition := meta.FindStatusCondition(conditions, conditionType)
+				if len(status) == 0 {
+					if condition != nil {
+						t.Errorf("expected no condition %s, but got %v", conditionType, condition)
+					}
+					continue
+				}
+				if condition == nil {
+					t.Fatalf("expected condition %s, but got none", conditionType)
+				}
+				if condition.Status != status {
+					t.Errorf("expected condition %s to be %s, but got %s", conditionType, status, condition.Status)
+				}
+				if condition.ObservedGeneration != 2 {
+					t.Errorf("expected observed generation 2 of condition %s, but got %d", conditionType, condition.ObservedGeneration)
+				}
+			}
+		})
+	}
+}
+
+func TestSetJoinConditions(t *testing.T) {
+	cases := []struct {
+		name               string
+		conditions         []metav1.Condition
+		timeout            bool
+		expectedImported   metav1.ConditionStatus
+		expectedReason     string
+		expectedKlusterlet metav1.ConditionStatus
+	}{
+		{
+			name: "joined and available",
+			conditions: []metav1.Condition{
+				{Type: clusterv1.ManagedClusterConditionJoined, Status: metav1.ConditionTrue},
+				{Type: clusterv1.ManagedClusterConditionAvailable, Status: metav1.ConditionTrue},
+			},
+			timeout:            true,
+			expectedImported:   metav1.ConditionTrue,
+			expectedReason:     reasonImportSucceed,
+			expectedKlusterlet: metav1.ConditionTrue,
+		},
+		{
+			name: "joined but not available",
+			conditions: []metav1.Condition{
+				{Type: clusterv1.ManagedClusterConditionJoined, Status: metav1.ConditionTrue},
+				{Type: clusterv1.ManagedClusterConditionAvailable, Status: metav1.ConditionUnknown},
+			},
+			expectedImported:   metav1.ConditionFalse,
+			expectedReason:     reasonWaitingForJoin,
+			expectedKlusterlet: metav1.ConditionTrue,
+		},
+		{
+			name:               "join timeout",
+			timeout:            true,
+			expectedImported:   metav1.ConditionFalse,
+			expectedReason:     reasonJoinTimeout,
+			expectedKlusterlet: metav1.ConditionFalse,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			conditions := c.conditions
+			setImportConditions(&conditions, 1, nil)
+			setJoinConditions(&conditions, 1, c.timeout, "klusterlet has no conditions")
+
+			imported := meta.FindStatusCondition(conditions, conditionImported)
+			if imported.Status != c.expectedImported || imported.Reason != c.expectedReason {
+				t.Errorf("expected imported condition %s/%s, but got %s/%s",
+					c.expectedImported, c.expectedReason, imported.Status, imported.Reason)
+			}
+			applied := meta.FindStatusCondition(conditions, conditionKlusterletApplied)
+			if applied.Status != c.expectedKlusterlet {
+				t.Errorf("expected klusterlet applied condition %s, but got %s", c.expectedKlusterlet, applied.Status)
+			}
+		})
+	}
+}
+
+func TestImportStatus(t *testing.T) {
+	cases := []struct {
+		name       string
+		err        error
+		conditions []metav1.Condition
+		expected   provider.ImportStatus
+	}{
+		{
+			name:     "spoke unreachable",
+			err:      &join.PhaseError{Phase: join.PhaseSpokeConnected, Err: fmt.Errorf("timeout")},
+			expected: provider.ImportStatus{Phase: "SpokeConnected", Reason: "SpokeUnreachable", Message: "timeout"},
+		},
+		{
+			name:     "waiting for the agent",
+			expected: provider.ImportStatus{Phase: clusterv1.ManagedClusterConditionJoined, Reason: reasonAgentNotRegistered},
+		},
+		{
+			name: "joined",
+			conditions: []metav1.Condition{
+				{
+					Type:    clusterv1.ManagedClusterConditionJoined,
+					Status:  metav1.ConditionTrue,
+					Reason:  "ManagedClusterJoined",
+					Message: "Managed cluster joined",
+				},
+			},
+			expected: provider.ImportStatus{
+				Phase:   clusterv1.ManagedClusterConditionJoined,
+				Reason:  "ManagedClusterJoined",
+				Message: "Managed cluster joined",
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			conditions := c.conditions
+			setImportConditions(&conditions, 1, c.err)
+			status := importStatus(conditions)
+			if status != c.expected {
+				t.Errorf("expected %#v, but got %#v", c.expected, status)
+			}
+		})
+	}
+}
@@ -2,39 +2,169 @@ package importers
 
 import (
 	"context"
+	"fmt"
 	"os"
+	"regexp"
 	"time"
 
+	configv1client "github.com/openshift/client-go/config/clientset/versioned/typed/config/v1"
 	"github.com/openshift/library-go/pkg/controller/controllercmd"
+	v1alpha1 "github.com/qiujian16/capi-importer/pkg/apis/v1alpha1"
 	"github.com/qiujian16/capi-importer/pkg/importers/controllers"
 	"github.com/qiujian16/capi-importer/pkg/join"
 	"github.com/qiujian16/capi-importer/pkg/provider"
 	"github.com/qiujian16/capi-importer/pkg/provider/capi"
 	"github.com/qiujian16/capi-importer/pkg/provider/clusterservice"
+	"github.com/qiujian16/capi-importer/pkg/provider/directory"
+	"github.com/qiujian16/capi-importer/pkg/provider/hive"
+	"github.com/qiujian16/capi-importer/pkg/provider/hypershift"
+	"github.com/qiujian16/capi-importer/pkg/provider/kubeconfig"
+	"github.com/qiujian16/capi-importer/pkg/provider/secret"
 	"github.com/spf13/pflag"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/klog/v2"
 	clusterv1client "open-cluster-management.io/api/client/cluster/clientset/versioned"
 	clusterinformers "open-cluster-management.io/api/client/cluster/informers/externalversions"
+	operatorv1 "open-cluster-management.io/api/operator/v1"
+)
+
+const (
+	// defaultBootstrapSA is the name of the bootstrap service account in the namespace of the
+	// importer, if --bootstrap-sa is not set.
+	defaultBootstrapSA = "managed-cluster-bootstrap"
+
+	credentialStoreMemory = "memory"
+	credentialStoreSecret = "secret"
+
+	providerCAPI       = "capi"
+	providerHive       = "hive"
+	providerHyperShift = "hypershift"
+	providerSecret     = "secret"
+	providerDirectory  = "directory"
+	providerKubeConfig = "kubeconfig"
 )
 
 type ImporterOptions struct {
-	HubAPIServer string
-	CAFile       string
-	CSToken      string
-	SA           string
+	Providers           []string
+	KubeConfigDir       string
+	KubeConfigFile      string
+	KubeConfigContexts  []string
+	ContextRegex        string
+	HubAPIServer        string
+	CAFile              string
+	HubProxyURL         string
+	HubCABundleFile     string
+	HubTLSServerName    string
+	HubHostAliasIP      string
+	CSToken             string
+	SA                  string
+	BootstrapStrategy   string
+	TokenExpiration     time.Duration
+	CredentialStore     string
+	CredentialTTL       time.Duration
+	CredentialNamespace string
+	Registry            string
+	RegistrationVersion string
+	WorkVersion         string
+	OperatorVersion     string
+	Images              join.Images
+	ImagePullSecret     string
+	KlusterletMode      string
+	HostingKubeConfig   string
+	HyperShiftMode      string
 }
 
 func NewImporterOptions() *ImporterOptions {
-	return &ImporterOptions{}
+	return &ImporterOptions{
+		Providers:           []string{providerCAPI},
+		BootstrapStrategy:   join.BootstrapStrategyToken,
+		TokenExpiration:     24 * time.Hour,
+		CredentialStore:     credentialStoreMemory,
+		CredentialTTL:       30 * time.Minute,
+		Registry:            "quay.io/open-cluster-management-io",
+		RegistrationVersion: "latest",
+		WorkVersion:         "latest",
+		OperatorVersion:     "latest",
+		KlusterletMode:      string(operatorv1.InstallModeDefault),
+		HyperShiftMode:      string(operatorv1.InstallModeHosted),
+	}
 }
 
 // AddFlags registers flags for manager
 func (o *ImporterOptions) AddFlags(fs *pflag.FlagSet) {
-	fs.StringVar(&o.HubAPIServer, "hub-apiserver", o.HubAPIServer, "")
-	fs.StringVar(&o.CAFile, "hub-ca-file", o.CAFile, "")
-	fs.StringVar(&o.SA, "bootstrap-sa", o.SA, "")
-	fs.StringVar(&o.CSToken, "cluster-service-token", o.CSToken, "")
+	fs.StringSliceVar(&o.Providers, "providers", o.Providers,
+		"The providers to import the clusters from on the hub, capi, hive, hypershift, secret, directory or kubeconfig. The clusters of cluster service are imported "+
+			"if --cluster-service-token is set.")
+	fs.StringVar(&o.KubeConfigDir, "kubeconfig-dir", o.KubeConfigDir,
+		"The local directory of the kubeconfig files imported by the directory provider, the name of each file without "+
+			"its extension is the name of the cluster.")
+	fs.StringVar(&o.KubeConfigFile, "kubeconfig-file", o.KubeConfigFile,
+		"The kubeconfig file with the contexts of the clusters imported by the kubeconfig provider.")
+	fs.StringSliceVar(&o.KubeConfigContexts, "kubeconfig-contexts", o.KubeConfigContexts,
+		"The contexts in --kubeconfig-file to import, all the contexts are imported if neither it nor --kubeconfig-context-regex is set.")
+	fs.StringVar(&o.ContextRegex, "kubeconfig-context-regex", o.ContextRegex,
+		"The regex of the contexts in --kubeconfig-file to import.")
+	fs.StringVar(&o.HubAPIServer, "hub-apiserver", o.HubAPIServer,
+		"The URL of the hub apiserver in the bootstrap kubeconfig, it is discovered from cluster-info, the OpenShift "+
+			"infrastructure or the kubeconfig of the importer if it is not set.")
+	fs.StringVar(&o.CAFile, "hub-ca-file", o.CAFile,
+		"The CA file of the hub apiserver in the bootstrap kubeconfig, it is read from cluster-info or kube-root-ca.crt if it is not set.")
+	fs.StringVar(&o.HubProxyURL, "hub-proxy-url", o.HubProxyURL,
+		"The URL of the HTTP(S) proxy for the agents to reach the hub apiserver.")
+	fs.StringVar(&o.HubCABundleFile, "hub-ca-bundle-file", o.HubCABundleFile,
+		"The file of an extra CA bundle appended to the CA of the hub apiserver in the bootstrap kubeconfig, e.g. the CA of a load balancer.")
+	fs.StringVar(&o.HubTLSServerName, "hub-tls-server-name", o.HubTLSServerName,
+		"The server name to verify the certificate of the hub apiserver, if it is not the host of the hub apiserver URL.")
+	fs.StringVar(&o.HubHostAliasIP, "hub-host-alias-ip", o.HubHostAliasIP,
+		"The IPv4 address the host of the hub apiserver URL resolves to for the agents.")
+	fs.StringVar(&o.SA, "bootstrap-sa", o.SA,
+		"The namespace/name of the service account on the hub to issue the bootstrap tokens, it is created with the bootstrap RBAC "+
+			"if it does not exist. Defaults to "+defaultBootstrapSA+" in the namespace of the importer.")
+	fs.StringVar(&o.BootstrapStrategy, "bootstrap-strategy", o.BootstrapStrategy,
+		"How the bootstrap credentials of the clusters are issued: token for short-lived tokens of the bootstrap service account, "+
+			"bootstrap-token for kubernetes bootstrap tokens in kube-system, serviceaccount for a service account per cluster, "+
+			"or csr for client certificates signed by the hub apiserver.")
+	fs.DurationVar(&o.TokenExpiration, "bootstrap-token-expiration", o.TokenExpiration,
+		"The lifetime of the bootstrap tokens or certificates issued for the clusters.")
+	fs.StringVar(&o.CSToken, "cluster-service-token", o.CSToken,
+		"The offline token to access the cluster service, clusters in cluster service are not imported if it is not set.")
+	fs.StringVar(&o.CredentialStore, "credential-store", o.CredentialStore,
+		"Where to keep the kubeconfigs fetched from the providers, memory or secret.")
+	fs.DurationVar(&o.CredentialTTL, "credential-ttl", o.CredentialTTL,
+		"How long the kubeconfigs are kept in the memory credential store.")
+	fs.StringVar(&o.CredentialNamespace, "credential-namespace", o.CredentialNamespace,
+		"The namespace on the hub of the secret credential store, defaults to the namespace of the importer.")
+	fs.StringVar(&o.Registry, "registry", o.Registry,
+		"The registry of the klusterlet images, e.g. a mirror registry in air-gapped environments.")
+	fs.StringVar(&o.RegistrationVersion, "registration-image-version", o.RegistrationVersion,
+		"The tag or digest (sha256:xxx) of the registration image.")
+	fs.StringVar(&o.WorkVersion, "work-image-version", o.WorkVersion,
+		"The tag or digest (sha256:xxx) of the work image.")
+	fs.StringVar(&o.OperatorVersion, "operator-image-version", o.OperatorVersion,
+		"The tag or digest (sha256:xxx) of the registration-operator image.")
+	fs.StringVar(&o.Images.Registration, "registration-image", o.Images.Registration,
+		"The full pull spec of the registration image, it overrides --registry and --registration-image-version.")
+	fs.StringVar(&o.Images.Work, "work-image", o.Images.Work,
+		"The full pull spec of the work image, it overrides --registry and --work-image-version.")
+	fs.StringVar(&o.Images.Operator, "operator-image", o.Images.Operator,
+		"The full pull spec of the registration-operator image, it overrides --registry and --operator-image-version.")
+	fs.StringVar(&o.ImagePullSecret, "image-pull-secret", o.ImagePullSecret,
+		"The namespace/name of the dockerconfigjson secret on the hub, which is copied to the imported clusters to pull the images.")
+	fs.StringVar(&o.KlusterletMode, "klusterlet-mode", o.KlusterletMode,
+		"The install mode of the klusterlet, Default, Hosted or SingletonHosted.")
+	fs.StringVar(&o.HostingKubeConfig, "hosting-kubeconfig", o.HostingKubeConfig,
+		"The kubeconfig file of the cluster to deploy the klusterlets on in the hosted modes, defaults to the cluster the importer runs on.")
+	fs.StringVar(&o.HyperShiftMode, "hypershift-klusterlet-mode", o.HyperShiftMode,
+		"The install mode of the klusterlets of the HostedClusters, they are deployed on the management cluster in the hosted modes.")
 }
 
 func (o *ImporterOptions) RunImporterController(ctx context.Context, controllerContext *controllercmd.ControllerContext) error {
@@ -49,40 +179,240 @@ func (o *ImporterOptions) RunImporterController(ctx context.Context, controllerC
 	}
 	clusterInformers := clusterinformers.NewSharedInformerFactory(clusterClient, 30*time.Minute)
 
-	saNamespace, saName, err := cache.SplitMetaNamespaceKey(o.SA)
+	saNamespace, saName := controllerContext.OperatorNamespace, defaultBootstrapSA
+	if len(o.SA) > 0 {
+		namespace, name, err := cache.SplitMetaNamespaceKey(o.SA)
+		if err != nil {
+			return err
+		}
+		if len(namespace) > 0 {
+			saNamespace = namespace
+		}
+		saName = name
+	}
+
+	var caData []byte
+	if len(o.CAFile) > 0 {
+		caData, err = os.ReadFile(o.CAFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	var caBundle []byte
+	if len(o.HubCABundleFile) > 0 {
+		caBundle, err = os.ReadFile(o.HubCABundleFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	bootStrapConfig := join.BootstrapConfig{
+		HubAPIServer:    o.HubAPIServer,
+		SANamespace:     saNamespace,
+		SAName:          saName,
+		CA:              caData,
+		Strategy:        o.BootstrapStrategy,
+		TokenExpiration: o.TokenExpiration,
+		ProxyURL:        o.HubProxyURL,
+		CABundle:        caBundle,
+		TLSServerName:   o.HubTLSServerName,
+		HostAliasIP:     o.HubHostAliasIP,
+	}
+	if len(bootStrapConfig.HubAPIServer) == 0 {
+		configClient, err := configv1client.NewForConfig(controllerContext.KubeConfig)
+		if err != nil {
+			return err
+		}
+		bootStrapConfig.HubAPIServer, err = join.DiscoverHubAPIServer(
+			ctx, kubeClient, configClient, controllerContext.KubeConfig.Host, bootStrapConfig)
+		if err != nil {
+			return err
+		}
+	} else if err := join.ValidateHubAPIServer(kubeClient, bootStrapConfig); err != nil {
+		// the hub apiserver may not be reachable from the importer in some networks, it is up to
+		// the user to set the right one.
+		klog.Warningf("The hub apiserver may not be reachable from the clusters: %v", err)
+	}
+	if err := bootStrapConfig.Validate(); err != nil {
+		return err
+	}
+	if err := join.EnsureBootstrapRBAC(ctx, kubeClient, controllerContext.EventRecorder, bootStrapConfig); err != nil {
+		return err
+	}
+
+	klusterletConfig, err := o.klusterletConfig(controllerContext.KubeConfig)
 	if err != nil {
 		return err
 	}
 
-	caData, err := os.ReadFile(o.CAFile)
+	providers, err := o.providers(controllerContext.KubeConfig, kubeClient)
 	if err != nil {
 		return err
 	}
-	bootStrapConfig := join.BootstrapConfig{
-		HubAPIServer: o.HubAPIServer,
-		SANamespace:  saNamespace,
-		SAName:       saName,
-		CA:           caData,
+	// cluster service is only synced when the token to access it is provided
+	if len(o.CSToken) > 0 {
+		credentials, err := o.credentialStore(kubeClient, controllerContext.OperatorNamespace)
+		if err != nil {
+			return err
+		}
+		providers = append(providers, clusterservice.NewClusterServiceProvider(o.CSToken, credentials))
 	}
 
-	providers := []provider.ClusterProvider{
-		clusterservice.NewClusterServiceProvider(o.CSToken),
-		capi.NewCAPIProvider(controllerContext.KubeConfig),
+	configInformers := dynamicinformer.NewDynamicSharedInformerFactory(
+		dynamic.NewForConfigOrDie(controllerContext.KubeConfig), 30*time.Minute)
+	var configInformer informers.GenericInformer
+	installed, err := resourceInstalled(kubeClient.Discovery(), v1alpha1.ImportConfigGVR)
+	if err != nil {
+		return err
+	}
+	if installed {
+		configInformer = configInformers.ForResource(v1alpha1.ImportConfigGVR)
+	} else {
+		klog.Info("ImportConfig CRD is not installed, clusters are imported with the flags of the importer")
 	}
 
 	ctrl := controllers.NewController(
 		kubeClient,
 		clusterClient,
 		clusterInformers.Cluster().V1().ManagedClusters(),
+		configInformer,
 		bootStrapConfig,
+		o.imageConfig(),
+		klusterletConfig,
 		controllerContext.EventRecorder,
 		providers...,
 	)
 
 	go clusterInformers.Start(ctx.Done())
+	go configInformers.Start(ctx.Done())
 	for _, p := range providers {
 		go p.Start(ctx)
 	}
 	go ctrl.Run(ctx, 1)
 	return nil
 }
+
+func (o *ImporterOptions) providers(kubeConfig *rest.Config, kubeClient kubernetes.Interface) ([]provider.ClusterProvider, error) {
+	providers := []provider.ClusterProvider{}
+	for _, name := range o.Providers {
+		switch name {
+		case providerCAPI:
+			providers = append(providers, capi.NewCAPIProvider(kubeConfig))
+		case providerHive:
+			providers = append(providers, hive.NewHiveProvider(kubeConfig))
+		case providerHyperShift:
+			if err := validateKlusterletMode(o.HyperShiftMode); err != nil {
+				return nil, err
+			}
+			// the HostedClusters and their control planes are on the cluster the importer runs on
+			managementKubeConfig, err := join.NewClientConfig(kubeConfig)
+			if err != nil {
+				return nil, err
+			}
+			providers = append(providers, hypershift.NewHyperShiftProvider(
+				kubeConfig, operatorv1.InstallMode(o.HyperShiftMode), managementKubeConfig))
+		case providerSecret:
+			providers = append(providers, secret.NewSecretProvider(kubeClient))
+		case providerDirectory:
+			if len(o.KubeConfigDir) == 0 {
+				return nil, fmt.Errorf("--kubeconfig-dir is required by the directory provider")
+			}
+			providers = append(providers, directory.NewDirectoryProvider(o.KubeConfigDir))
+		case providerKubeConfig:
+			if len(o.KubeConfigFile) == 0 {
+				return nil, fmt.Errorf("--kubeconfig-file is required by the kubeconfig provider")
+			}
+			selector := kubeconfig.ContextSelector{Names: o.KubeConfigContexts}
+			if len(o.ContextRegex) > 0 {
+				regex, err := regexp.Compile(o.ContextRegex)
+				if err != nil {
+					return nil, fmt.Errorf("invalid --kubeconfig-context-regex: %v", err)
+				}
+				selector.Regex = regex
+			}
+			providers = append(providers, kubeconfig.NewKubeConfigProvider(o.KubeConfigFile, selector))
+		default:
+			return nil, fmt.Errorf("unknown provider %q", name)
+		}
+	}
+	return providers, nil
+}
+
+func (o *ImporterOptions) klusterletConfig(kubeConfig *rest.Config) (controllers.KlusterletConfig, error) {
+	config := controllers.KlusterletConfig{Mode: o.KlusterletMode}
+	if err := validateKlusterletMode(o.KlusterletMode); err != nil {
+		return config, err
+	}
+
+	// the hosting cluster is always set up, since the ImportConfig of a cluster may switch
+	// the cluster to a hosted mode.
+	if len(o.HostingKubeConfig) == 0 {
+		hostingKubeConfig, err := join.NewClientConfig(kubeConfig)
+		if err != nil {
+			return config, err
+		}
+		config.HostingKubeConfig = hostingKubeConfig
+		return config, nil
+	}
+
+	hostingKubeConfig, err := os.ReadFile(o.HostingKubeConfig)
+	if err != nil {
+		return config, err
+	}
+	config.HostingKubeConfig, err = clientcmd.NewClientConfigFromBytes(hostingKubeConfig)
+	return config, err
+}
+
+func validateKlusterletMode(mode string) error {
+	switch operatorv1.InstallMode(mode) {
+	case operatorv1.InstallModeDefault, operatorv1.InstallModeHosted, operatorv1.InstallModeSingletonHosted:
+		return nil
+	default:
+		return fmt.Errorf("unsupported klusterlet mode %q", mode)
+	}
+}
+
+func (o *ImporterOptions) imageConfig() controllers.ImageConfig {
+	return controllers.ImageConfig{
+		Registry: o.Registry,
+		Versions: join.ImageVersions{
+			Registration: o.RegistrationVersion,
+			Work:         o.WorkVersion,
+			Operator:     o.OperatorVersion,
+		},
+		Images:     o.Images,
+		PullSecret: o.ImagePullSecret,
+	}
+}
+
+func (o *ImporterOptions) credentialStore(kubeClient kubernetes.Interface, namespace string) (provider.CredentialStore, error) {
+	switch o.CredentialStore {
+	case credentialStoreMemory:
+		return provider.NewMemoryCredentialStore(o.CredentialTTL), nil
+	case credentialStoreSecret:
+		if len(o.CredentialNamespace) > 0 {
+			namespace = o.CredentialNamespace
+		}
+		return provider.NewSecretCredentialStore(kubeClient, namespace), nil
+	default:
+		return nil, fmt.Errorf("unknown credential store %q", o.CredentialStore)
+	}
+}
+
+// resourceInstalled checks whether the resource is served by the apiserver
+func resourceInstalled(client discovery.DiscoveryInterface, gvr schema.GroupVersionResource) (bool, error) {
+	resources, err := client.ServerResourcesForGroupVersion(gvr.GroupVersion().String())
+	if errors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	for _, r := range resources.APIResources {
+		if r.Name == gvr.Resource {
+			return true, nil
+		}
+	}
+	return false, nil
+}
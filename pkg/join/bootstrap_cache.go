@@ -0,0 +1,173 @@
+// Copyright Contributors to the Open Cluster Management project
+package join
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ghodss/yaml"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	clientcmdapiv1 "k8s.io/client-go/tools/clientcmd/api/v1"
+)
+
+const (
+	// bootstrapRotationRatio is the ratio of the lifetime of a bootstrap kubeconfig after which it is rotated
+	bootstrapRotationRatio = 0.8
+
+	annotationIssuedAt   = "import.open-cluster-management.io/issued-at"
+	annotationExpiration = "import.open-cluster-management.io/expiration"
+
+	bootstrapKubeConfigKey = "kubeconfig"
+)
+
+// CachedBootStrapper keeps the bootstrap kubeconfig of the cluster in a secret on the hub, so the
+// retries of the import reuse the same kubeconfig instead of issuing new credentials each time. The
+// kubeconfig is rotated once it passes the rotation threshold of its lifetime.
+type CachedBootStrapper struct {
+	getter      BootstrapGetter
+	config      BootstrapConfig
+	client      kubernetes.Interface
+	clusterName string
+	now         func() time.Time
+}
+
+func NewCachedBootStrapper(config BootstrapConfig, client kubernetes.Interface, clusterName string) (*CachedBootStrapper, error) {
+	getter, err := NewBootstrapGetter(config, client, clusterName)
+	if err != nil {
+		return nil, err
+	}
+	return &CachedBootStrapper{
+		getter:      getter,
+		config:      config,
+		client:      client,
+		clusterName: clusterName,
+		now:         time.Now,
+	}, nil
+}
+
+func (g *CachedBootStrapper) secretName() string {
+	return fmt.Sprintf("%s-bootstrap-kubeconfig", g.clusterName)
+}
+
+// KubeConfigRaw returns the cached bootstrap kubeconfig, a new one is issued and cached if there
+// is none or it is due to rotate.
+func (g *CachedBootStrapper) KubeConfigRaw() ([]byte, error) {
+	secret, err := g.client.CoreV1().Secrets(g.config.SANamespace).Get(context.TODO(), g.secretName(), metav1.GetOptions{})
+	switch {
+	case errors.IsNotFound(err):
+	case err != nil:
+		return nil, err
+	case g.now().Before(g.rotateAt(secret)):
+		return secret.Data[bootstrapKubeConfigKey], nil
+	}
+
+	kubeConfig, err := g.Issue()
+	if err != nil {
+		return nil, err
+	}
+	return kubeConfig, g.Store(kubeConfig)
+}
+
+func (g *CachedBootStrapper) KubeConfig() (clientcmdapiv1.Config, error) {
+	config := clientcmdapiv1.Config{}
+	kubeConfig, err := g.KubeConfigRaw()
+	if err != nil {
+		return config, err
+	}
+	err = yaml.Unmarshal(kubeConfig, &config)
+	return config, err
+}
+
+// Revoke deletes the cached bootstrap kubeconfig and revokes the credentials of the cluster
+func (g *CachedBootStrapper) Revoke() error {
+	err := g.client.CoreV1().Secrets(g.config.SANamespace).Delete(context.TODO(), g.secretName(), metav1.DeleteOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	return g.getter.Revoke()
+}
+
+// RotateAt returns the time to rotate the cached bootstrap kubeconfig, it is the zero time if
+// there is no cached kubeconfig.
+func (g *CachedBootStrapper) RotateAt() (time.Time, error) {
+	secret, err := g.client.CoreV1().Secrets(g.config.SANamespace).Get(context.TODO(), g.secretName(), metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return g.rotateAt(secret), nil
+}
+
+// Issue issues a new bootstrap kubeconfig without caching it, it is cached by Store once it is
+// delivered to the cluster.
+func (g *CachedBootStrapper) Issue() ([]byte, error) {
+	return g.getter.KubeConfigRaw()
+}
+
+// Store caches the bootstrap kubeconfig issued now
+func (g *CachedBootStrapper) Store(kubeConfig []byte) error {
+	issuedAt := g.now()
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      g.secretName(),
+			Namespace: g.config.SANamespace,
+			Labels: map[string]string{
+				LabelClusterName: g.clusterName,
+			},
+			Annotations: map[string]string{
+				annotationIssuedAt:   issuedAt.UTC().Format(time.RFC3339),
+				annotationExpiration: issuedAt.Add(g.config.TokenExpiration).UTC().Format(time.RFC3339),
+			},
+		},
+		Data: map[string][]byte{
+			bootstrapKubeConfigKey: kubeConfig,
+		},
+	}
+
+	existing, err := g.client.CoreV1().Secrets(g.config.SANamespace).Get(context.TODO(), g.secretName(), metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		_, err = g.client.CoreV1().Secrets(g.config.SANamespace).Create(context.TODO(), secret, metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	secret.ResourceVersion = existing.ResourceVersion
+	_, err = g.client.CoreV1().Secrets(g.config.SANamespace).Update(context.TODO(), secret, metav1.UpdateOptions{})
+	return err
+}
+
+// rotateAt returns the time to rotate the cached kubeconfig, the kubeconfig is rotated right away if
+// its lifetime is unknown, or it does not reach the hub apiserver as the config does any more.
+func (g *CachedBootStrapper) rotateAt(secret *corev1.Secret) time.Time {
+	issuedAt, err := time.Parse(time.RFC3339, secret.Annotations[annotationIssuedAt])
+	if err != nil {
+		return time.Time{}
+	}
+	expiration, err := time.Parse(time.RFC3339, secret.Annotations[annotationExpiration])
+	if err != nil {
+		return time.Time{}
+	}
+
+	config := clientcmdapiv1.Config{}
+	if err := yaml.Unmarshal(secret.Data[bootstrapKubeConfigKey], &config); err != nil {
+		return time.Time{}
+	}
+	if len(config.Clusters) != 1 {
+		return time.Time{}
+	}
+	cluster := config.Clusters[0].Cluster
+	if cluster.Server != g.config.HubAPIServer || cluster.ProxyURL != g.config.ProxyURL ||
+		cluster.TLSServerName != g.config.TLSServerName {
+		return time.Time{}
+	}
+
+	lifetime := expiration.Sub(issuedAt)
+	return issuedAt.Add(time.Duration(float64(lifetime) * bootstrapRotationRatio))
+}
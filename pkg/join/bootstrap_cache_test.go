@@ -0,0 +1,204 @@
+// Copyright Contributors to the Open Cluster Management project
+package join
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+)
+
+func TestRotateAt(t *testing.T) {
+	kubeConfig := []byte(`
+apiVersion: v1
+kind: Config
+clusters:
+- name: hub
+  cluster:
+    server: https://hub:6443
+`)
+	issuedAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	cases := []struct {
+		name        string
+		annotations map[string]string
+		server      string
+		expected    time.Time
+	}{
+		{
+			name: "rotate after the threshold",
+			annotations: map[string]string{
+				annotationIssuedAt:   issuedAt.Format(time.RFC3339),
+				annotationExpiration: issuedAt.Add(10 * time.Hour).Format(time.RFC3339),
+			},
+			server:   "https://hub:6443",
+			expected: issuedAt.Add(8 * time.Hour),
+		},
+		{
+			name:   "unknown lifetime",
+			server: "https://hub:6443",
+		},
+		{
+			name: "hub apiserver changed",
+			annotations: map[string]string{
+				annotationIssuedAt:   issuedAt.Format(time.RFC3339),
+				annotationExpiration: issuedAt.Add(10 * time.Hour).Format(time.RFC3339),
+			},
+			server: "https://hub.example.com:6443",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			g := &CachedBootStrapper{config: BootstrapConfig{HubAPIServer: c.server}}
+			secret := &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Annotations: c.annotations},
+				Data:       map[string][]byte{bootstrapKubeConfigKey: kubeConfig},
+			}
+			if rotateAt := g.rotateAt(secret); !rotateAt.Equal(c.expected) {
+				t.Errorf("expected %v, but got %v", c.expected, rotateAt)
+			}
+		})
+	}
+}
+
+func TestCachedBootStrapper(t *testing.T) {
+	config := BootstrapConfig{
+		CA:              []byte("ca"),
+		HubAPIServer:    "https://hub:6443",
+		SAName:          "bootstrap",
+		SANamespace:     "open-cluster-management",
+		TokenExpiration: 10 * time.Hour,
+	}
+	issuedAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	cached := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "cluster1-bootstrap-kubeconfig",
+			Namespace: config.SANamespace,
+			Annotations: map[string]string{
+				annotationIssuedAt:   issuedAt.Format(time.RFC3339),
+				annotationExpiration: issuedAt.Add(config.TokenExpiration).Format(time.RFC3339),
+			},
+		},
+		Data: map[string][]byte{bootstrapKubeConfigKey: []byte(`
+apiVersion: v1
+kind: Config
+clusters:
+- name: hub
+  cluster:
+    server: https://hub:6443
+users:
+- name: bootstrap
+  user:
+    token: cached
+`)},
+	}
+
+	cases := []struct {
+		name           string
+		existing       []runtime.Object
+		now            time.Time
+		expectedToken  string
+		expectedIssued bool
+	}{
+		{
+			name:           "issue and cache a new kubeconfig",
+			now:            issuedAt,
+			expectedToken:  "token1",
+			expectedIssued: true,
+		},
+		{
+			name:          "cached kubeconfig before the rotation threshold",
+			existing:      []runtime.Object{cached},
+			now:           issuedAt.Add(7 * time.Hour),
+			expectedToken: "cached",
+		},
+		{
+			name:           "rotate the cached kubeconfig at 0.8 of its lifetime",
+			existing:       []runtime.Object{cached},
+			now:            issuedAt.Add(8 * time.Hour),
+			expectedToken:  "token1",
+			expectedIssued: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			client := withTokenRequests(kubefake.NewSimpleClientset(c.existing...))
+			g, err := NewCachedBootStrapper(config, client, "cluster1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			g.now = func() time.Time { return c.now }
+
+			kubeConfig, err := g.KubeConfigRaw()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(string(kubeConfig), "token: "+c.expectedToken) {
+				t.Errorf("expected the kubeconfig with token %s, but got %s", c.expectedToken, kubeConfig)
+			}
+			if issued := tokenRequests(client) > 0; issued != c.expectedIssued {
+				t.Errorf("expected issued %t, but got %t", c.expectedIssued, issued)
+			}
+
+			// the kubeconfig issued is cached until the next rotation
+			rotateAt, err := g.RotateAt()
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected := issuedAt.Add(8 * time.Hour)
+			if c.expectedIssued {
+				expected = c.now.Add(8 * time.Hour)
+			}
+			if !rotateAt.Equal(expected) {
+				t.Errorf("expected to rotate at %v, but got %v", expected, rotateAt)
+			}
+			secret, err := client.CoreV1().Secrets(config.SANamespace).Get(context.TODO(), g.secretName(), metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(secret.Data[bootstrapKubeConfigKey]) != string(kubeConfig) {
+				t.Errorf("expected the kubeconfig is cached, but got %s", secret.Data[bootstrapKubeConfigKey])
+			}
+		})
+	}
+}
+
+func TestCachedBootStrapperStore(t *testing.T) {
+	config := BootstrapConfig{SANamespace: "open-cluster-management", TokenExpiration: time.Hour}
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	client := kubefake.NewSimpleClientset()
+	g, err := NewCachedBootStrapper(config, client, "cluster1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the kubeconfig is cached once it is delivered, and replaced by the rotated one
+	for i, kubeConfig := range []string{"kubeconfig1", "kubeconfig2"} {
+		g.now = func() time.Time { return now.Add(time.Duration(i) * time.Hour) }
+		if err := g.Store([]byte(kubeConfig)); err != nil {
+			t.Fatal(err)
+		}
+
+		secret, err := client.CoreV1().Secrets(config.SANamespace).Get(context.TODO(), g.secretName(), metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(secret.Data[bootstrapKubeConfigKey]) != kubeConfig {
+			t.Errorf("expected %s is cached, but got %s", kubeConfig, secret.Data[bootstrapKubeConfigKey])
+		}
+		if secret.Labels[LabelClusterName] != "cluster1" {
+			t.Errorf("expected the secret is labeled with the cluster name, but got %v", secret.Labels)
+		}
+		expiration := g.now().Add(config.TokenExpiration).Format(time.RFC3339)
+		if secret.Annotations[annotationExpiration] != expiration {
+			t.Errorf("expected expiration %s, but got %s", expiration, secret.Annotations[annotationExpiration])
+		}
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright Contributors to the Open Cluster Management project
+package join
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+	clientcmdapiv1 "k8s.io/client-go/tools/clientcmd/api/v1"
+)
+
+const (
+	// BootstrapTokenGroup is the extra group of the bootstrap tokens issued for the clusters, it
+	// has to be bound to the BootstrapClusterRole on the hub.
+	BootstrapTokenGroup = "system:bootstrappers:managedcluster"
+
+	bootstrapTokenNamespace = "kube-system"
+	bootstrapTokenChars     = "abcdefghijklmnopqrstuvwxyz0123456789"
+)
+
+// BootstrapTokenBootStrapper issues kubernetes bootstrap tokens for the cluster, the tokens are
+// secrets in kube-system labeled with the cluster name, so they are revoked per cluster.
+type BootstrapTokenBootStrapper struct {
+	config      BootstrapConfig
+	client      kubernetes.Interface
+	clusterName string
+}
+
+func NewBootstrapTokenBootStrapper(config BootstrapConfig, client kubernetes.Interface, clusterName string) BootstrapGetter {
+	return &BootstrapTokenBootStrapper{
+		config:      config,
+		client:      client,
+		clusterName: clusterName,
+	}
+}
+
+func (g *BootstrapTokenBootStrapper) KubeConfigRaw() ([]byte, error) {
+	return rawBootstrapKubeConfig(g)
+}
+
+// KubeConfig issues a new bootstrap token for the cluster, the tokens issued before are revoked.
+func (g *BootstrapTokenBootStrapper) KubeConfig() (clientcmdapiv1.Config, error) {
+	if err := g.Revoke(); err != nil {
+		return clientcmdapiv1.Config{}, err
+	}
+
+	tokenID, err := randomString(6)
+	if err != nil {
+		return clientcmdapiv1.Config{}, err
+	}
+	tokenSecret, err := randomString(16)
+	if err != nil {
+		return clientcmdapiv1.Config{}, err
+	}
+
+	_, err = g.client.CoreV1().Secrets(bootstrapTokenNamespace).Create(context.TODO(), &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "bootstrap-token-" + tokenID,
+			Namespace: bootstrapTokenNamespace,
+			Labels: map[string]string{
+				LabelClusterName: g.clusterName,
+			},
+		},
+		Type: corev1.SecretTypeBootstrapToken,
+		StringData: map[string]string{
+			"description":                    fmt.Sprintf("bootstrap token of cluster %s", g.clusterName),
+			"token-id":                       tokenID,
+			"token-secret":                   tokenSecret,
+			"expiration":                     time.Now().Add(g.config.TokenExpiration).UTC().Format(time.RFC3339),
+			"usage-bootstrap-authentication": "true",
+			"auth-extra-groups":              BootstrapTokenGroup,
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return clientcmdapiv1.Config{}, err
+	}
+
+	return bootstrapKubeConfig(g.client, g.config, clientcmdapiv1.AuthInfo{Token: fmt.Sprintf("%s.%s", tokenID, tokenSecret)})
+}
+
+func (g *BootstrapTokenBootStrapper) Revoke() error {
+	return g.client.CoreV1().Secrets(bootstrapTokenNamespace).DeleteCollection(context.TODO(), metav1.DeleteOptions{}, metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(labels.Set{LabelClusterName: g.clusterName}).String(),
+		FieldSelector: fmt.Sprintf("type=%s", corev1.SecretTypeBootstrapToken),
+	})
+}
+
+// randomString returns a random string of the characters allowed in bootstrap tokens
+func randomString(length int) (string, error) {
+	b := make([]byte, length)
+	max := big.NewInt(int64(len(bootstrapTokenChars)))
+	for i := range b {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		b[i] = bootstrapTokenChars[n.Int64()]
+	}
+	return string(b), nil
+}
@@ -2,87 +2,252 @@
 package join
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"net"
+	"net/url"
+	"time"
 
 	"github.com/ghodss/yaml"
 	authv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 	clientcmdapiv1 "k8s.io/client-go/tools/clientcmd/api/v1"
 	"k8s.io/utils/pointer"
+	operatorv1 "open-cluster-management.io/api/operator/v1"
 )
 
 type BootstrapGetter interface {
 	KubeConfig() (clientcmdapiv1.Config, error)
 	KubeConfigRaw() ([]byte, error)
+	// Revoke invalidates the bootstrap credentials issued for the cluster
+	Revoke() error
 }
 
+// LabelClusterName is set on the hub resources created for the imported cluster
+const LabelClusterName = "import.open-cluster-management.io/cluster-name"
+
+const (
+	// BootstrapStrategyToken issues short-lived tokens of the bootstrap service account for each import
+	BootstrapStrategyToken = "token"
+	// BootstrapStrategyBootstrapToken issues kubernetes bootstrap tokens in kube-system for each cluster
+	BootstrapStrategyBootstrapToken = "bootstrap-token"
+	// BootstrapStrategyServiceAccount creates a service account on the hub for each cluster
+	BootstrapStrategyServiceAccount = "serviceaccount"
+	// BootstrapStrategyCSR issues client certificates signed by the hub apiserver for each import
+	BootstrapStrategyCSR = "csr"
+)
+
+// BootstrapClusterRole is the cluster role on the hub with the permissions for the agents to
+// bootstrap, it is bound to the credentials created for the clusters.
+const BootstrapClusterRole = "open-cluster-management:bootstrap"
+
 type BootstrapConfig struct {
 	CA           []byte
 	HubAPIServer string
 	SAName       string
 	SANamespace  string
+	// Strategy is how the bootstrap credentials of the clusters are issued, defaults to token
+	Strategy string
+	// TokenExpiration is the lifetime of the tokens issued for the clusters
+	TokenExpiration time.Duration
+	// ProxyURL is the proxy for the agents to reach the hub apiserver
+	ProxyURL string
+	// CABundle is appended to the CA of the hub apiserver, e.g. the CA of a load balancer in front of the hub
+	CABundle []byte
+	// TLSServerName is the server name to verify the certificate of the hub apiserver, if it is not
+	// the host of the hub apiserver URL.
+	TLSServerName string
+	// HostAliasIP is the IP the host of the hub apiserver URL resolves to for the agents, it is set
+	// as the hub apiserver host alias of the klusterlet.
+	HostAliasIP string
+}
+
+// HostAlias returns the hub apiserver host alias of the klusterlet, nil if the host alias IP is not set
+func (c BootstrapConfig) HostAlias() *operatorv1.HubApiServerHostAlias {
+	if len(c.HostAliasIP) == 0 {
+		return nil
+	}
+	u, err := url.Parse(c.HubAPIServer)
+	if err != nil {
+		return nil
+	}
+	return &operatorv1.HubApiServerHostAlias{
+		IP:       c.HostAliasIP,
+		Hostname: u.Hostname(),
+	}
 }
 
+// Validate checks the strategy and the token expiration of the config
+func (c BootstrapConfig) Validate() error {
+	switch c.Strategy {
+	case BootstrapStrategyToken, BootstrapStrategyBootstrapToken, BootstrapStrategyServiceAccount, BootstrapStrategyCSR, "":
+	default:
+		return fmt.Errorf("unknown bootstrap strategy %q", c.Strategy)
+	}
+	// the apiserver does not issue service account tokens or certificates expiring in less than 10 minutes
+	if c.TokenExpiration < 10*time.Minute {
+		return fmt.Errorf("bootstrap token expiration %s is less than 10m", c.TokenExpiration)
+	}
+	if len(c.ProxyURL) > 0 {
+		u, err := url.Parse(c.ProxyURL)
+		if err != nil {
+			return fmt.Errorf("invalid hub proxy URL %q: %v", c.ProxyURL, err)
+		}
+		switch u.Scheme {
+		case "http", "https", "socks5":
+		default:
+			return fmt.Errorf("unsupported scheme of hub proxy URL %q", c.ProxyURL)
+		}
+	}
+	if len(c.HostAliasIP) > 0 {
+		if ip := net.ParseIP(c.HostAliasIP); ip == nil || ip.To4() == nil {
+			return fmt.Errorf("hub host alias IP %q is not an IPv4 address", c.HostAliasIP)
+		}
+		if alias := c.HostAlias(); alias == nil || net.ParseIP(alias.Hostname) != nil {
+			return fmt.Errorf("hub apiserver %q has no hostname for the host alias", c.HubAPIServer)
+		}
+	}
+	return nil
+}
+
+// NewBootstrapGetter returns the BootstrapGetter of the cluster for the strategy in the config
+func NewBootstrapGetter(config BootstrapConfig, client kubernetes.Interface, clusterName string) (BootstrapGetter, error) {
+	switch config.Strategy {
+	case BootstrapStrategyToken, "":
+		return NewTokenBootStrapper(config, client, clusterName), nil
+	case BootstrapStrategyBootstrapToken:
+		return NewBootstrapTokenBootStrapper(config, client, clusterName), nil
+	case BootstrapStrategyServiceAccount:
+		return NewServiceAccountBootStrapper(config, client, clusterName), nil
+	case BootstrapStrategyCSR:
+		return NewCSRBootStrapper(config, client, clusterName), nil
+	default:
+		return nil, fmt.Errorf("unknown bootstrap strategy %q", config.Strategy)
+	}
+}
+
+// TokenBootStrapper issues short-lived tokens of the bootstrap service account bound to a secret of
+// the cluster on the hub, deleting the secret revokes all the tokens issued for the cluster.
 type TokenBootStrapper struct {
-	config BootstrapConfig
-	client kubernetes.Interface
+	config      BootstrapConfig
+	client      kubernetes.Interface
+	clusterName string
 }
 
-func NewTokenBootStrapper(config BootstrapConfig, client kubernetes.Interface) BootstrapGetter {
+func NewTokenBootStrapper(config BootstrapConfig, client kubernetes.Interface, clusterName string) BootstrapGetter {
 	return &TokenBootStrapper{
-		config: config,
-		client: client,
+		config:      config,
+		client:      client,
+		clusterName: clusterName,
 	}
 }
 
+func (g *TokenBootStrapper) Revoke() error {
+	err := g.client.CoreV1().Secrets(g.config.SANamespace).Delete(context.TODO(), g.bindingSecretName(), metav1.DeleteOptions{})
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+func (g *TokenBootStrapper) bindingSecretName() string {
+	return fmt.Sprintf("%s-bootstrap-token", g.clusterName)
+}
+
+// bindingSecret gets or creates the secret which the tokens of the cluster are bound to.
+func (g *TokenBootStrapper) bindingSecret() (*corev1.Secret, error) {
+	secret, err := g.client.CoreV1().Secrets(g.config.SANamespace).Get(context.TODO(), g.bindingSecretName(), metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return g.client.CoreV1().Secrets(g.config.SANamespace).Create(context.TODO(), &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      g.bindingSecretName(),
+				Namespace: g.config.SANamespace,
+				Labels: map[string]string{
+					LabelClusterName: g.clusterName,
+				},
+			},
+		}, metav1.CreateOptions{})
+	}
+	return secret, err
+}
+
 func (g *TokenBootStrapper) KubeConfigRaw() ([]byte, error) {
-	clientConfig, err := g.KubeConfig()
+	return rawBootstrapKubeConfig(g)
+}
+
+func (g *TokenBootStrapper) KubeConfig() (clientcmdapiv1.Config, error) {
+	secret, err := g.bindingSecret()
 	if err != nil {
-		return nil, err
+		return clientcmdapiv1.Config{}, err
 	}
 
-	bootstrapConfigBytes, err := yaml.Marshal(clientConfig)
+	token, err := createToken(g.client, g.config, g.config.SAName, &authv1.BoundObjectReference{
+		Kind:       "Secret",
+		APIVersion: "v1",
+		Name:       secret.Name,
+		UID:        secret.UID,
+	})
 	if err != nil {
-		return nil, err
+		return clientcmdapiv1.Config{}, err
 	}
-
-	return bootstrapConfigBytes, nil
+	return bootstrapKubeConfig(g.client, g.config, clientcmdapiv1.AuthInfo{Token: token})
 }
 
-func (g *TokenBootStrapper) KubeConfig() (clientcmdapiv1.Config, error) {
-	saToken, err := g.client.CoreV1().ServiceAccounts(g.config.SANamespace).CreateToken(
+// createToken requests a token of the service account expiring after the token expiration of the config
+func createToken(client kubernetes.Interface, config BootstrapConfig, saName string, boundObject *authv1.BoundObjectReference) (string, error) {
+	saToken, err := client.CoreV1().ServiceAccounts(config.SANamespace).CreateToken(
 		context.TODO(),
-		g.config.SAName,
+		saName,
 		&authv1.TokenRequest{
 			Spec: authv1.TokenRequestSpec{
-				ExpirationSeconds: pointer.Int64(8640 * 3600),
+				ExpirationSeconds: pointer.Int64(int64(config.TokenExpiration.Seconds())),
+				BoundObjectRef:    boundObject,
 			},
 		},
 		metav1.CreateOptions{})
 	if err != nil {
-		return clientcmdapiv1.Config{}, err
+		return "", err
+	}
+	return saToken.Status.Token, nil
+}
+
+func rawBootstrapKubeConfig(g BootstrapGetter) ([]byte, error) {
+	clientConfig, err := g.KubeConfig()
+	if err != nil {
+		return nil, err
 	}
 
+	bootstrapConfigBytes, err := yaml.Marshal(clientConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return bootstrapConfigBytes, nil
+}
+
+// bootstrapKubeConfig builds the bootstrap kubeconfig to the hub with the credentials of the auth info
+func bootstrapKubeConfig(client kubernetes.Interface, config BootstrapConfig, authInfo clientcmdapiv1.AuthInfo) (clientcmdapiv1.Config, error) {
 	clientConfig := clientcmdapiv1.Config{
 		// Define a cluster stanza based on the bootstrap kubeconfig.
 		Clusters: []clientcmdapiv1.NamedCluster{
 			{
 				Name: "hub",
 				Cluster: clientcmdapiv1.Cluster{
-					Server: g.config.HubAPIServer,
+					Server:        config.HubAPIServer,
+					ProxyURL:      config.ProxyURL,
+					TLSServerName: config.TLSServerName,
 				},
 			},
 		},
-		// Define auth based on the obtained client cert.
+		// Define auth based on the obtained token or client cert.
 		AuthInfos: []clientcmdapiv1.NamedAuthInfo{
 			{
-				Name: "bootstrap",
-				AuthInfo: clientcmdapiv1.AuthInfo{
-					Token: saToken.Status.Token,
-				},
+				Name:     "bootstrap",
+				AuthInfo: authInfo,
 			},
 		},
 		// Define a context that connects the auth info and cluster, and set it as the default
@@ -99,21 +264,34 @@ func (g *TokenBootStrapper) KubeConfig() (clientcmdapiv1.Config, error) {
 		CurrentContext: "bootstrap",
 	}
 
-	if g.config.CA != nil {
+	if config.CA != nil {
 		// directly set ca-data if --ca-file is set
-		clientConfig.Clusters[0].Cluster.CertificateAuthorityData = g.config.CA
+		clientConfig.Clusters[0].Cluster.CertificateAuthorityData = config.CA
 	} else {
 		// get ca data from, ca may empty(cluster-info exists with no ca data)
-		ca, err := getCACert(g.client)
+		ca, err := getCACert(client)
 		if err != nil {
 			return clientConfig, err
 		}
 		clientConfig.Clusters[0].Cluster.CertificateAuthorityData = ca
 	}
+	if len(config.CABundle) > 0 {
+		clientConfig.Clusters[0].Cluster.CertificateAuthorityData = appendCABundle(
+			clientConfig.Clusters[0].Cluster.CertificateAuthorityData, config.CABundle)
+	}
 
 	return clientConfig, nil
 }
 
+// appendCABundle appends the CA bundle to the CA data, the PEM blocks are separated by a new line
+func appendCABundle(ca, bundle []byte) []byte {
+	data := append([]byte{}, ca...)
+	if len(data) > 0 && !bytes.HasSuffix(data, []byte("\n")) {
+		data = append(data, '\n')
+	}
+	return append(data, bundle...)
+}
+
 func getCACert(kubeClient kubernetes.Interface) ([]byte, error) {
 	config, err := getClusterInfoKubeConfig(kubeClient)
 	if err == nil {
@@ -0,0 +1,369 @@
+// Copyright Contributors to the Open Cluster Management project
+package join
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	authv1 "k8s.io/api/authentication/v1"
+	certificatesv1 "k8s.io/api/certificates/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+	clientcmdapiv1 "k8s.io/client-go/tools/clientcmd/api/v1"
+)
+
+// withTokenRequests makes the fake client issue the tokens token1, token2 and so on for the token
+// requests of the service accounts, the tracker of the fake client does not support them.
+func withTokenRequests(client *kubefake.Clientset) *kubefake.Clientset {
+	issued := 0
+	client.PrependReactor("create", "serviceaccounts", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		if action.GetSubresource() != "token" {
+			return false, nil, nil
+		}
+		issued++
+		return true, &authv1.TokenRequest{
+			Status: authv1.TokenRequestStatus{Token: fmt.Sprintf("token%d", issued)},
+		}, nil
+	})
+	return client
+}
+
+// tokenRequests returns the number of the tokens requested from the fake client
+func tokenRequests(client *kubefake.Clientset) int {
+	count := 0
+	for _, action := range client.Actions() {
+		if action.GetVerb() == "create" && action.GetSubresource() == "token" {
+			count++
+		}
+	}
+	return count
+}
+
+// withCertificates makes the fake client issue the certificate of a CSR once it is approved
+func withCertificates(client *kubefake.Clientset) *kubefake.Clientset {
+	client.PrependReactor("update", "certificatesigningrequests", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		if action.GetSubresource() == "approval" {
+			csr := action.(clienttesting.UpdateAction).GetObject().(*certificatesv1.CertificateSigningRequest)
+			csr.Status.Certificate = []byte("certificate")
+		}
+		return false, nil, nil
+	})
+	return client
+}
+
+// deletedCollection returns the label selector of the collection deleted from the resource
+func deletedCollection(client *kubefake.Clientset, resource string) (string, bool) {
+	for _, action := range client.Actions() {
+		if action.GetVerb() == "delete-collection" && action.GetResource().Resource == resource {
+			return action.(clienttesting.DeleteCollectionAction).GetListRestrictions().Labels.String(), true
+		}
+	}
+	return "", false
+}
+
+func TestBootstrapConfigValidate(t *testing.T) {
+	cases := []struct {
+		name        string
+		strategy    string
+		expiration  time.Duration
+		server      string
+		proxyURL    string
+		hostAliasIP string
+		expectedErr bool
+	}{
+		{
+			name:       "default strategy",
+			expiration: time.Hour,
+		},
+		{
+			name:       "bootstrap token",
+			strategy:   BootstrapStrategyBootstrapToken,
+			expiration: time.Hour,
+		},
+		{
+			name:       "service account",
+			strategy:   BootstrapStrategyServiceAccount,
+			expiration: 10 * time.Minute,
+		},
+		{
+			name:       "csr",
+			strategy:   BootstrapStrategyCSR,
+			expiration: time.Hour,
+		},
+		{
+			name:        "unknown strategy",
+			strategy:    "foo",
+			expiration:  time.Hour,
+			expectedErr: true,
+		},
+		{
+			name:        "proxy and host alias",
+			expiration:  time.Hour,
+			server:      "https://hub.example.com:6443",
+			proxyURL:    "http://proxy.example.com:3128",
+			hostAliasIP: "10.0.0.1",
+		},
+		{
+			name:        "unsupported proxy",
+			expiration:  time.Hour,
+			proxyURL:    "ftp://proxy.example.com",
+			expectedErr: true,
+		},
+		{
+			name:        "host alias of an IP server",
+			expiration:  time.Hour,
+			server:      "https://10.0.0.2:6443",
+			hostAliasIP: "10.0.0.1",
+			expectedErr: true,
+		},
+		{
+			name:        "expiration too short",
+			strategy:    BootstrapStrategyToken,
+			expiration:  time.Minute,
+			expectedErr: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			config := BootstrapConfig{
+				Strategy:        c.strategy,
+				TokenExpiration: c.expiration,
+				HubAPIServer:    c.server,
+				ProxyURL:        c.proxyURL,
+				HostAliasIP:     c.hostAliasIP,
+			}
+			err := config.Validate()
+			if c.expectedErr != (err != nil) {
+				t.Errorf("expected error %v, but got %v", c.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestRandomString(t *testing.T) {
+	s, err := randomString(16)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(s) != 16 {
+		t.Errorf("expected 16 characters, but got %q", s)
+	}
+	if strings.Trim(s, bootstrapTokenChars) != "" {
+		t.Errorf("unexpected characters in %q", s)
+	}
+}
+
+func TestAppendCABundle(t *testing.T) {
+	cases := []struct {
+		name     string
+		ca       string
+		expected string
+	}{
+		{
+			name:     "no ca",
+			expected: "bundle\n",
+		},
+		{
+			name:     "ca without new line",
+			ca:       "ca",
+			expected: "ca\nbundle\n",
+		},
+		{
+			name:     "ca with new line",
+			ca:       "ca\n",
+			expected: "ca\nbundle\n",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			data := appendCABundle([]byte(c.ca), []byte("bundle\n"))
+			if string(data) != c.expected {
+				t.Errorf("expected %q, but got %q", c.expected, string(data))
+			}
+		})
+	}
+}
+
+func TestBootstrapGetters(t *testing.T) {
+	config := BootstrapConfig{
+		CA:              []byte("ca"),
+		HubAPIServer:    "https://hub:6443",
+		SAName:          "bootstrap",
+		SANamespace:     "open-cluster-management",
+		TokenExpiration: time.Hour,
+	}
+	selector := LabelClusterName + "=cluster1"
+
+	cases := []struct {
+		name           string
+		strategy       string
+		validateIssue  func(t *testing.T, authInfo clientcmdapiv1.AuthInfo, client *kubefake.Clientset)
+		validateRevoke func(t *testing.T, client *kubefake.Clientset)
+	}{
+		{
+			name:     "tokens bound to the secret of the cluster",
+			strategy: BootstrapStrategyToken,
+			validateIssue: func(t *testing.T, authInfo clientcmdapiv1.AuthInfo, client *kubefake.Clientset) {
+				if authInfo.Token != "token1" {
+					t.Errorf("expected token1, but got %q", authInfo.Token)
+				}
+				secret, err := client.CoreV1().Secrets(config.SANamespace).Get(context.TODO(), "cluster1-bootstrap-token", metav1.GetOptions{})
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, action := range client.Actions() {
+					if action.GetSubresource() != "token" {
+						continue
+					}
+					request := action.(clienttesting.CreateAction).GetObject().(*authv1.TokenRequest)
+					if request.Spec.BoundObjectRef == nil || request.Spec.BoundObjectRef.Name != secret.Name {
+						t.Errorf("expected the token is bound to secret %s, but got %v", secret.Name, request.Spec.BoundObjectRef)
+					}
+					if *request.Spec.ExpirationSeconds != 3600 {
+						t.Errorf("expected the token expires in 3600s, but got %d", *request.Spec.ExpirationSeconds)
+					}
+				}
+			},
+			validateRevoke: func(t *testing.T, client *kubefake.Clientset) {
+				_, err := client.CoreV1().Secrets(config.SANamespace).Get(context.TODO(), "cluster1-bootstrap-token", metav1.GetOptions{})
+				if err == nil {
+					t.Errorf("expected the secret bound by the tokens is deleted")
+				}
+			},
+		},
+		{
+			name:     "bootstrap tokens in kube-system",
+			strategy: BootstrapStrategyBootstrapToken,
+			validateIssue: func(t *testing.T, authInfo clientcmdapiv1.AuthInfo, client *kubefake.Clientset) {
+				matches := regexp.MustCompile(`^([a-z0-9]{6})\.([a-z0-9]{16})$`).FindStringSubmatch(authInfo.Token)
+				if matches == nil {
+					t.Fatalf("expected a bootstrap token, but got %q", authInfo.Token)
+				}
+				secret, err := client.CoreV1().Secrets(bootstrapTokenNamespace).Get(context.TODO(), "bootstrap-token-"+matches[1], metav1.GetOptions{})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if secret.Type != corev1.SecretTypeBootstrapToken || secret.Labels[LabelClusterName] != "cluster1" {
+					t.Errorf("expected a bootstrap token secret of the cluster, but got %v %v", secret.Type, secret.Labels)
+				}
+				if secret.StringData["token-secret"] != matches[2] || secret.StringData["auth-extra-groups"] != BootstrapTokenGroup {
+					t.Errorf("unexpected bootstrap token secret %v", secret.StringData)
+				}
+			},
+			validateRevoke: func(t *testing.T, client *kubefake.Clientset) {
+				if labels, ok := deletedCollection(client, "secrets"); !ok || labels != selector {
+					t.Errorf("expected the bootstrap tokens with %s are deleted, but got %q", selector, labels)
+				}
+			},
+		},
+		{
+			name:     "service account of the cluster",
+			strategy: BootstrapStrategyServiceAccount,
+			validateIssue: func(t *testing.T, authInfo clientcmdapiv1.AuthInfo, client *kubefake.Clientset) {
+				if authInfo.Token != "token1" {
+					t.Errorf("expected token1, but got %q", authInfo.Token)
+				}
+				if _, err := client.CoreV1().ServiceAccounts(config.SANamespace).Get(
+					context.TODO(), "cluster1-bootstrap", metav1.GetOptions{}); err != nil {
+					t.Error(err)
+				}
+				binding, err := client.RbacV1().ClusterRoleBindings().Get(
+					context.TODO(), BootstrapClusterRole+":cluster1", metav1.GetOptions{})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if binding.RoleRef.Name != BootstrapClusterRole || binding.Subjects[0].Name != "cluster1-bootstrap" {
+					t.Errorf("expected the service account is bound to the bootstrap role, but got %v", binding)
+				}
+			},
+			validateRevoke: func(t *testing.T, client *kubefake.Clientset) {
+				if _, err := client.CoreV1().ServiceAccounts(config.SANamespace).Get(
+					context.TODO(), "cluster1-bootstrap", metav1.GetOptions{}); err == nil {
+					t.Errorf("expected the service account is deleted")
+				}
+				if _, err := client.RbacV1().ClusterRoleBindings().Get(
+					context.TODO(), BootstrapClusterRole+":cluster1", metav1.GetOptions{}); err == nil {
+					t.Errorf("expected the binding is deleted")
+				}
+			},
+		},
+		{
+			name:     "client certificates of the cluster",
+			strategy: BootstrapStrategyCSR,
+			validateIssue: func(t *testing.T, authInfo clientcmdapiv1.AuthInfo, client *kubefake.Clientset) {
+				if string(authInfo.ClientCertificateData) != "certificate" || len(authInfo.ClientKeyData) == 0 {
+					t.Errorf("expected the client certificate and key, but got %v", authInfo)
+				}
+				csrs, err := client.CertificatesV1().CertificateSigningRequests().List(context.TODO(), metav1.ListOptions{})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(csrs.Items) != 1 {
+					t.Fatalf("expected one csr, but got %d", len(csrs.Items))
+				}
+				csr := csrs.Items[0]
+				if csr.Spec.SignerName != certificatesv1.KubeAPIServerClientSignerName || *csr.Spec.ExpirationSeconds != 3600 {
+					t.Errorf("unexpected csr %v", csr.Spec)
+				}
+				if len(csr.Status.Conditions) != 1 || csr.Status.Conditions[0].Type != certificatesv1.CertificateApproved {
+					t.Errorf("expected the csr is approved, but got %v", csr.Status.Conditions)
+				}
+				binding, err := client.RbacV1().ClusterRoleBindings().Get(
+					context.TODO(), BootstrapClusterRole+":csr:cluster1", metav1.GetOptions{})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if binding.Subjects[0].Name != BootstrapClusterRole+":cluster1" {
+					t.Errorf("expected the user of the certificate is bound, but got %v", binding.Subjects)
+				}
+			},
+			validateRevoke: func(t *testing.T, client *kubefake.Clientset) {
+				if _, err := client.RbacV1().ClusterRoleBindings().Get(
+					context.TODO(), BootstrapClusterRole+":csr:cluster1", metav1.GetOptions{}); err == nil {
+					t.Errorf("expected the binding is deleted")
+				}
+				if labels, ok := deletedCollection(client, "certificatesigningrequests"); !ok || labels != selector {
+					t.Errorf("expected the csrs with %s are deleted, but got %q", selector, labels)
+				}
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			client := withCertificates(withTokenRequests(kubefake.NewSimpleClientset()))
+			config := config
+			config.Strategy = c.strategy
+			getter, err := NewBootstrapGetter(config, client, "cluster1")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			kubeConfig, err := getter.KubeConfig()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(kubeConfig.AuthInfos) != 1 || len(kubeConfig.Clusters) != 1 {
+				t.Fatalf("unexpected kubeconfig %v", kubeConfig)
+			}
+			if server := kubeConfig.Clusters[0].Cluster.Server; server != config.HubAPIServer {
+				t.Errorf("expected server %s, but got %s", config.HubAPIServer, server)
+			}
+			c.validateIssue(t, kubeConfig.AuthInfos[0].AuthInfo, client)
+
+			if err := getter.Revoke(); err != nil {
+				t.Fatal(err)
+			}
+			c.validateRevoke(t, client)
+		})
+	}
+}
@@ -0,0 +1,76 @@
+// Copyright Contributors to the Open Cluster Management project
+package join
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apiextensionsclient "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+const (
+	// crdEstablishTimeout is how long to wait for a CRD to be established, the import fails and is
+	// retried if the CRD is not established in time.
+	crdEstablishTimeout  = 30 * time.Second
+	crdEstablishInterval = time.Second
+)
+
+var crdGVK = apiextensionsv1.SchemeGroupVersion.WithKind("CustomResourceDefinition")
+
+// waitForCRDs waits for the CRDs applied in the report to be established, the error is set on the
+// result of the CRD which is not established in the timeout.
+func waitForCRDs(ctx context.Context, client apiextensionsclient.Interface, report ApplyReport, timeout time.Duration) {
+	for i := range report {
+		if report[i].Error != nil || report[i].GVK != crdGVK {
+			continue
+		}
+		report[i].Error = waitForCRD(ctx, client, report[i].Name, timeout)
+	}
+}
+
+// waitForCRD stops waiting once the names of the CRD are rejected, the CRD is never established
+// until it is fixed.
+func waitForCRD(ctx context.Context, client apiextensionsclient.Interface, name string, timeout time.Duration) error {
+	err := wait.PollUntilContextTimeout(ctx, crdEstablishInterval, timeout, true, func(ctx context.Context) (bool, error) {
+		crd, err := client.ApiextensionsV1().CustomResourceDefinitions().Get(ctx, name, metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		if rejected := crdNamesRejected(crd); rejected != nil {
+			return false, fmt.Errorf("names of CRD %s are not accepted: %s", name, rejected.Message)
+		}
+		return crdEstablished(crd), nil
+	})
+	if wait.Interrupted(err) {
+		return fmt.Errorf("CRD %s is not established in %s", name, timeout)
+	}
+	return err
+}
+
+func crdEstablished(crd *apiextensionsv1.CustomResourceDefinition) bool {
+	for _, condition := range crd.Status.Conditions {
+		if condition.Type == apiextensionsv1.Established {
+			return condition.Status == apiextensionsv1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// crdNamesRejected returns the NamesAccepted condition of the CRD if its names conflict with another CRD
+func crdNamesRejected(crd *apiextensionsv1.CustomResourceDefinition) *apiextensionsv1.CustomResourceDefinitionCondition {
+	for i := range crd.Status.Conditions {
+		condition := &crd.Status.Conditions[i]
+		if condition.Type == apiextensionsv1.NamesAccepted && condition.Status == apiextensionsv1.ConditionFalse {
+			return condition
+		}
+	}
+	return nil
+}
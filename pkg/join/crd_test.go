@@ -0,0 +1,139 @@
+// Copyright Contributors to the Open Cluster Management project
+package join
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apiextensionsfake "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset/fake"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestCRDEstablished(t *testing.T) {
+	cases := []struct {
+		name       string
+		conditions []apiextensionsv1.CustomResourceDefinitionCondition
+		expected   bool
+	}{
+		{
+			name: "no conditions",
+		},
+		{
+			name: "names accepted only",
+			conditions: []apiextensionsv1.CustomResourceDefinitionCondition{
+				{Type: apiextensionsv1.NamesAccepted, Status: apiextensionsv1.ConditionTrue},
+			},
+		},
+		{
+			name: "not established",
+			conditions: []apiextensionsv1.CustomResourceDefinitionCondition{
+				{Type: apiextensionsv1.NamesAccepted, Status: apiextensionsv1.ConditionTrue},
+				{Type: apiextensionsv1.Established, Status: apiextensionsv1.ConditionFalse},
+			},
+		},
+		{
+			name: "established",
+			conditions: []apiextensionsv1.CustomResourceDefinitionCondition{
+				{Type: apiextensionsv1.NamesAccepted, Status: apiextensionsv1.ConditionTrue},
+				{Type: apiextensionsv1.Established, Status: apiextensionsv1.ConditionTrue},
+			},
+			expected: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			crd := &apiextensionsv1.CustomResourceDefinition{
+				Status: apiextensionsv1.CustomResourceDefinitionStatus{Conditions: c.conditions},
+			}
+			if established := crdEstablished(crd); established != c.expected {
+				t.Errorf("expected %v, but got %v", c.expected, established)
+			}
+		})
+	}
+}
+
+func newCRD(name string, conditions ...apiextensionsv1.CustomResourceDefinitionCondition) *apiextensionsv1.CustomResourceDefinition {
+	return &apiextensionsv1.CustomResourceDefinition{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Status:     apiextensionsv1.CustomResourceDefinitionStatus{Conditions: conditions},
+	}
+}
+
+func TestWaitForCRDs(t *testing.T) {
+	crdName := "klusterlets.operator.open-cluster-management.io"
+	cases := []struct {
+		name        string
+		crds        []runtime.Object
+		expectedErr string
+		// stopEarly is true if the wait stops before the timeout on the error
+		stopEarly bool
+	}{
+		{
+			name: "established",
+			crds: []runtime.Object{newCRD(crdName,
+				apiextensionsv1.CustomResourceDefinitionCondition{Type: apiextensionsv1.NamesAccepted, Status: apiextensionsv1.ConditionTrue},
+				apiextensionsv1.CustomResourceDefinitionCondition{Type: apiextensionsv1.Established, Status: apiextensionsv1.ConditionTrue},
+			)},
+		},
+		{
+			name: "never established",
+			crds: []runtime.Object{newCRD(crdName,
+				apiextensionsv1.CustomResourceDefinitionCondition{Type: apiextensionsv1.NamesAccepted, Status: apiextensionsv1.ConditionTrue},
+			)},
+			expectedErr: "is not established",
+		},
+		{
+			name:        "not found",
+			expectedErr: "is not established",
+		},
+		{
+			name: "names rejected",
+			crds: []runtime.Object{newCRD(crdName,
+				apiextensionsv1.CustomResourceDefinitionCondition{
+					Type:    apiextensionsv1.NamesAccepted,
+					Status:  apiextensionsv1.ConditionFalse,
+					Message: "klusterlets is already in use",
+				},
+			)},
+			expectedErr: "klusterlets is already in use",
+			stopEarly:   true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			client := apiextensionsfake.NewSimpleClientset(c.crds...)
+			report := ApplyReport{
+				{File: "join/klusterlets.crd.yaml", GVK: crdGVK, Name: crdName},
+				// the other manifests are not waited
+				{File: "join/namespace.yaml", Name: "open-cluster-management-agent"},
+			}
+
+			timeout := 100 * time.Millisecond
+			start := time.Now()
+			waitForCRDs(context.TODO(), client, report, timeout)
+			if c.stopEarly && time.Since(start) >= timeout {
+				t.Errorf("expected the wait stops before the timeout")
+			}
+
+			if report[1].Error != nil {
+				t.Errorf("unexpected error of the namespace: %v", report[1].Error)
+			}
+			err := report[0].Error
+			if len(c.expectedErr) == 0 {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), c.expectedErr) {
+				t.Errorf("expected error %q, but got %v", c.expectedErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,193 @@
+// Copyright Contributors to the Open Cluster Management project
+package join
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509/pkix"
+	"fmt"
+	"time"
+
+	certificatesv1 "k8s.io/api/certificates/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	clientcmdapiv1 "k8s.io/client-go/tools/clientcmd/api/v1"
+	"k8s.io/client-go/util/cert"
+	"k8s.io/client-go/util/keyutil"
+	"k8s.io/utils/pointer"
+)
+
+const (
+	// csrPollInterval is the interval to check whether the certificate of the approved CSR is issued
+	csrPollInterval = time.Second
+	// csrTimeout is how long to wait for the certificate of the approved CSR to be issued
+	csrTimeout = 30 * time.Second
+)
+
+// CSRBootStrapper issues client certificates signed by the hub apiserver for the cluster. The CSR
+// is created and approved by the importer, and the user of the certificate is bound to the bootstrap
+// cluster role. The certificates can not be revoked, so deleting the binding revokes the bootstrap
+// permissions of the certificates issued for the cluster.
+type CSRBootStrapper struct {
+	config       BootstrapConfig
+	client       kubernetes.Interface
+	clusterName  string
+	pollInterval time.Duration
+	timeout      time.Duration
+}
+
+func NewCSRBootStrapper(config BootstrapConfig, client kubernetes.Interface, clusterName string) BootstrapGetter {
+	return &CSRBootStrapper{
+		config:       config,
+		client:       client,
+		clusterName:  clusterName,
+		pollInterval: csrPollInterval,
+		timeout:      csrTimeout,
+	}
+}
+
+// userName is the common name of the certificates issued for the cluster
+func (g *CSRBootStrapper) userName() string {
+	return fmt.Sprintf("%s:%s", BootstrapClusterRole, g.clusterName)
+}
+
+func (g *CSRBootStrapper) clusterRoleBindingName() string {
+	return fmt.Sprintf("%s:csr:%s", BootstrapClusterRole, g.clusterName)
+}
+
+func (g *CSRBootStrapper) KubeConfigRaw() ([]byte, error) {
+	return rawBootstrapKubeConfig(g)
+}
+
+// KubeConfig issues a new client certificate for the cluster, the certificate expires after the
+// token expiration of the config.
+func (g *CSRBootStrapper) KubeConfig() (clientcmdapiv1.Config, error) {
+	if err := g.ensureClusterRoleBinding(); err != nil {
+		return clientcmdapiv1.Config{}, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return clientcmdapiv1.Config{}, err
+	}
+	keyData, err := keyutil.MarshalPrivateKeyToPEM(key)
+	if err != nil {
+		return clientcmdapiv1.Config{}, err
+	}
+	certData, err := g.issueCertificate(key)
+	if err != nil {
+		return clientcmdapiv1.Config{}, err
+	}
+
+	return bootstrapKubeConfig(g.client, g.config, clientcmdapiv1.AuthInfo{
+		ClientCertificateData: certData,
+		ClientKeyData:         keyData,
+	})
+}
+
+// issueCertificate creates and approves a CSR of the key, and waits for the certificate to be issued
+func (g *CSRBootStrapper) issueCertificate(key *ecdsa.PrivateKey) ([]byte, error) {
+	request, err := cert.MakeCSR(key, &pkix.Name{CommonName: g.userName()}, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.TODO()
+	csrClient := g.client.CertificatesV1().CertificateSigningRequests()
+	csr, err := csrClient.Create(ctx, &certificatesv1.CertificateSigningRequest{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: fmt.Sprintf("%s-bootstrap-", g.clusterName),
+			Labels: map[string]string{
+				LabelClusterName: g.clusterName,
+			},
+		},
+		Spec: certificatesv1.CertificateSigningRequestSpec{
+			Request:           request,
+			SignerName:        certificatesv1.KubeAPIServerClientSignerName,
+			ExpirationSeconds: pointer.Int32(int32(g.config.TokenExpiration.Seconds())),
+			Usages: []certificatesv1.KeyUsage{
+				certificatesv1.UsageDigitalSignature,
+				certificatesv1.UsageClientAuth,
+			},
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	csr.Status.Conditions = append(csr.Status.Conditions, certificatesv1.CertificateSigningRequestCondition{
+		Type:    certificatesv1.CertificateApproved,
+		Status:  corev1.ConditionTrue,
+		Reason:  "AutoApprovedByImporter",
+		Message: fmt.Sprintf("bootstrap certificate of cluster %s is approved by the importer", g.clusterName),
+	})
+	csr, err = csrClient.UpdateApproval(ctx, csr.Name, csr, metav1.UpdateOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	var certData []byte
+	err = wait.PollUntilContextTimeout(ctx, g.pollInterval, g.timeout, true, func(ctx context.Context) (bool, error) {
+		issued, err := csrClient.Get(ctx, csr.Name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		for _, condition := range issued.Status.Conditions {
+			if condition.Type == certificatesv1.CertificateDenied || condition.Type == certificatesv1.CertificateFailed {
+				return false, fmt.Errorf("csr %s is %s: %s", csr.Name, condition.Type, condition.Message)
+			}
+		}
+		certData = issued.Status.Certificate
+		return len(certData) > 0, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to issue the certificate of csr %s: %v", csr.Name, err)
+	}
+	return certData, nil
+}
+
+// ensureClusterRoleBinding binds the user of the certificates of the cluster to the bootstrap cluster role
+func (g *CSRBootStrapper) ensureClusterRoleBinding() error {
+	_, err := g.client.RbacV1().ClusterRoleBindings().Create(context.TODO(), &rbacv1.ClusterRoleBinding{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: g.clusterRoleBindingName(),
+			Labels: map[string]string{
+				LabelClusterName: g.clusterName,
+			},
+		},
+		RoleRef: rbacv1.RoleRef{
+			APIGroup: rbacv1.GroupName,
+			Kind:     "ClusterRole",
+			Name:     BootstrapClusterRole,
+		},
+		Subjects: []rbacv1.Subject{
+			{
+				APIGroup: rbacv1.GroupName,
+				Kind:     rbacv1.UserKind,
+				Name:     g.userName(),
+			},
+		},
+	}, metav1.CreateOptions{})
+	if err != nil && !errors.IsAlreadyExists(err) {
+		return err
+	}
+	return nil
+}
+
+// Revoke deletes the binding of the user of the certificates and the CSRs of the cluster
+func (g *CSRBootStrapper) Revoke() error {
+	err := g.client.RbacV1().ClusterRoleBindings().Delete(context.TODO(), g.clusterRoleBindingName(), metav1.DeleteOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	return g.client.CertificatesV1().CertificateSigningRequests().DeleteCollection(context.TODO(), metav1.DeleteOptions{}, metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(labels.Set{LabelClusterName: g.clusterName}).String(),
+	})
+}
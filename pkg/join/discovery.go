@@ -0,0 +1,202 @@
+// Copyright Contributors to the Open Cluster Management project
+package join
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	configv1client "github.com/openshift/client-go/config/clientset/versioned/typed/config/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/klog/v2"
+)
+
+// reachableTimeout is the timeout to check whether the hub apiserver is reachable
+const reachableTimeout = 10 * time.Second
+
+// DiscoverHubAPIServer finds the URL of the hub apiserver for the bootstrap kubeconfig. The server in
+// the cluster-info of kube-public is tried at first, then the apiServerURL of the OpenShift
+// infrastructure, and the host of the kubeconfig the importer runs with. When the importer runs in the
+// hub cluster, the host is the address of the kubernetes service which the spokes are not able to
+// reach, so the endpoints of the kubernetes service are tried at last instead. The first URL which is
+// reachable as the bootstrap kubeconfig of the config does is returned.
+func DiscoverHubAPIServer(ctx context.Context, kubeClient kubernetes.Interface, configClient configv1client.ConfigV1Interface,
+	host string, config BootstrapConfig) (string, error) {
+	logger := klog.FromContext(ctx)
+	ca, err := hubCA(kubeClient, config)
+	if err != nil {
+		return "", err
+	}
+
+	candidates := []struct {
+		source  string
+		servers func() ([]string, error)
+	}{
+		{
+			source: "cluster-info",
+			servers: func() ([]string, error) {
+				config, err := getClusterInfoKubeConfig(kubeClient)
+				if err != nil {
+					return nil, err
+				}
+				if len(config.Clusters) != 1 {
+					return nil, fmt.Errorf("can not find the cluster in the cluster-info")
+				}
+				return []string{config.Clusters[0].Cluster.Server}, nil
+			},
+		},
+		{
+			source: "OpenShift infrastructure",
+			servers: func() ([]string, error) {
+				infrastructure, err := configClient.Infrastructures().Get(ctx, "cluster", metav1.GetOptions{})
+				if err != nil {
+					return nil, err
+				}
+				return []string{infrastructure.Status.APIServerURL}, nil
+			},
+		},
+		{
+			source: "kubeconfig of the importer",
+			servers: func() ([]string, error) {
+				return []string{host}, nil
+			},
+		},
+		{
+			source: "endpoints of the kubernetes service",
+			servers: func() ([]string, error) {
+				return kubernetesEndpoints(ctx, kubeClient)
+			},
+		},
+	}
+
+	var errs []error
+	for _, candidate := range candidates {
+		servers, err := candidate.servers()
+		switch {
+		case errors.IsNotFound(err):
+			continue
+		case err != nil:
+			errs = append(errs, fmt.Errorf("%s: %v", candidate.source, err))
+			continue
+		}
+
+		for _, server := range servers {
+			if len(server) == 0 {
+				continue
+			}
+			if err := validateHubAPIServer(server, ca, config); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %v", candidate.source, err))
+				continue
+			}
+			logger.Info("Discovered hub apiserver", "source", candidate.source, "server", server)
+			return server, nil
+		}
+	}
+	return "", fmt.Errorf("failed to discover the hub apiserver, set --hub-apiserver: %v", utilerrors.NewAggregate(errs))
+}
+
+// kubernetesEndpoints returns the URLs of the apiservers behind the kubernetes service of the cluster
+func kubernetesEndpoints(ctx context.Context, kubeClient kubernetes.Interface) ([]string, error) {
+	endpoints, err := kubeClient.CoreV1().Endpoints(metav1.NamespaceDefault).Get(ctx, "kubernetes", metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	var servers []string
+	for _, subset := range endpoints.Subsets {
+		for _, port := range subset.Ports {
+			if port.Name != "https" {
+				continue
+			}
+			for _, address := range subset.Addresses {
+				servers = append(servers, "https://"+net.JoinHostPort(address.IP, strconv.Itoa(int(port.Port))))
+			}
+		}
+	}
+	return servers, nil
+}
+
+// ValidateHubAPIServer checks that the hub apiserver of the config is reachable as the bootstrap
+// kubeconfig does, with the CA, the CA bundle, the TLS server name and the proxy of the config.
+// It is checked from the importer, the addresses which are only reachable inside the hub cluster
+// are rejected since the spokes are not able to reach them.
+func ValidateHubAPIServer(kubeClient kubernetes.Interface, config BootstrapConfig) error {
+	ca, err := hubCA(kubeClient, config)
+	if err != nil {
+		return err
+	}
+	return validateHubAPIServer(config.HubAPIServer, ca, config)
+}
+
+// hubCA returns the CA data of the bootstrap kubeconfig of the config
+func hubCA(kubeClient kubernetes.Interface, config BootstrapConfig) ([]byte, error) {
+	ca := config.CA
+	if ca == nil {
+		var err error
+		ca, err = getCACert(kubeClient)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(config.CABundle) > 0 {
+		ca = appendCABundle(ca, config.CABundle)
+	}
+	return ca, nil
+}
+
+func validateHubAPIServer(server string, ca []byte, config BootstrapConfig) error {
+	u, err := url.Parse(server)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "https" || len(u.Hostname()) == 0 {
+		return fmt.Errorf("hub apiserver %q is not a https URL", server)
+	}
+	if internalHost(u.Hostname()) {
+		return fmt.Errorf("hub apiserver %q is only reachable inside the hub cluster", server)
+	}
+
+	restConfig := &rest.Config{
+		Host: server,
+		TLSClientConfig: rest.TLSClientConfig{
+			CAData:     ca,
+			ServerName: config.TLSServerName,
+		},
+		Timeout: reachableTimeout,
+	}
+	if len(config.ProxyURL) > 0 {
+		proxyURL, err := url.Parse(config.ProxyURL)
+		if err != nil {
+			return fmt.Errorf("invalid hub proxy URL %q: %v", config.ProxyURL, err)
+		}
+		restConfig.Proxy = http.ProxyURL(proxyURL)
+	}
+	client, err := rest.HTTPClientFor(restConfig)
+	if err != nil {
+		return err
+	}
+	// any response means the apiserver is reachable, the request is not authenticated
+	resp, err := client.Get(strings.TrimSuffix(server, "/") + "/healthz")
+	if err != nil {
+		return fmt.Errorf("hub apiserver %q is not reachable: %v", server, err)
+	}
+	return resp.Body.Close()
+}
+
+// internalHost returns true if the host is the kubernetes service of the cluster the importer runs in,
+// the address of the service is virtual and only routed inside the cluster.
+func internalHost(host string) bool {
+	if host == os.Getenv("KUBERNETES_SERVICE_HOST") {
+		return true
+	}
+	return host == "kubernetes" || strings.HasPrefix(host, "kubernetes.default")
+}
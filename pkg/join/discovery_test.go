@@ -0,0 +1,226 @@
+// Copyright Contributors to the Open Cluster Management project
+package join
+
+import (
+	"context"
+	"encoding/pem"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	configv1 "github.com/openshift/api/config/v1"
+	configfake "github.com/openshift/client-go/config/clientset/versioned/fake"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+)
+
+// newHubServer starts a TLS server as the hub apiserver, and returns the PEM encoded CA of it
+func newHubServer(t *testing.T) (*httptest.Server, []byte) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	t.Cleanup(server.Close)
+	return server, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+}
+
+func TestValidateHubAPIServer(t *testing.T) {
+	server, ca := newHubServer(t)
+	_, otherCA := newHubServer(t)
+	// the certificate of the test server is valid for example.com but not for localhost
+	localhost := strings.Replace(server.URL, "127.0.0.1", "localhost", 1)
+
+	t.Setenv("KUBERNETES_SERVICE_HOST", "10.96.0.1")
+
+	cases := []struct {
+		name          string
+		server        string
+		ca            []byte
+		caBundle      []byte
+		tlsServerName string
+		proxyURL      string
+		expectedErr   bool
+	}{
+		{
+			name:   "reachable",
+			server: server.URL,
+			ca:     ca,
+		},
+		{
+			name:     "signed by the CA bundle",
+			server:   server.URL,
+			ca:       otherCA,
+			caBundle: ca,
+		},
+		{
+			name:        "server name not in the certificate",
+			server:      localhost,
+			ca:          ca,
+			expectedErr: true,
+		},
+		{
+			name:          "TLS server name",
+			server:        localhost,
+			ca:            ca,
+			tlsServerName: "example.com",
+		},
+		{
+			name:        "proxy not reachable",
+			server:      server.URL,
+			ca:          ca,
+			proxyURL:    "http://127.0.0.1:1",
+			expectedErr: true,
+		},
+		{
+			name:        "unknown CA",
+			server:      server.URL,
+			expectedErr: true,
+		},
+		{
+			name:        "not https",
+			server:      "http://hub.example.com:6443",
+			expectedErr: true,
+		},
+		{
+			name:        "kubernetes service",
+			server:      "https://kubernetes.default.svc:443",
+			expectedErr: true,
+		},
+		{
+			name:        "kubernetes service IP",
+			server:      "https://10.96.0.1:443",
+			expectedErr: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := ValidateHubAPIServer(kubefake.NewSimpleClientset(), BootstrapConfig{
+				HubAPIServer:  c.server,
+				CA:            c.ca,
+				CABundle:      c.caBundle,
+				TLSServerName: c.tlsServerName,
+				ProxyURL:      c.proxyURL,
+			})
+			if c.expectedErr != (err != nil) {
+				t.Errorf("expected error %v, but got %v", c.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestDiscoverHubAPIServer(t *testing.T) {
+	server, ca := newHubServer(t)
+	unreachable := "https://127.0.0.1:1"
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, err := strconv.Atoi(u.Port())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clusterInfo := func(server string) *corev1.ConfigMap {
+		return &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "cluster-info", Namespace: "kube-public"},
+			Data: map[string]string{"kubeconfig": `
+apiVersion: v1
+kind: Config
+clusters:
+- name: hub
+  cluster:
+    server: ` + server},
+		}
+	}
+	infrastructure := func(server string) *configv1.Infrastructure {
+		return &configv1.Infrastructure{
+			ObjectMeta: metav1.ObjectMeta{Name: "cluster"},
+			Status:     configv1.InfrastructureStatus{APIServerURL: server},
+		}
+	}
+	kubernetesEndpoints := &corev1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{Name: "kubernetes", Namespace: metav1.NamespaceDefault},
+		Subsets: []corev1.EndpointSubset{
+			{
+				Addresses: []corev1.EndpointAddress{{IP: u.Hostname()}},
+				Ports:     []corev1.EndpointPort{{Name: "https", Port: int32(port)}},
+			},
+		},
+	}
+
+	t.Setenv("KUBERNETES_SERVICE_HOST", "10.96.0.1")
+	inCluster := "https://" + net.JoinHostPort("10.96.0.1", "443")
+
+	cases := []struct {
+		name           string
+		kubeObjs       []runtime.Object
+		configObjs     []runtime.Object
+		host           string
+		ca             []byte
+		expectedServer string
+		expectedErr    bool
+	}{
+		{
+			name:           "server of the cluster-info",
+			kubeObjs:       []runtime.Object{clusterInfo(server.URL)},
+			configObjs:     []runtime.Object{infrastructure(unreachable)},
+			host:           unreachable,
+			ca:             ca,
+			expectedServer: server.URL,
+		},
+		{
+			name:           "apiServerURL of the OpenShift infrastructure",
+			kubeObjs:       []runtime.Object{clusterInfo(unreachable)},
+			configObjs:     []runtime.Object{infrastructure(server.URL)},
+			host:           unreachable,
+			ca:             ca,
+			expectedServer: server.URL,
+		},
+		{
+			name:           "host of the kubeconfig of the importer",
+			host:           server.URL,
+			ca:             ca,
+			expectedServer: server.URL,
+		},
+		{
+			name:           "endpoints of the kubernetes service in cluster",
+			kubeObjs:       []runtime.Object{kubernetesEndpoints},
+			host:           inCluster,
+			ca:             ca,
+			expectedServer: server.URL,
+		},
+		{
+			name:        "only the kubernetes service in cluster",
+			host:        inCluster,
+			ca:          ca,
+			expectedErr: true,
+		},
+		{
+			name:        "servers not signed by the CA",
+			kubeObjs:    []runtime.Object{clusterInfo(server.URL)},
+			host:        server.URL,
+			expectedErr: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			kubeClient := kubefake.NewSimpleClientset(c.kubeObjs...)
+			configClient := configfake.NewSimpleClientset(c.configObjs...)
+			discovered, err := DiscoverHubAPIServer(context.TODO(), kubeClient, configClient.ConfigV1(), c.host,
+				BootstrapConfig{CA: c.ca})
+			if c.expectedErr != (err != nil) {
+				t.Fatalf("expected error %v, but got %v", c.expectedErr, err)
+			}
+			if discovered != c.expectedServer {
+				t.Errorf("expected server %q, but got %q", c.expectedServer, discovered)
+			}
+		})
+	}
+}
@@ -2,10 +2,13 @@
 package join
 
 import (
+	"bytes"
 	"context"
+	"encoding/base64"
 	"fmt"
+	"text/template"
+	"time"
 
-	"github.com/openshift/library-go/pkg/assets"
 	"github.com/openshift/library-go/pkg/operator/events"
 	"github.com/openshift/library-go/pkg/operator/resource/resourceapply"
 	"github.com/qiujian16/capi-importer/pkg/join/scenario"
@@ -17,7 +20,6 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/serializer"
-	utilerrors "k8s.io/apimachinery/pkg/util/errors"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/clientcmd"
@@ -37,9 +39,11 @@ func init() {
 }
 
 type Builder struct {
-	values          Values
-	cache           resourceapply.ResourceCache
-	spokeKubeConfig clientcmd.ClientConfig
+	values            Values
+	cache             resourceapply.ResourceCache
+	spokeKubeConfig   clientcmd.ClientConfig
+	hostingKubeConfig clientcmd.ClientConfig
+	timeout           time.Duration
 }
 
 // Values: The values used in the template
@@ -52,11 +56,10 @@ type Values struct {
 	Hub Hub
 	//Klusterlet is the klusterlet related configuration
 	Klusterlet Klusterlet
-	//Registry is the image registry related configuration
-	Registry string
-	//bundle version
-	BundleVersion BundleVersion
-	// managed kubeconfig
+	//Images is the pull specs of the images deployed on the spoke
+	Images Images
+	// ManagedKubeconfig is the base64 encoded kubeconfig of the spoke used by the agents in the
+	// hosted mode, it is built from the spoke kubeconfig of the builder.
 	ManagedKubeconfig string
 
 	// Features is the slice of feature for registration
@@ -64,6 +67,25 @@ type Values struct {
 
 	// Features is the slice of feature for work
 	WorkFeatures []operatorv1.FeatureGate
+
+	// ImagePullSecret is the base64 encoded content of the docker config json to pull the images,
+	// it is rendered into the namespaces of the operator and the agent if it is set.
+	ImagePullSecret string
+}
+
+// Validate checks the values required to render the templates
+func (v Values) Validate() error {
+	return v.Images.Validate()
+}
+
+// KlusterletNamespace is the namespace of the secrets used by the agents on the cluster running
+// the klusterlet. In the hosted modes, it is the namespace named after the klusterlet on the
+// hosting cluster.
+func (v Values) KlusterletNamespace() string {
+	if v.Klusterlet.Hosted() {
+		return v.Klusterlet.Name
+	}
+	return v.AgentNamespace
 }
 
 // Hub: The hub values for the template
@@ -72,6 +94,8 @@ type Hub struct {
 	APIServer string
 	//KubeConfig: The kubeconfig of the bootstrap secret to connect to the hub
 	KubeConfig string
+	//HostAlias: The host alias of the hub apiserver for the agents
+	HostAlias *operatorv1.HubApiServerHostAlias
 }
 
 // Klusterlet is for templating klusterlet configuration
@@ -82,15 +106,13 @@ type Klusterlet struct {
 	Name      string
 }
 
-type BundleVersion struct {
-	// registration image version
-	RegistrationImageVersion string
-	// placement image version
-	PlacementImageVersion string
-	// work image version
-	WorkImageVersion string
-	// operator image version
-	OperatorImageVersion string
+// Hosted returns true if the klusterlet is deployed on a hosting cluster instead of the spoke
+func (k Klusterlet) Hosted() bool {
+	switch operatorv1.InstallMode(k.Mode) {
+	case operatorv1.InstallModeHosted, operatorv1.InstallModeSingletonHosted:
+		return true
+	}
+	return false
 }
 
 func NewBuilder() *Builder {
@@ -109,76 +131,219 @@ func (b *Builder) WithSpokeKubeConfig(config clientcmd.ClientConfig) *Builder {
 	return b
 }
 
-func (b *Builder) ApplyImport(ctx context.Context, recorder events.Recorder) error {
+// WithHostingKubeConfig sets the kubeconfig of the cluster to deploy the klusterlet on in the
+// hosted modes, the spoke is only accessed by the agents in these modes.
+func (b *Builder) WithHostingKubeConfig(config clientcmd.ClientConfig) *Builder {
+	b.hostingKubeConfig = config
+	return b
+}
+
+// WithTimeout sets the timeout of the requests to the spoke, so an unreachable spoke
+// does not block the caller.
+func (b *Builder) WithTimeout(timeout time.Duration) *Builder {
+	b.timeout = timeout
+	return b
+}
+
+// ApplyImport applies the klusterlet on the spoke, or on the hosting cluster in the hosted modes.
+// The result of each manifest is returned in the report, and the errors of the steps of the import
+// are returned as a PhaseError. The import stops at the first step which fails.
+func (b *Builder) ApplyImport(ctx context.Context, recorder events.Recorder) (ApplyReport, error) {
+	if err := b.values.Validate(); err != nil {
+		return nil, err
+	}
+
 	kubeClient, apiExtensionClient, operatorClient, err := b.getClients()
 	if err != nil {
-		return err
+		return nil, phaseError(PhaseSpokeConnected, err)
 	}
 
-	_, err = kubeClient.CoreV1().Namespaces().Get(ctx, b.values.AgentNamespace, metav1.GetOptions{})
-	if errors.IsNotFound(err) {
-		_, err = kubeClient.CoreV1().Namespaces().Create(ctx, &corev1.Namespace{
-			ObjectMeta: metav1.ObjectMeta{
-				Name: b.values.AgentNamespace,
-				Annotations: map[string]string{
-					"workload.openshift.io/allowed": "management",
-				},
-			},
-		}, metav1.CreateOptions{})
+	if b.values.Klusterlet.Hosted() {
+		managedKubeConfig, err := rawKubeConfig(b.spokeKubeConfig)
 		if err != nil {
-			return err
+			return nil, phaseError(PhaseSpokeConnected, err)
 		}
-	} else if err != nil {
-		return err
+		b.values.ManagedKubeconfig = base64.StdEncoding.EncodeToString(managedKubeConfig)
+	}
+
+	if err := ensureNamespace(ctx, kubeClient, b.values.KlusterletNamespace()); err != nil {
+		return nil, phaseError(PhaseSpokeConnected, err)
 	}
 
 	var files []string
 	files = append(files,
-		"join/klusterlets.crd.yaml",
 		"join/namespace.yaml",
 		"join/service_account.yaml",
 		"join/cluster_role.yaml",
 		"join/cluster_role_binding.yaml",
 		"bootstrap_hub_kubeconfig.yaml",
 	)
+	if len(b.values.ImagePullSecret) > 0 {
+		files = append(files,
+			"join/image_pull_secret.yaml",
+			"join/agent_image_pull_secret.yaml",
+		)
+	}
+	if b.values.Klusterlet.Hosted() {
+		files = append(files, "join/hosted/external_managed_kubeconfig.yaml")
+	}
+
+	// the kinds and names of the rendered manifests are kept for the report
+	manifests := map[string]ManifestResult{}
+	assetFunc := b.assetFunc(manifests)
+
+	clientHolder := resourceapply.NewKubeClientHolder(kubeClient).WithAPIExtensionsClient(apiExtensionClient)
+	// the CRDs applied are waited to be established, so the custom resources applied after them
+	// do not fail with no matches for kind.
+	applyFiles := func(files ...string) ApplyReport {
+		var report ApplyReport
+		for _, result := range resourceapply.ApplyDirectly(ctx, clientHolder, recorder, b.cache, assetFunc, files...) {
+			manifest := manifests[result.File]
+			manifest.File = result.File
+			manifest.Changed = result.Changed
+			manifest.Error = result.Error
+			report = append(report, manifest)
+		}
+		waitForCRDs(ctx, apiExtensionClient, report, crdEstablishTimeout)
+		return report
+	}
+
+	// nothing else is applied if the klusterlet CRD fails, the klusterlet CR depends on it
+	report := applyFiles("join/klusterlets.crd.yaml")
+	if err := report.Err(); err != nil {
+		return report, phaseError(PhaseCRDApplied, err)
+	}
+
+	report = append(report, applyFiles(files...)...)
+	if err := report.Err(); err != nil {
+		return report, phaseError(PhaseManifestsApplied, err)
+	}
+
+	result := b.applyDeployment(ctx, kubeClient, assetFunc, recorder, "join/operator.yaml")
+	result.GVK, result.Name = manifests[result.File].GVK, manifests[result.File].Name
+	report = append(report, result)
+	if err := report.Err(); err != nil {
+		return report, phaseError(PhaseOperatorDeployed, err)
+	}
+
+	result = b.applyKlusterlet(ctx, operatorClient, assetFunc, "join/klusterlets.cr.yaml")
+	result.GVK, result.Name = manifests[result.File].GVK, manifests[result.File].Name
+	report = append(report, result)
+	return report, phaseError(PhaseKlusterletApplied, report.Err())
+}
+
+// ApplyBootstrapKubeConfig updates the bootstrap hub kubeconfig secret of the klusterlet in place,
+// the agent uses it to bootstrap again when its hub kubeconfig is not valid any more.
+func (b *Builder) ApplyBootstrapKubeConfig(ctx context.Context, recorder events.Recorder) (ApplyReport, error) {
+	kubeClient, _, _, err := b.getClients()
+	if err != nil {
+		return nil, phaseError(PhaseSpokeConnected, err)
+	}
+
+	manifests := map[string]ManifestResult{}
+	var report ApplyReport
+	for _, result := range resourceapply.ApplyDirectly(ctx, resourceapply.NewKubeClientHolder(kubeClient), recorder, b.cache,
+		b.assetFunc(manifests), "bootstrap_hub_kubeconfig.yaml") {
+		manifest := manifests[result.File]
+		manifest.File = result.File
+		manifest.Changed = result.Changed
+		manifest.Error = result.Error
+		report = append(report, manifest)
+	}
+	return report, report.Err()
+}
 
-	assetFunc := func(name string) ([]byte, error) {
+// assetFunc renders the manifests with the values, the kinds and names of the rendered manifests
+// are recorded in the results.
+func (b *Builder) assetFunc(results map[string]ManifestResult) resourceapply.AssetFunc {
+	return func(name string) ([]byte, error) {
 		template, err := scenario.Files.ReadFile(name)
 		if err != nil {
 			return nil, err
 		}
-		objData := assets.MustCreateAssetFromTemplate(name, template, b.values).Data
-		return objData, nil
+		manifest, err := renderTemplate(name, template, b.values)
+		if err != nil {
+			return nil, err
+		}
+		results[name] = readManifest(name, manifest)
+		return manifest, nil
 	}
+}
 
-	clientHolder := resourceapply.NewKubeClientHolder(kubeClient).WithAPIExtensionsClient(apiExtensionClient)
-	applyResults := resourceapply.ApplyDirectly(
-		ctx,
-		clientHolder,
-		recorder,
-		b.cache,
-		assetFunc,
-		files...,
-	)
+// ensureNamespace creates the namespace if it does not exist, it is the first request to the
+// cluster running the klusterlet.
+func ensureNamespace(ctx context.Context, kubeClient kubernetes.Interface, namespace string) error {
+	_, err := kubeClient.CoreV1().Namespaces().Get(ctx, namespace, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		_, err = kubeClient.CoreV1().Namespaces().Create(ctx, &corev1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: namespace,
+				Annotations: map[string]string{
+					"workload.openshift.io/allowed": "management",
+				},
+			},
+		}, metav1.CreateOptions{})
+	}
+	return err
+}
 
-	var errs []error
-	for _, result := range applyResults {
-		if result.Error != nil {
-			errs = append(errs, err)
-		}
+// ApplyDetach deletes the klusterlet on the spoke, or on the hosting cluster in the hosted modes.
+// The klusterlet operator then removes the agents.
+func (b *Builder) ApplyDetach(ctx context.Context, recorder events.Recorder) error {
+	_, _, operatorClient, err := b.getClients()
+	if err != nil {
+		return err
 	}
 
-	_, err = b.applyDeployment(ctx, kubeClient, assetFunc, recorder, "join/operator.yaml")
+	err = operatorClient.OperatorV1().Klusterlets().Delete(ctx, b.values.Klusterlet.Name, metav1.DeleteOptions{})
+	if errors.IsNotFound(err) {
+		return nil
+	}
 	if err != nil {
-		errs = append(errs, err)
+		return err
 	}
+	recorder.Eventf("KlusterletDeleted", "klusterlet %s is deleted from cluster %s", b.values.Klusterlet.Name, b.values.ClusterName)
+	return nil
+}
 
-	_, err = b.applyKlusterlet(ctx, operatorClient, assetFunc, recorder, "join/klusterlets.cr.yaml")
+// KlusterletConditions returns the conditions of the klusterlet on the spoke, or on the hosting
+// cluster in the hosted modes, to tell why the agent does not join the hub.
+func (b *Builder) KlusterletConditions(ctx context.Context) ([]metav1.Condition, error) {
+	_, _, operatorClient, err := b.getClients()
 	if err != nil {
-		errs = append(errs, err)
+		return nil, err
+	}
+
+	klusterlet, err := operatorClient.OperatorV1().Klusterlets().Get(ctx, b.values.Klusterlet.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return klusterlet.Status.Conditions, nil
+}
+
+// renderTemplate renders the template with the values, it fails if any required value is missing
+// instead of rendering an empty value.
+func renderTemplate(name string, data []byte, values Values) ([]byte, error) {
+	tmpl, err := template.New(name).
+		Funcs(template.FuncMap{"required": required}).
+		Option("missingkey=error").
+		Parse(string(data))
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, values); err != nil {
+		return nil, fmt.Errorf("failed to render %s: %v", name, err)
 	}
+	return buf.Bytes(), nil
+}
 
-	return utilerrors.NewAggregate(errs)
+func required(name, value string) (string, error) {
+	if len(value) == 0 {
+		return "", fmt.Errorf("%s is required", name)
+	}
+	return value, nil
 }
 
 func (b *Builder) getClients() (
@@ -186,10 +351,22 @@ func (b *Builder) getClients() (
 	apiExtensionsClient apiextensionsclient.Interface,
 	operatorClient operatorclient.Interface,
 	err error) {
-	config, err := b.spokeKubeConfig.ClientConfig()
+	// the klusterlet is deployed on the hosting cluster in the hosted modes
+	clientConfig := b.spokeKubeConfig
+	if b.values.Klusterlet.Hosted() {
+		clientConfig = b.hostingKubeConfig
+	}
+	if clientConfig == nil {
+		return nil, nil, nil, fmt.Errorf("kubeconfig of the cluster to deploy klusterlet %s is not set", b.values.Klusterlet.Name)
+	}
+
+	config, err := clientConfig.ClientConfig()
 	if err != nil {
 		return nil, nil, nil, err
 	}
+	if b.timeout > 0 {
+		config.Timeout = b.timeout
+	}
 	kubeClient, err = kubernetes.NewForConfig(config)
 	if err != nil {
 		return
@@ -210,58 +387,63 @@ func (b *Builder) applyDeployment(
 	ctx context.Context,
 	client kubernetes.Interface,
 	manifests resourceapply.AssetFunc,
-	recorder events.Recorder, file string) (*appsv1.Deployment, error) {
+	recorder events.Recorder, file string) ManifestResult {
+	result := ManifestResult{File: file}
 	deploymentBytes, err := manifests(file)
 	if err != nil {
-		return nil, err
+		result.Error = err
+		return result
 	}
 	deployment, _, err := genericCodec.Decode(deploymentBytes, nil, nil)
 	if err != nil {
-		return nil, fmt.Errorf("%q: %v", file, err)
+		result.Error = fmt.Errorf("cannot decode %q: %v", file, err)
+		return result
 	}
 
-	updatedDeployment, _, err := resourceapply.ApplyDeployment(
+	_, result.Changed, result.Error = resourceapply.ApplyDeployment(
 		ctx,
 		client.AppsV1(),
 		recorder,
 		deployment.(*appsv1.Deployment), 0)
-	if err != nil {
-		return updatedDeployment, fmt.Errorf("%q (%T): %v", file, deployment, err)
-	}
-
-	return updatedDeployment, nil
+	return result
 }
 
 func (b *Builder) applyKlusterlet(
 	ctx context.Context,
 	client operatorclient.Interface,
 	manifests resourceapply.AssetFunc,
-	recorder events.Recorder, file string) (*operatorv1.Klusterlet, error) {
+	file string) ManifestResult {
+	result := ManifestResult{File: file}
 	klusterletBytes, err := manifests(file)
 	if err != nil {
-		return nil, err
+		result.Error = err
+		return result
 	}
 	object, _, err := genericCodec.Decode(klusterletBytes, nil, nil)
 	if err != nil {
-		return nil, fmt.Errorf("%q: %v", file, err)
+		result.Error = fmt.Errorf("cannot decode %q: %v", file, err)
+		return result
 	}
 
 	desired := object.(*operatorv1.Klusterlet)
 
 	existing, err := client.OperatorV1().Klusterlets().Get(ctx, desired.Name, metav1.GetOptions{})
 	if errors.IsNotFound(err) {
-		created, createErr := client.OperatorV1().Klusterlets().Create(ctx, desired, metav1.CreateOptions{})
-		return created, createErr
+		_, result.Error = client.OperatorV1().Klusterlets().Create(ctx, desired, metav1.CreateOptions{})
+		result.Changed = result.Error == nil
+		return result
 	} else if err != nil {
-		return nil, err
+		result.Error = err
+		return result
 	}
 
 	if equality.Semantic.DeepEqual(existing.Spec, desired.Spec) {
-		return existing, nil
+		return result
 	}
 
 	existing.Spec = desired.Spec
 
-	updated, err := client.OperatorV1().Klusterlets().Update(ctx, existing, metav1.UpdateOptions{})
-	return updated, err
+	_, result.Error = client.OperatorV1().Klusterlets().Update(ctx, existing, metav1.UpdateOptions{})
+	result.Changed = result.Error == nil
+	return result
 }
@@ -0,0 +1,205 @@
+// Copyright Contributors to the Open Cluster Management project
+package join
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/openshift/library-go/pkg/operator/events"
+	"github.com/qiujian16/capi-importer/pkg/join/scenario"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	operatorv1 "open-cluster-management.io/api/operator/v1"
+)
+
+func TestRenderKlusterlet(t *testing.T) {
+	cases := []struct {
+		name        string
+		images      Images
+		hostAlias   *operatorv1.HubApiServerHostAlias
+		expectedErr bool
+		validate    func(t *testing.T, klusterlet *operatorv1.Klusterlet)
+	}{
+		{
+			name: "images are rendered",
+			images: Images{
+				Registration: "quay.io/ocm/registration:v1",
+				Work:         "quay.io/ocm/work@sha256:abc",
+				Operator:     "quay.io/ocm/registration-operator:v2",
+			},
+			validate: func(t *testing.T, klusterlet *operatorv1.Klusterlet) {
+				if klusterlet.Spec.RegistrationImagePullSpec != "quay.io/ocm/registration:v1" {
+					t.Errorf("unexpected registration image %q", klusterlet.Spec.RegistrationImagePullSpec)
+				}
+				if klusterlet.Spec.WorkImagePullSpec != "quay.io/ocm/work@sha256:abc" {
+					t.Errorf("unexpected work image %q", klusterlet.Spec.WorkImagePullSpec)
+				}
+				if klusterlet.Spec.ImagePullSpec != "quay.io/ocm/registration-operator:v2" {
+					t.Errorf("unexpected operator image %q", klusterlet.Spec.ImagePullSpec)
+				}
+				if klusterlet.Spec.HubApiServerHostAlias != nil {
+					t.Errorf("unexpected host alias %v", klusterlet.Spec.HubApiServerHostAlias)
+				}
+			},
+		},
+		{
+			name: "host alias is rendered",
+			images: Images{
+				Registration: "quay.io/ocm/registration:v1",
+				Work:         "quay.io/ocm/work:v1",
+				Operator:     "quay.io/ocm/registration-operator:v1",
+			},
+			hostAlias: &operatorv1.HubApiServerHostAlias{IP: "10.0.0.1", Hostname: "hub.example.com"},
+			validate: func(t *testing.T, klusterlet *operatorv1.Klusterlet) {
+				alias := klusterlet.Spec.HubApiServerHostAlias
+				if alias == nil || alias.IP != "10.0.0.1" || alias.Hostname != "hub.example.com" {
+					t.Errorf("unexpected host alias %v", alias)
+				}
+			},
+		},
+		{
+			name: "missing image",
+			images: Images{
+				Registration: "quay.io/ocm/registration:v1",
+				Operator:     "quay.io/ocm/registration-operator:v2",
+			},
+			expectedErr: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			template, err := scenario.Files.ReadFile("join/klusterlets.cr.yaml")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			data, err := renderTemplate("join/klusterlets.cr.yaml", template, Values{
+				ClusterName:    "cluster1",
+				AgentNamespace: "open-cluster-management-agent",
+				Klusterlet:     Klusterlet{Name: "klusterlet"},
+				Images:         c.images,
+				Hub:            Hub{HostAlias: c.hostAlias},
+			})
+			if c.expectedErr {
+				if err == nil {
+					t.Errorf("expected error, but got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			obj, _, err := genericCodec.Decode(data, nil, nil)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			c.validate(t, obj.(*operatorv1.Klusterlet))
+		})
+	}
+}
+
+func TestKlusterletNamespace(t *testing.T) {
+	cases := []struct {
+		name     string
+		mode     operatorv1.InstallMode
+		expected string
+	}{
+		{
+			name:     "default mode",
+			mode:     operatorv1.InstallModeDefault,
+			expected: "open-cluster-management-agent",
+		},
+		{
+			name:     "hosted mode",
+			mode:     operatorv1.InstallModeHosted,
+			expected: "klusterlet-cluster1",
+		},
+		{
+			name:     "singleton hosted mode",
+			mode:     operatorv1.InstallModeSingletonHosted,
+			expected: "klusterlet-cluster1",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			values := Values{
+				ClusterName:    "cluster1",
+				AgentNamespace: "open-cluster-management-agent",
+				Klusterlet: Klusterlet{
+					Name: "klusterlet-cluster1",
+					Mode: string(c.mode),
+				},
+			}
+			if ns := values.KlusterletNamespace(); ns != c.expected {
+				t.Errorf("expected namespace %q, but got %q", c.expected, ns)
+			}
+		})
+	}
+}
+
+// fakeSpoke is an apiserver which only serves the namespace of the klusterlet and the established
+// klusterlet CRD, the other requests are forbidden.
+func fakeSpoke(t *testing.T) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/api/v1/namespaces/open-cluster-management-agent":
+			fmt.Fprint(w, `{"apiVersion":"v1","kind":"Namespace","metadata":{"name":"open-cluster-management-agent"}}`)
+		case strings.HasPrefix(r.URL.Path, "/apis/apiextensions.k8s.io/v1/customresourcedefinitions/"):
+			name := path.Base(r.URL.Path)
+			fmt.Fprintf(w, `{"apiVersion":"apiextensions.k8s.io/v1","kind":"CustomResourceDefinition",`+
+				`"metadata":{"name":%q},"status":{"conditions":[`+
+				`{"type":"NamesAccepted","status":"True"},{"type":"Established","status":"True"}]}}`, name)
+		default:
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `{"kind":"Status","apiVersion":"v1","status":"Failure","reason":"Forbidden","code":403}`)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestApplyImportPhase(t *testing.T) {
+	spoke := fakeSpoke(t)
+	config := clientcmdapi.NewConfig()
+	config.Clusters["spoke"] = &clientcmdapi.Cluster{Server: spoke.URL}
+	config.AuthInfos["spoke"] = &clientcmdapi.AuthInfo{Token: "token"}
+	config.Contexts["spoke"] = &clientcmdapi.Context{Cluster: "spoke", AuthInfo: "spoke"}
+	config.CurrentContext = "spoke"
+
+	builder := NewBuilder().
+		WithSpokeKubeConfig(clientcmd.NewDefaultClientConfig(*config, &clientcmd.ConfigOverrides{})).
+		WithValues(Values{
+			ClusterName:    "cluster1",
+			AgentNamespace: "open-cluster-management-agent",
+			Klusterlet:     Klusterlet{Name: "klusterlet", Mode: string(operatorv1.InstallModeDefault)},
+			Hub:            Hub{APIServer: "https://hub.example.com", KubeConfig: "a3ViZWNvbmZpZw=="},
+			Images: Images{
+				Registration: "quay.io/ocm/registration:v1",
+				Work:         "quay.io/ocm/work:v1",
+				Operator:     "quay.io/ocm/registration-operator:v1",
+			},
+		})
+
+	// the CRD is established, the manifests after it are forbidden
+	report, err := builder.ApplyImport(context.TODO(), events.NewInMemoryRecorder("test"))
+	var phaseErr *PhaseError
+	if !errors.As(err, &phaseErr) {
+		t.Fatalf("expected a phase error, but got %v", err)
+	}
+	if phaseErr.Phase != PhaseManifestsApplied {
+		t.Errorf("expected the import fails in phase %s, but got %s: %v", PhaseManifestsApplied, phaseErr.Phase, err)
+	}
+	if len(report) == 0 || report[0].GVK != crdGVK || report[0].Error != nil {
+		t.Errorf("expected the CRD is applied, but got %v", report)
+	}
+}
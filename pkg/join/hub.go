@@ -0,0 +1,112 @@
+// Copyright Contributors to the Open Cluster Management project
+package join
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/openshift/library-go/pkg/operator/events"
+	"github.com/openshift/library-go/pkg/operator/resource/resourceapply"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+)
+
+// bootstrapClusterRoleBinding is the binding of the bootstrap service account and the bootstrap token
+// group created by the importer. The binding named after the bootstrap cluster role is owned by the
+// cluster manager or clusteradm, and is not touched so their subjects are kept.
+const bootstrapClusterRoleBinding = "open-cluster-management:importer:bootstrap"
+
+// EnsureBootstrapRBAC creates the bootstrap service account, the bootstrap cluster role if it does not
+// exist, and the binding of the service account and the bootstrap token group to it on the hub. Errors
+// other than forbidden are retried, a forbidden error means the importer is not allowed to manage
+// the RBAC and it has to be created beforehand.
+func EnsureBootstrapRBAC(ctx context.Context, client kubernetes.Interface, recorder events.Recorder, config BootstrapConfig) error {
+	err := retry.OnError(retry.DefaultBackoff, func(err error) bool {
+		return !errors.IsForbidden(err)
+	}, func() error {
+		return applyBootstrapRBAC(ctx, client, recorder, config)
+	})
+	if errors.IsForbidden(err) {
+		return fmt.Errorf("not allowed to provision the bootstrap service account %s/%s and the cluster role %s on the hub, "+
+			"grant the importer the permissions or create them beforehand: %v", config.SANamespace, config.SAName, BootstrapClusterRole, err)
+	}
+	return err
+}
+
+func applyBootstrapRBAC(ctx context.Context, client kubernetes.Interface, recorder events.Recorder, config BootstrapConfig) error {
+	_, err := client.CoreV1().Namespaces().Get(ctx, config.SANamespace, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		_, _, err = resourceapply.ApplyNamespace(ctx, client.CoreV1(), recorder, &corev1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{Name: config.SANamespace},
+		})
+	}
+	if err != nil {
+		return err
+	}
+
+	_, _, err = resourceapply.ApplyServiceAccount(ctx, client.CoreV1(), recorder, &corev1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      config.SAName,
+			Namespace: config.SANamespace,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	// the bootstrap cluster role installed by the cluster manager is kept as it is
+	_, err = client.RbacV1().ClusterRoles().Get(ctx, BootstrapClusterRole, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		_, _, err = resourceapply.ApplyClusterRole(ctx, client.RbacV1(), recorder, bootstrapClusterRole())
+	}
+	if err != nil {
+		return err
+	}
+
+	_, _, err = resourceapply.ApplyClusterRoleBinding(ctx, client.RbacV1(), recorder, &rbacv1.ClusterRoleBinding{
+		ObjectMeta: metav1.ObjectMeta{Name: bootstrapClusterRoleBinding},
+		RoleRef: rbacv1.RoleRef{
+			APIGroup: rbacv1.GroupName,
+			Kind:     "ClusterRole",
+			Name:     BootstrapClusterRole,
+		},
+		Subjects: []rbacv1.Subject{
+			{
+				Kind:      rbacv1.ServiceAccountKind,
+				Name:      config.SAName,
+				Namespace: config.SANamespace,
+			},
+			{
+				APIGroup: rbacv1.GroupName,
+				Kind:     rbacv1.GroupKind,
+				Name:     BootstrapTokenGroup,
+			},
+		},
+	})
+	return err
+}
+
+// bootstrapClusterRole is the cluster role with the permissions for the agents to bootstrap
+func bootstrapClusterRole() *rbacv1.ClusterRole {
+	return &rbacv1.ClusterRole{
+		ObjectMeta: metav1.ObjectMeta{Name: BootstrapClusterRole},
+		Rules: []rbacv1.PolicyRule{
+			{
+				// the agent creates a CSR to get the client certificate to the hub
+				APIGroups: []string{"certificates.k8s.io"},
+				Resources: []string{"certificatesigningrequests"},
+				Verbs:     []string{"create", "get", "list", "watch"},
+			},
+			{
+				// the agent creates the ManagedCluster if it does not exist
+				APIGroups: []string{"cluster.open-cluster-management.io"},
+				Resources: []string{"managedclusters"},
+				Verbs:     []string{"get", "create"},
+			},
+		},
+	}
+}
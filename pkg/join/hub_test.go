@@ -0,0 +1,119 @@
+// Copyright Contributors to the Open Cluster Management project
+package join
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/openshift/library-go/pkg/operator/events"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+)
+
+func TestEnsureBootstrapRBAC(t *testing.T) {
+	config := BootstrapConfig{SAName: "bootstrap", SANamespace: "open-cluster-management"}
+
+	// the bootstrap RBAC of the cluster manager, the binding is extended by clusteradm
+	clusterManagerRole := &rbacv1.ClusterRole{
+		ObjectMeta: metav1.ObjectMeta{Name: BootstrapClusterRole},
+		Rules: []rbacv1.PolicyRule{
+			{
+				APIGroups: []string{"certificates.k8s.io"},
+				Resources: []string{"certificatesigningrequests"},
+				Verbs:     []string{"create", "get", "list", "watch"},
+			},
+			{
+				APIGroups: []string{"cluster.open-cluster-management.io"},
+				Resources: []string{"managedclusters"},
+				Verbs:     []string{"get", "create"},
+			},
+			{
+				APIGroups: []string{"cluster.open-cluster-management.io"},
+				Resources: []string{"managedclustersets/join"},
+				Verbs:     []string{"create"},
+			},
+		},
+	}
+	clusterManagerBinding := &rbacv1.ClusterRoleBinding{
+		ObjectMeta: metav1.ObjectMeta{Name: BootstrapClusterRole},
+		RoleRef: rbacv1.RoleRef{
+			APIGroup: rbacv1.GroupName,
+			Kind:     "ClusterRole",
+			Name:     BootstrapClusterRole,
+		},
+		Subjects: []rbacv1.Subject{
+			{
+				Kind:      rbacv1.ServiceAccountKind,
+				Name:      "cluster-bootstrap",
+				Namespace: "open-cluster-management",
+			},
+		},
+	}
+
+	cases := []struct {
+		name     string
+		existing []runtime.Object
+	}{
+		{
+			name: "bootstrap RBAC does not exist",
+		},
+		{
+			name:     "bootstrap RBAC of the cluster manager exists",
+			existing: []runtime.Object{clusterManagerRole, clusterManagerBinding},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			client := kubefake.NewSimpleClientset(c.existing...)
+			err := EnsureBootstrapRBAC(context.TODO(), client, events.NewInMemoryRecorder("test"), config)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := client.CoreV1().ServiceAccounts(config.SANamespace).Get(
+				context.TODO(), config.SAName, metav1.GetOptions{}); err != nil {
+				t.Errorf("expected the bootstrap service account is created, but got %v", err)
+			}
+
+			role, err := client.RbacV1().ClusterRoles().Get(context.TODO(), BootstrapClusterRole, metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			expectedRules := bootstrapClusterRole().Rules
+			if len(c.existing) > 0 {
+				expectedRules = clusterManagerRole.Rules
+			}
+			if !reflect.DeepEqual(role.Rules, expectedRules) {
+				t.Errorf("expected rules %v, but got %v", expectedRules, role.Rules)
+			}
+
+			binding, err := client.RbacV1().ClusterRoleBindings().Get(context.TODO(), bootstrapClusterRoleBinding, metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			expectedSubjects := []rbacv1.Subject{
+				{Kind: rbacv1.ServiceAccountKind, Name: config.SAName, Namespace: config.SANamespace},
+				{APIGroup: rbacv1.GroupName, Kind: rbacv1.GroupKind, Name: BootstrapTokenGroup},
+			}
+			if binding.RoleRef.Name != BootstrapClusterRole || !reflect.DeepEqual(binding.Subjects, expectedSubjects) {
+				t.Errorf("expected the importer binding to %s with %v, but got %v", BootstrapClusterRole, expectedSubjects, binding)
+			}
+
+			// the existing subjects of the shared binding are kept
+			if len(c.existing) == 0 {
+				return
+			}
+			shared, err := client.RbacV1().ClusterRoleBindings().Get(context.TODO(), BootstrapClusterRole, metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(shared.Subjects, clusterManagerBinding.Subjects) {
+				t.Errorf("expected subjects %v are kept, but got %v", clusterManagerBinding.Subjects, shared.Subjects)
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright Contributors to the Open Cluster Management project
+package join
+
+import (
+	"fmt"
+	"strings"
+
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+)
+
+// Images is the full pull specs of the images deployed on the spoke. There is no addon-manager image,
+// the addon manager runs on the hub with the ClusterManager and the Klusterlet API has no image for it
+// in any mode.
+type Images struct {
+	// Registration is the image of the registration agent
+	Registration string
+	// Work is the image of the work agent
+	Work string
+	// Operator is the image of the klusterlet operator, it is also the image of the agent in
+	// the singleton modes
+	Operator string
+}
+
+// Validate checks the pull specs of the images deployed on the spoke
+func (i Images) Validate() error {
+	var errs []error
+	for _, image := range []struct{ name, pullSpec string }{
+		{name: "registration image", pullSpec: i.Registration},
+		{name: "work image", pullSpec: i.Work},
+		{name: "operator image", pullSpec: i.Operator},
+	} {
+		if err := validatePullSpec(image.name, image.pullSpec); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+func validatePullSpec(name, image string) error {
+	switch {
+	case len(image) == 0:
+		return fmt.Errorf("%s is required", name)
+	case strings.ContainsAny(image, " \t\n"):
+		return fmt.Errorf("%s %q contains whitespace", name, image)
+	case strings.HasPrefix(image, "/") || strings.HasSuffix(image, ":") || strings.HasSuffix(image, "@"):
+		return fmt.Errorf("%s %q is not a valid image pull spec", name, image)
+	}
+	return nil
+}
+
+// ImagePullSpec returns the pull spec of the image in the registry. The version is either a tag,
+// or a digest in the format of sha256:xxx.
+func ImagePullSpec(registry, name, version string) string {
+	if len(version) == 0 {
+		return ""
+	}
+	if strings.Contains(version, ":") {
+		return fmt.Sprintf("%s/%s@%s", registry, name, version)
+	}
+	return fmt.Sprintf("%s/%s:%s", registry, name, version)
+}
+
+// ImageVersions is the tags or digests of the images in the registry
+type ImageVersions struct {
+	Registration string
+	Work         string
+	Operator     string
+}
+
+// ComposeImages builds the pull specs of the images from the registry and the versions, unless the
+// full pull spec of the image is set in the overrides.
+func ComposeImages(registry string, versions ImageVersions, overrides Images) Images {
+	images := Images{
+		Registration: ImagePullSpec(registry, "registration", versions.Registration),
+		Work:         ImagePullSpec(registry, "work", versions.Work),
+		Operator:     ImagePullSpec(registry, "registration-operator", versions.Operator),
+	}
+	if len(overrides.Registration) > 0 {
+		images.Registration = overrides.Registration
+	}
+	if len(overrides.Work) > 0 {
+		images.Work = overrides.Work
+	}
+	if len(overrides.Operator) > 0 {
+		images.Operator = overrides.Operator
+	}
+	return images
+}
@@ -0,0 +1,87 @@
+// Copyright Contributors to the Open Cluster Management project
+package join
+
+import (
+	"testing"
+)
+
+func TestImagePullSpec(t *testing.T) {
+	cases := []struct {
+		name     string
+		version  string
+		expected string
+	}{
+		{
+			name:     "tag",
+			version:  "v0.12.0",
+			expected: "quay.io/open-cluster-management/registration:v0.12.0",
+		},
+		{
+			name:     "digest",
+			version:  "sha256:abc",
+			expected: "quay.io/open-cluster-management/registration@sha256:abc",
+		},
+		{
+			name:     "no version",
+			expected: "",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			pullSpec := ImagePullSpec("quay.io/open-cluster-management", "registration", c.version)
+			if pullSpec != c.expected {
+				t.Errorf("expected %q, but got %q", c.expected, pullSpec)
+			}
+		})
+	}
+}
+
+func TestValidateImages(t *testing.T) {
+	images := Images{
+		Registration: "quay.io/ocm/registration:v1",
+		Work:         "quay.io/ocm/work@sha256:abc",
+		Operator:     "quay.io/ocm/registration-operator:v1",
+	}
+
+	cases := []struct {
+		name        string
+		images      func() Images
+		expectedErr bool
+	}{
+		{
+			name:   "valid images",
+			images: func() Images { return images },
+		},
+		{
+			name: "missing work image",
+			images: func() Images {
+				i := images
+				i.Work = ""
+				return i
+			},
+			expectedErr: true,
+		},
+		{
+			name: "empty tag",
+			images: func() Images {
+				i := images
+				i.Registration = "quay.io/ocm/registration:"
+				return i
+			},
+			expectedErr: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := c.images().Validate()
+			if c.expectedErr && err == nil {
+				t.Errorf("expected error, but got nil")
+			}
+			if !c.expectedErr && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright Contributors to the Open Cluster Management project
+package join
+
+import (
+	"fmt"
+	"os"
+
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// NewClientConfig returns the ClientConfig of the rest config, e.g. to use the cluster the
+// importer runs on as the hosting cluster.
+func NewClientConfig(config *rest.Config) (clientcmd.ClientConfig, error) {
+	kubeConfig, err := buildKubeConfig(config, false)
+	if err != nil {
+		return nil, err
+	}
+	return clientcmd.NewDefaultClientConfig(*kubeConfig, &clientcmd.ConfigOverrides{}), nil
+}
+
+// rawKubeConfig serializes the kubeconfig of the client config. The files referred by the
+// kubeconfig are inlined, so it can be used on another cluster.
+func rawKubeConfig(clientConfig clientcmd.ClientConfig) ([]byte, error) {
+	config, err := clientConfig.ClientConfig()
+	if err != nil {
+		return nil, err
+	}
+	kubeConfig, err := buildKubeConfig(config, true)
+	if err != nil {
+		return nil, err
+	}
+	return clientcmd.Write(*kubeConfig)
+}
+
+func buildKubeConfig(config *rest.Config, inline bool) (*clientcmdapi.Config, error) {
+	config = rest.CopyConfig(config)
+	if config.ExecProvider != nil || config.AuthProvider != nil {
+		return nil, fmt.Errorf("kubeconfig with exec or auth provider is not supported")
+	}
+
+	authInfo := &clientcmdapi.AuthInfo{
+		Token:     config.BearerToken,
+		TokenFile: config.BearerTokenFile,
+		Username:  config.Username,
+		Password:  config.Password,
+	}
+	if inline {
+		if err := rest.LoadTLSFiles(config); err != nil {
+			return nil, err
+		}
+		if len(config.BearerTokenFile) > 0 {
+			token, err := os.ReadFile(config.BearerTokenFile)
+			if err != nil {
+				return nil, err
+			}
+			authInfo.Token = string(token)
+			authInfo.TokenFile = ""
+		}
+	} else {
+		authInfo.ClientCertificate = config.CertFile
+		authInfo.ClientKey = config.KeyFile
+	}
+	authInfo.ClientCertificateData = config.CertData
+	authInfo.ClientKeyData = config.KeyData
+
+	cluster := &clientcmdapi.Cluster{
+		Server:                   config.Host,
+		CertificateAuthorityData: config.CAData,
+		InsecureSkipTLSVerify:    config.Insecure,
+		TLSServerName:            config.ServerName,
+	}
+	if !inline {
+		cluster.CertificateAuthority = config.CAFile
+	}
+
+	kubeConfig := clientcmdapi.NewConfig()
+	kubeConfig.Clusters["cluster"] = cluster
+	kubeConfig.AuthInfos["user"] = authInfo
+	kubeConfig.Contexts["context"] = &clientcmdapi.Context{
+		Cluster:  "cluster",
+		AuthInfo: "user",
+	}
+	kubeConfig.CurrentContext = "context"
+	return kubeConfig, nil
+}
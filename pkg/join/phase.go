@@ -0,0 +1,41 @@
+// Copyright Contributors to the Open Cluster Management project
+package join
+
+import "fmt"
+
+// Phase is a step of applying the klusterlet on the spoke
+type Phase string
+
+const (
+	// PhaseSpokeConnected is the phase to connect to the cluster running the klusterlet
+	PhaseSpokeConnected Phase = "SpokeConnected"
+	// PhaseCRDApplied is the phase to apply the klusterlet CRD until it is established
+	PhaseCRDApplied Phase = "CRDApplied"
+	// PhaseManifestsApplied is the phase to apply the namespace, the RBAC and the secrets of the operator
+	PhaseManifestsApplied Phase = "ManifestsApplied"
+	// PhaseOperatorDeployed is the phase to deploy the klusterlet operator
+	PhaseOperatorDeployed Phase = "OperatorDeployed"
+	// PhaseKlusterletApplied is the phase to apply the klusterlet CR
+	PhaseKlusterletApplied Phase = "KlusterletApplied"
+)
+
+// PhaseError is returned by the builder with the phase the import fails in
+type PhaseError struct {
+	Phase Phase
+	Err   error
+}
+
+func (e *PhaseError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Phase, e.Err)
+}
+
+func (e *PhaseError) Unwrap() error {
+	return e.Err
+}
+
+func phaseError(phase Phase, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &PhaseError{Phase: phase, Err: err}
+}
@@ -0,0 +1,73 @@
+// Copyright Contributors to the Open Cluster Management project
+package join
+
+import (
+	"bytes"
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/yaml"
+)
+
+// ManifestResult is the result of applying a manifest on the cluster running the klusterlet
+type ManifestResult struct {
+	// File is the name of the manifest template
+	File string
+	// GVK is the kind of the object in the manifest, it is empty if the manifest cannot be rendered
+	GVK schema.GroupVersionKind
+	// Name is the name of the object in the manifest
+	Name string
+	// Changed is true if the object is created or updated
+	Changed bool
+	// Error is the error to render or apply the manifest
+	Error error
+}
+
+func (r ManifestResult) String() string {
+	if r.GVK.Empty() {
+		return r.File
+	}
+	return fmt.Sprintf("%s (%s)", r.File, r.GVK)
+}
+
+// ApplyReport is the results of the manifests applied by the import in order
+type ApplyReport []ManifestResult
+
+// Err returns the aggregated errors of the manifests failed to apply, nil if all are applied
+func (r ApplyReport) Err() error {
+	var errs []error
+	for _, result := range r {
+		if result.Error != nil {
+			errs = append(errs, fmt.Errorf("failed to apply %s: %v", result, result.Error))
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// Failed returns the results of the manifests failed to apply
+func (r ApplyReport) Failed() ApplyReport {
+	var failed ApplyReport
+	for _, result := range r {
+		if result.Error != nil {
+			failed = append(failed, result)
+		}
+	}
+	return failed
+}
+
+// readManifest reads the kind and the name of the object from the rendered manifest
+func readManifest(file string, manifest []byte) ManifestResult {
+	result := ManifestResult{File: file}
+	object := struct {
+		metav1.TypeMeta   `json:",inline"`
+		metav1.ObjectMeta `json:"metadata,omitempty"`
+	}{}
+	if err := yaml.NewYAMLOrJSONDecoder(bytes.NewReader(manifest), 1024).Decode(&object); err != nil {
+		return result
+	}
+	result.GVK = schema.FromAPIVersionAndKind(object.APIVersion, object.Kind)
+	result.Name = object.Name
+	return result
+}
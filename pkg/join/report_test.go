@@ -0,0 +1,69 @@
+// Copyright Contributors to the Open Cluster Management project
+package join
+
+import (
+	"fmt"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestReadManifest(t *testing.T) {
+	cases := []struct {
+		name     string
+		manifest string
+		expected ManifestResult
+	}{
+		{
+			name:     "core",
+			manifest: "apiVersion: v1\nkind: Secret\nmetadata:\n  name: bootstrap-hub-kubeconfig\n",
+			expected: ManifestResult{
+				File: "manifest.yaml",
+				GVK:  schema.GroupVersionKind{Version: "v1", Kind: "Secret"},
+				Name: "bootstrap-hub-kubeconfig",
+			},
+		},
+		{
+			name:     "group",
+			manifest: "apiVersion: operator.open-cluster-management.io/v1\nkind: Klusterlet\n",
+			expected: ManifestResult{
+				File: "manifest.yaml",
+				GVK:  schema.GroupVersionKind{Group: "operator.open-cluster-management.io", Version: "v1", Kind: "Klusterlet"},
+			},
+		},
+		{
+			name:     "invalid",
+			manifest: "apiVersion: [",
+			expected: ManifestResult{File: "manifest.yaml"},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			result := readManifest("manifest.yaml", []byte(c.manifest))
+			if result != c.expected {
+				t.Errorf("expected %v, but got %v", c.expected, result)
+			}
+		})
+	}
+}
+
+func TestApplyReportErr(t *testing.T) {
+	report := ApplyReport{
+		{File: "join/namespace.yaml", GVK: schema.GroupVersionKind{Version: "v1", Kind: "Namespace"}, Changed: true},
+		{File: "join/cluster_role.yaml", GVK: schema.GroupVersionKind{Group: "rbac.authorization.k8s.io", Version: "v1", Kind: "ClusterRole"}, Error: fmt.Errorf("forbidden")},
+		{File: "join/operator.yaml", Error: fmt.Errorf("required value")},
+	}
+
+	if len(report.Failed()) != 2 {
+		t.Errorf("expected 2 failed manifests, but got %d", len(report.Failed()))
+	}
+	expected := "[failed to apply join/cluster_role.yaml (rbac.authorization.k8s.io/v1, Kind=ClusterRole): forbidden, " +
+		"failed to apply join/operator.yaml: required value]"
+	if err := report.Err(); err == nil || err.Error() != expected {
+		t.Errorf("expected error %q, but got %v", expected, err)
+	}
+	if err := report[:1].Err(); err != nil {
+		t.Errorf("expected no error, but got %v", err)
+	}
+}
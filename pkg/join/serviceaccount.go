@@ -0,0 +1,107 @@
+// Copyright Contributors to the Open Cluster Management project
+package join
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	clientcmdapiv1 "k8s.io/client-go/tools/clientcmd/api/v1"
+)
+
+// ServiceAccountBootStrapper creates a service account on the hub for the cluster and binds it to
+// the bootstrap cluster role, deleting the service account revokes the tokens of the cluster.
+type ServiceAccountBootStrapper struct {
+	config      BootstrapConfig
+	client      kubernetes.Interface
+	clusterName string
+}
+
+func NewServiceAccountBootStrapper(config BootstrapConfig, client kubernetes.Interface, clusterName string) BootstrapGetter {
+	return &ServiceAccountBootStrapper{
+		config:      config,
+		client:      client,
+		clusterName: clusterName,
+	}
+}
+
+func (g *ServiceAccountBootStrapper) serviceAccountName() string {
+	return fmt.Sprintf("%s-bootstrap", g.clusterName)
+}
+
+func (g *ServiceAccountBootStrapper) clusterRoleBindingName() string {
+	return fmt.Sprintf("%s:%s", BootstrapClusterRole, g.clusterName)
+}
+
+func (g *ServiceAccountBootStrapper) KubeConfigRaw() ([]byte, error) {
+	return rawBootstrapKubeConfig(g)
+}
+
+func (g *ServiceAccountBootStrapper) KubeConfig() (clientcmdapiv1.Config, error) {
+	if err := g.ensureServiceAccount(); err != nil {
+		return clientcmdapiv1.Config{}, err
+	}
+
+	token, err := createToken(g.client, g.config, g.serviceAccountName(), nil)
+	if err != nil {
+		return clientcmdapiv1.Config{}, err
+	}
+	return bootstrapKubeConfig(g.client, g.config, clientcmdapiv1.AuthInfo{Token: token})
+}
+
+// ensureServiceAccount creates the service account of the cluster and binds it to the bootstrap cluster role
+func (g *ServiceAccountBootStrapper) ensureServiceAccount() error {
+	labels := map[string]string{
+		LabelClusterName: g.clusterName,
+	}
+
+	_, err := g.client.CoreV1().ServiceAccounts(g.config.SANamespace).Create(context.TODO(), &corev1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      g.serviceAccountName(),
+			Namespace: g.config.SANamespace,
+			Labels:    labels,
+		},
+	}, metav1.CreateOptions{})
+	if err != nil && !errors.IsAlreadyExists(err) {
+		return err
+	}
+
+	_, err = g.client.RbacV1().ClusterRoleBindings().Create(context.TODO(), &rbacv1.ClusterRoleBinding{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   g.clusterRoleBindingName(),
+			Labels: labels,
+		},
+		RoleRef: rbacv1.RoleRef{
+			APIGroup: rbacv1.GroupName,
+			Kind:     "ClusterRole",
+			Name:     BootstrapClusterRole,
+		},
+		Subjects: []rbacv1.Subject{
+			{
+				Kind:      rbacv1.ServiceAccountKind,
+				Name:      g.serviceAccountName(),
+				Namespace: g.config.SANamespace,
+			},
+		},
+	}, metav1.CreateOptions{})
+	if err != nil && !errors.IsAlreadyExists(err) {
+		return err
+	}
+	return nil
+}
+
+func (g *ServiceAccountBootStrapper) Revoke() error {
+	err := g.client.RbacV1().ClusterRoleBindings().Delete(context.TODO(), g.clusterRoleBindingName(), metav1.DeleteOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	err = g.client.CoreV1().ServiceAccounts(g.config.SANamespace).Delete(context.TODO(), g.serviceAccountName(), metav1.DeleteOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
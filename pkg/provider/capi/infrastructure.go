@@ -0,0 +1,157 @@
+package capi
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/qiujian16/capi-importer/pkg/provider"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/klog/v2"
+)
+
+// infrastructure describes a CAPI infrastructure provider by the kind of its
+// infrastructure cluster resource.
+type infrastructure struct {
+	cloud string
+	// regionPath is the field path of the region in the infrastructure cluster,
+	// it is empty if the provider has no notion of region.
+	regionPath []string
+}
+
+// infrastructures is the infrastructure providers by kind. The zone of a PowerVS workspace is not a
+// region, the region of a PowerVS cluster is only known once it is connected to a VPC.
+var infrastructures = map[string]infrastructure{
+	"AWSCluster":          {cloud: provider.CloudAmazon, regionPath: []string{"spec", "region"}},
+	"AWSManagedCluster":   {cloud: provider.CloudAmazon},
+	"AzureCluster":        {cloud: provider.CloudAzure, regionPath: []string{"spec", "location"}},
+	"AzureManagedCluster": {cloud: provider.CloudAzure},
+	"GCPCluster":          {cloud: provider.CloudGoogle, regionPath: []string{"spec", "region"}},
+	"GCPManagedCluster":   {cloud: provider.CloudGoogle, regionPath: []string{"spec", "region"}},
+	"VSphereCluster":      {cloud: provider.CloudVSphere},
+	"OpenStackCluster":    {cloud: provider.CloudOpenStack},
+	"IBMVPCCluster":       {cloud: provider.CloudIBM, regionPath: []string{"spec", "region"}},
+	"IBMPowerVSCluster":   {cloud: provider.CloudIBM, regionPath: []string{"spec", "vpc", "region"}},
+	"Metal3Cluster":       {cloud: provider.CloudBareMetal},
+	"DockerCluster":       {cloud: provider.CloudOther},
+}
+
+// labelCache keeps the labels of the clusters by the infrastructureRef they are built from, so the
+// infrastructure cluster is only read again when the cluster is recreated or refers to another one.
+type labelCache struct {
+	lock   sync.Mutex
+	labels map[string]cachedLabels
+}
+
+type cachedLabels struct {
+	infrastructureRef string
+	labels            map[string]string
+}
+
+func newLabelCache() *labelCache {
+	return &labelCache{labels: map[string]cachedLabels{}}
+}
+
+func (l *labelCache) get(key, infrastructureRef string) (map[string]string, bool) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	cached, ok := l.labels[key]
+	if !ok || cached.infrastructureRef != infrastructureRef {
+		return nil, false
+	}
+	return cached.labels, true
+}
+
+func (l *labelCache) set(key, infrastructureRef string, labels map[string]string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.labels[key] = cachedLabels{infrastructureRef: infrastructureRef, labels: labels}
+}
+
+func (l *labelCache) delete(key string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	delete(l.labels, key)
+}
+
+// infrastructureLabels returns the labels of the cluster from the cache, they are built again if the
+// cluster is recreated or its infrastructureRef changes. The labels without the region are not cached
+// if the infrastructure cluster fails to be read, so the region is read again in the next sync.
+func (c *CAPIProvider) infrastructureLabels(cluster *unstructured.Unstructured) map[string]string {
+	key := fmt.Sprintf("%s/%s", cluster.GetNamespace(), cluster.GetName())
+	infrastructureRef, _, _ := unstructured.NestedStringMap(cluster.Object, "spec", "infrastructureRef")
+	fingerprint := fmt.Sprintf("%s/%s/%s/%s/%s", cluster.GetUID(), infrastructureRef["apiVersion"],
+		infrastructureRef["kind"], infrastructureRef["namespace"], infrastructureRef["name"])
+	if labels, ok := c.labels.get(key, fingerprint); ok {
+		return labels
+	}
+
+	labels, err := c.buildInfrastructureLabels(cluster)
+	if err != nil {
+		klog.V(4).Infof("failed to get region of cluster %s: %v", key, err)
+		return labels
+	}
+	c.labels.set(key, fingerprint, labels)
+	return labels
+}
+
+// buildInfrastructureLabels builds the labels from the infrastructureRef of the cluster. The
+// region is read from the infrastructure cluster resource when the provider has one.
+func (c *CAPIProvider) buildInfrastructureLabels(cluster *unstructured.Unstructured) (map[string]string, error) {
+	kind, _, _ := unstructured.NestedString(cluster.Object, "spec", "infrastructureRef", "kind")
+	if len(kind) == 0 {
+		return map[string]string{}, nil
+	}
+
+	labels := map[string]string{
+		provider.LabelInfrastructureKind: kind,
+		provider.LabelCloud:              provider.CloudOther,
+	}
+
+	infra, ok := infrastructures[kind]
+	if !ok {
+		return labels, nil
+	}
+	labels[provider.LabelCloud] = infra.cloud
+	if len(infra.regionPath) == 0 {
+		return labels, nil
+	}
+
+	region, err := c.infrastructureRegion(cluster, kind, infra.regionPath)
+	if err != nil {
+		return labels, err
+	}
+	if len(region) > 0 {
+		labels[provider.LabelRegion] = region
+	}
+	return labels, nil
+}
+
+func (c *CAPIProvider) infrastructureRegion(cluster *unstructured.Unstructured, kind string, regionPath []string) (string, error) {
+	apiVersion, _, _ := unstructured.NestedString(cluster.Object, "spec", "infrastructureRef", "apiVersion")
+	name, _, _ := unstructured.NestedString(cluster.Object, "spec", "infrastructureRef", "name")
+	namespace, _, _ := unstructured.NestedString(cluster.Object, "spec", "infrastructureRef", "namespace")
+	if len(namespace) == 0 {
+		namespace = cluster.GetNamespace()
+	}
+
+	gv, err := schema.ParseGroupVersion(apiVersion)
+	if err != nil {
+		return "", err
+	}
+	// all the CAPI infrastructure resources follow the default plural naming.
+	infraGVR := gv.WithResource(strings.ToLower(kind) + "s")
+
+	infraCluster, err := c.dynamicClient.Resource(infraGVR).Namespace(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+	region, _, err := unstructured.NestedString(infraCluster.Object, regionPath...)
+	return region, err
+}
@@ -0,0 +1,152 @@
+package capi
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/qiujian16/capi-importer/pkg/provider"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+)
+
+func withInfrastructureRef(cluster *unstructured.Unstructured, apiVersion, kind, name string) *unstructured.Unstructured {
+	_ = unstructured.SetNestedStringMap(cluster.Object, map[string]string{
+		"apiVersion": apiVersion,
+		"kind":       kind,
+		"name":       name,
+	}, "spec", "infrastructureRef")
+	return cluster
+}
+
+func newInfrastructureCluster(apiVersion, kind, name string, fields map[string]interface{}) *unstructured.Unstructured {
+	return &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": apiVersion,
+			"kind":       kind,
+			"metadata": map[string]interface{}{
+				"name":      name,
+				"namespace": "ns1",
+			},
+			"spec": fields,
+		},
+	}
+}
+
+func TestInfrastructureLabels(t *testing.T) {
+	awsCluster := newInfrastructureCluster("infrastructure.cluster.x-k8s.io/v1beta2", "AWSCluster", "cluster1",
+		map[string]interface{}{"region": "us-east-1"})
+	powerVSCluster := newInfrastructureCluster("infrastructure.cluster.x-k8s.io/v1beta2", "IBMPowerVSCluster", "cluster1",
+		map[string]interface{}{"zone": "dal10"})
+	powerVSClusterInVPC := newInfrastructureCluster("infrastructure.cluster.x-k8s.io/v1beta2", "IBMPowerVSCluster", "cluster2",
+		map[string]interface{}{"zone": "dal10", "vpc": map[string]interface{}{"region": "us-south"}})
+
+	cases := []struct {
+		name     string
+		cluster  *unstructured.Unstructured
+		expected map[string]string
+	}{
+		{
+			name:     "no infrastructure ref",
+			cluster:  newCluster("Provisioned", true, true),
+			expected: map[string]string{},
+		},
+		{
+			name: "unknown infrastructure",
+			cluster: withInfrastructureRef(newCluster("Provisioned", true, true),
+				"infrastructure.cluster.x-k8s.io/v1beta1", "FooCluster", "cluster1"),
+			expected: map[string]string{
+				provider.LabelInfrastructureKind: "FooCluster",
+				provider.LabelCloud:              provider.CloudOther,
+			},
+		},
+		{
+			name: "infrastructure without region",
+			cluster: withInfrastructureRef(newCluster("Provisioned", true, true),
+				"infrastructure.cluster.x-k8s.io/v1beta1", "VSphereCluster", "cluster1"),
+			expected: map[string]string{
+				provider.LabelInfrastructureKind: "VSphereCluster",
+				provider.LabelCloud:              provider.CloudVSphere,
+			},
+		},
+		{
+			name: "infrastructure with region",
+			cluster: withInfrastructureRef(newCluster("Provisioned", true, true),
+				"infrastructure.cluster.x-k8s.io/v1beta2", "AWSCluster", "cluster1"),
+			expected: map[string]string{
+				provider.LabelInfrastructureKind: "AWSCluster",
+				provider.LabelCloud:              provider.CloudAmazon,
+				provider.LabelRegion:             "us-east-1",
+			},
+		},
+		{
+			name: "zone is not a region",
+			cluster: withInfrastructureRef(newCluster("Provisioned", true, true),
+				"infrastructure.cluster.x-k8s.io/v1beta2", "IBMPowerVSCluster", "cluster1"),
+			expected: map[string]string{
+				provider.LabelInfrastructureKind: "IBMPowerVSCluster",
+				provider.LabelCloud:              provider.CloudIBM,
+			},
+		},
+		{
+			name: "region of the vpc",
+			cluster: withInfrastructureRef(newCluster("Provisioned", true, true),
+				"infrastructure.cluster.x-k8s.io/v1beta2", "IBMPowerVSCluster", "cluster2"),
+			expected: map[string]string{
+				provider.LabelInfrastructureKind: "IBMPowerVSCluster",
+				provider.LabelCloud:              provider.CloudIBM,
+				provider.LabelRegion:             "us-south",
+			},
+		},
+		{
+			name: "infrastructure cluster not found",
+			cluster: withInfrastructureRef(newCluster("Provisioned", true, true),
+				"infrastructure.cluster.x-k8s.io/v1beta2", "AWSCluster", "cluster2"),
+			expected: map[string]string{
+				provider.LabelInfrastructureKind: "AWSCluster",
+				provider.LabelCloud:              provider.CloudAmazon,
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			p := &CAPIProvider{
+				dynamicClient: dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), awsCluster, powerVSCluster, powerVSClusterInVPC),
+				labels:        newLabelCache(),
+			}
+			labels := p.infrastructureLabels(c.cluster)
+			if !reflect.DeepEqual(labels, c.expected) {
+				t.Errorf("expected labels %v, but got %v", c.expected, labels)
+			}
+		})
+	}
+}
+
+func TestInfrastructureLabelsCached(t *testing.T) {
+	dynamicClient := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(),
+		newInfrastructureCluster("infrastructure.cluster.x-k8s.io/v1beta2", "AWSCluster", "cluster1",
+			map[string]interface{}{"region": "us-east-1"}),
+		newInfrastructureCluster("infrastructure.cluster.x-k8s.io/v1beta2", "AWSCluster", "cluster2",
+			map[string]interface{}{"region": "us-west-2"}),
+	)
+	p := &CAPIProvider{dynamicClient: dynamicClient, labels: newLabelCache()}
+
+	cluster := withInfrastructureRef(newCluster("Provisioned", true, true),
+		"infrastructure.cluster.x-k8s.io/v1beta2", "AWSCluster", "cluster1")
+	p.infrastructureLabels(cluster)
+	p.infrastructureLabels(cluster)
+	if actions := dynamicClient.Actions(); len(actions) != 1 {
+		t.Errorf("expected the infrastructure cluster is read once, but got %d actions", len(actions))
+	}
+
+	// the infrastructure cluster is read again once the cluster refers to another one
+	cluster = withInfrastructureRef(cluster, "infrastructure.cluster.x-k8s.io/v1beta2", "AWSCluster", "cluster2")
+	labels := p.infrastructureLabels(cluster)
+	if actions := dynamicClient.Actions(); len(actions) != 2 {
+		t.Errorf("expected the infrastructure cluster is read again, but got %d actions", len(actions))
+	}
+	if labels[provider.LabelRegion] != "us-west-2" {
+		t.Errorf("expected region us-west-2, but got %q", labels[provider.LabelRegion])
+	}
+}
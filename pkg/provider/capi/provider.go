@@ -3,70 +3,71 @@ package capi
 import (
 	"context"
 	"fmt"
-	"time"
 
 	"github.com/pkg/errors"
+	"github.com/qiujian16/capi-importer/pkg/provider"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/client-go/dynamic"
-	"k8s.io/client-go/dynamic/dynamicinformer"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
-	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/clientcmd"
 )
 
+const (
+	// phaseProvisioned is the CAPI cluster phase once the infrastructure and the
+	// control plane endpoint are available.
+	phaseProvisioned = "Provisioned"
+)
+
+// CAPIProvider imports the clusters provisioned by any CAPI infrastructure provider,
+// the infrastructure of each cluster is recorded in the labels of the ManagedCluster.
 type CAPIProvider struct {
-	informer   dynamicinformer.DynamicSharedInformerFactory
-	lister     cache.GenericLister
-	kubeClient kubernetes.Interface
+	*provider.ObjectProvider
+	kubeClient    kubernetes.Interface
+	dynamicClient dynamic.Interface
+	labels        *labelCache
 }
 
-var gvr = schema.GroupVersionResource{}
+var gvr = schema.GroupVersionResource{
+	Group:    "cluster.x-k8s.io",
+	Version:  "v1beta1",
+	Resource: "clusters",
+}
 
 func NewCAPIProvider(kubeconfig *rest.Config) *CAPIProvider {
 	dynamicClient := dynamic.NewForConfigOrDie(kubeconfig)
-	kubeClient := kubernetes.NewForConfigOrDie(kubeconfig)
-
-	dynamicInformer := dynamicinformer.NewDynamicSharedInformerFactory(dynamicClient, 30*time.Minute)
 	return &CAPIProvider{
-		informer:   dynamicInformer,
-		lister:     dynamicInformer.ForResource(gvr).Lister(),
-		kubeClient: kubeClient,
+		ObjectProvider: provider.NewObjectProvider("capi", gvr, dynamicClient, isClusterReady),
+		kubeClient:     kubernetes.NewForConfigOrDie(kubeconfig),
+		dynamicClient:  dynamicClient,
+		labels:         newLabelCache(),
 	}
 }
 
-func (c *CAPIProvider) AddEventHandler(handler cache.ResourceEventHandler) (cache.ResourceEventHandlerRegistration, error) {
-	return c.informer.ForResource(gvr).Informer().AddEventHandler(handler)
-}
-
-func (c *CAPIProvider) HasSynced() bool {
-	return c.informer.ForResource(gvr).Informer().HasSynced()
-}
-
-func (c *CAPIProvider) Key(obj runtime.Object) []string {
-	name, _ := cache.MetaNamespaceKeyFunc(obj)
-	return []string{fmt.Sprintf("%s/%s", c.Name(), name)}
-}
-
-func (c *CAPIProvider) Name() string {
-	return "capiaws"
-}
-
-func (c *CAPIProvider) Start(ctx context.Context) {
-	c.informer.Start(ctx.Done())
-}
-
-func (c *CAPIProvider) KubeConfig(clusterKey string) (clientcmd.ClientConfig, error) {
-	namespace, name, err := cache.SplitMetaNamespaceKey(clusterKey)
+func (c *CAPIProvider) Labels(ref provider.ClusterRef) (map[string]string, error) {
+	cluster, err := c.Get(ref)
 	if err != nil {
 		return nil, err
 	}
-	_, err = c.lister.ByNamespace(namespace).Get(name)
+	return c.infrastructureLabels(cluster), nil
+}
+
+func (c *CAPIProvider) KubeConfig(ref provider.ClusterRef) (clientcmd.ClientConfig, error) {
+	cluster, err := c.Get(ref)
 	if err != nil {
 		return nil, err
 	}
+	namespace, name := cluster.GetNamespace(), cluster.GetName()
+
+	// do not read the kubeconfig until the cluster is reachable, return not found
+	// so the controller waits for the next cluster event. The kubeconfig of a deleting
+	// cluster is still returned so the klusterlet can be cleaned up.
+	if cluster.GetDeletionTimestamp() == nil && !isClusterReady(cluster) {
+		return nil, apierrors.NewNotFound(gvr.GroupResource(), name)
+	}
 
 	secret, err := c.kubeClient.CoreV1().Secrets(namespace).Get(context.TODO(), name+"-kubeconfig", metav1.GetOptions{})
 	if err != nil {
@@ -79,3 +80,24 @@ func (c *CAPIProvider) KubeConfig(clusterKey string) (clientcmd.ClientConfig, er
 	}
 	return clientcmd.NewClientConfigFromBytes(data)
 }
+
+// Deleted drops the cached infrastructure labels of the cluster once it is removed
+func (c *CAPIProvider) Deleted(ref provider.ClusterRef) (bool, error) {
+	if _, err := c.Get(ref); apierrors.IsNotFound(err) {
+		c.labels.delete(fmt.Sprintf("%s/%s", ref.Namespace, ref.Name))
+	}
+	return c.ObjectProvider.Deleted(ref)
+}
+
+// isClusterReady checks that both the infrastructure and the control plane of the
+// cluster are ready, and the cluster has been provisioned.
+func isClusterReady(cluster *unstructured.Unstructured) bool {
+	phase, _, _ := unstructured.NestedString(cluster.Object, "status", "phase")
+	if phase != phaseProvisioned {
+		return false
+	}
+
+	infrastructureReady, _, _ := unstructured.NestedBool(cluster.Object, "status", "infrastructureReady")
+	controlPlaneReady, _, _ := unstructured.NestedBool(cluster.Object, "status", "controlPlaneReady")
+	return infrastructureReady && controlPlaneReady
+}
@@ -0,0 +1,59 @@
+package capi
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func newCluster(phase string, infrastructureReady, controlPlaneReady bool) *unstructured.Unstructured {
+	return &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": "cluster.x-k8s.io/v1beta1",
+			"kind":       "Cluster",
+			"metadata": map[string]interface{}{
+				"name":      "cluster1",
+				"namespace": "ns1",
+			},
+			"status": map[string]interface{}{
+				"phase":               phase,
+				"infrastructureReady": infrastructureReady,
+				"controlPlaneReady":   controlPlaneReady,
+			},
+		},
+	}
+}
+
+func TestIsClusterReady(t *testing.T) {
+	cases := []struct {
+		name     string
+		cluster  *unstructured.Unstructured
+		expected bool
+	}{
+		{
+			name:    "provisioning cluster",
+			cluster: newCluster("Provisioning", true, false),
+		},
+		{
+			name:    "control plane not ready",
+			cluster: newCluster("Provisioned", true, false),
+		},
+		{
+			name:    "infrastructure not ready",
+			cluster: newCluster("Provisioned", false, true),
+		},
+		{
+			name:     "ready cluster",
+			cluster:  newCluster("Provisioned", true, true),
+			expected: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if ready := isClusterReady(c.cluster); ready != c.expected {
+				t.Errorf("expected ready %v, but got %v", c.expected, ready)
+			}
+		})
+	}
+}
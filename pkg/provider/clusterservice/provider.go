@@ -3,35 +3,63 @@ package clusterservice
 import (
 	"context"
 	"fmt"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	sdk "github.com/openshift-online/ocm-sdk-go"
 	clustersmgmtv1 "github.com/openshift-online/ocm-sdk-go/clustersmgmt/v1"
-	"github.com/openshift-online/rh-trex/pkg/errors"
 	"github.com/qiujian16/capi-importer/pkg/provider"
+	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
-	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/klog/v2"
 	clusterapiv1 "open-cluster-management.io/api/cluster/v1"
 )
 
-const byKey = "by-key"
+const (
+	byKey    = "by-key"
+	pageSize = 100
 
+	pollInterval = 10 * time.Minute
+
+	// retryInterval is the interval to connect to the cluster service and list the clusters again
+	// until the clusters are listed at first.
+	retryInterval = 30 * time.Second
+
+	annotationID    = "id"
+	annotationState = "state"
+)
+
+var clusterResource = schema.GroupResource{Group: "clusters_mgmt", Resource: "clusters"}
+
+// ClusterServiceProvider imports the ready clusters of the cluster service. The kubeconfig of a
+// cluster is fetched lazily when the cluster is imported, and kept in the credential store
+// until the importer evicts it. Clusters in the other states are kept in the store, so they
+// are only detached once they are removed from the cluster service or being uninstalled.
 type ClusterServiceProvider struct {
-	handler cache.ResourceEventHandler
-	store   cache.Store
-	token   string
+	handler     cache.ResourceEventHandler
+	store       cache.Store
+	credentials provider.CredentialStore
+	token       string
+	// connection is built by the goroutine of Start, and read by the controller to fetch the kubeconfigs
+	connection atomic.Pointer[sdk.Connection]
+	// synced is set once the clusters are listed from the cluster service at first, the clusters
+	// not in the store are not known to be removed until then.
+	synced atomic.Bool
 }
 
-func NewClusterServiceProvider(token string) provider.ClusterProvider {
+func NewClusterServiceProvider(token string, credentials provider.CredentialStore) *ClusterServiceProvider {
 	return &ClusterServiceProvider{
 		store: cache.NewIndexer(clusterKey, cache.Indexers{
 			byKey: cache.MetaNamespaceIndexFunc,
 		}),
-		token: token,
+		credentials: credentials,
+		token:       token,
 	}
 }
 
@@ -40,93 +68,344 @@ func (c *ClusterServiceProvider) AddEventHandler(handler cache.ResourceEventHand
 	return c, nil
 }
 
+// HasSynced is always true, so a cluster service unreachable at startup does not block the
+// controller shared with the other providers. Deleted is false until the clusters are listed, so
+// the imported clusters which are not loaded into the store yet are not detached.
 func (c *ClusterServiceProvider) HasSynced() bool {
 	return true
 }
 
 func (c *ClusterServiceProvider) Key(obj runtime.Object) []string {
-	name, _ := clusterKey(obj)
-	return []string{fmt.Sprintf("%s/%s", c.Name(), name)}
+	name, err := clusterKey(obj)
+	if err != nil {
+		return []string{}
+	}
+	return []string{c.ref(name).Key()}
 }
 
-func (c *ClusterServiceProvider) KubeConfig(clusterKey string) (clientcmd.ClientConfig, error) {
-	cluster, exist, err := c.store.GetByKey(clusterKey)
+func (c *ClusterServiceProvider) ref(name string) provider.ClusterRef {
+	return provider.ClusterRef{
+		Provider: c.Name(),
+		Name:     name,
+	}
+}
+
+// KubeConfig returns the kubeconfig of the cluster once it is ready, a not found error is returned
+// until then so the controller waits for the next event of the cluster.
+func (c *ClusterServiceProvider) KubeConfig(ref provider.ClusterRef) (clientcmd.ClientConfig, error) {
+	cluster, err := c.readyCluster(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.TODO()
+	kubeconfig, err := c.credentials.Get(ctx, ref)
+	if errors.IsNotFound(err) {
+		kubeconfig, err = c.fetchKubeConfig(ctx, cluster.Annotations[annotationID])
+		if err != nil {
+			return nil, err
+		}
+		err = c.credentials.Set(ctx, ref, kubeconfig)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return clientcmd.NewClientConfigFromBytes(kubeconfig)
+}
+
+func (c *ClusterServiceProvider) Evict(ctx context.Context, ref provider.ClusterRef) error {
+	return c.credentials.Delete(ctx, ref)
+}
+
+func (c *ClusterServiceProvider) fetchKubeConfig(ctx context.Context, id string) ([]byte, error) {
+	connection := c.connection.Load()
+	if connection == nil {
+		return nil, fmt.Errorf("cluster service is not connected")
+	}
+	credential, err := connection.ClustersMgmt().V1().Clusters().Cluster(id).Credentials().Get().SendContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(credential.Body().Kubeconfig()), nil
+}
+
+// Labels returns the labels of the cluster once it is ready, the clusters which are not ready are
+// not imported.
+func (c *ClusterServiceProvider) Labels(ref provider.ClusterRef) (map[string]string, error) {
+	cluster, err := c.readyCluster(ref)
+	if err != nil {
+		return nil, err
+	}
+	return cluster.Labels, nil
+}
+
+// ID returns the id of the cluster in the cluster service, a cluster recreated with the same name
+// has a new id.
+func (c *ClusterServiceProvider) ID(ref provider.ClusterRef) (string, error) {
+	cluster, err := c.getCluster(ref)
+	if err != nil {
+		return "", err
+	}
+	return cluster.Annotations[annotationID], nil
+}
+
+// Deleted returns true if the cluster is removed from the cluster service or is being uninstalled,
+// it is never true before the clusters are listed from the cluster service.
+func (c *ClusterServiceProvider) Deleted(ref provider.ClusterRef) (bool, error) {
+	if !c.synced.Load() {
+		return false, nil
+	}
+	cluster, err := c.getCluster(ref)
+	if errors.IsNotFound(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return cluster.Annotations[annotationState] == string(clustersmgmtv1.ClusterStateUninstalling), nil
+}
+
+func (c *ClusterServiceProvider) getCluster(ref provider.ClusterRef) (*clusterapiv1.ManagedCluster, error) {
+	obj, exist, err := c.store.GetByKey(ref.Name)
 	if err != nil {
 		return nil, err
 	}
 	if !exist {
-		return nil, errors.NotFound("cluster is not found")
+		return nil, errors.NewNotFound(clusterResource, ref.Name)
+	}
+	cluster, ok := obj.(*clusterapiv1.ManagedCluster)
+	if !ok {
+		return nil, fmt.Errorf("cluster %s is not a managed cluster", ref)
+	}
+	return cluster, nil
+}
+
+// readyCluster returns the cluster if it is ready, or a not found error
+func (c *ClusterServiceProvider) readyCluster(ref provider.ClusterRef) (*clusterapiv1.ManagedCluster, error) {
+	cluster, err := c.getCluster(ref)
+	if err != nil {
+		return nil, err
+	}
+	if cluster.Annotations[annotationState] != string(clustersmgmtv1.ClusterStateReady) {
+		return nil, errors.NewNotFound(clusterResource, ref.Name)
 	}
-	accesor, _ := meta.Accessor(cluster)
-	configString := accesor.GetAnnotations()["kubeconfig"]
-	return clientcmd.NewClientConfigFromBytes([]byte(configString))
+	return cluster, nil
 }
 
 func (c *ClusterServiceProvider) Name() string {
 	return "clusterservice"
 }
 
+// Start connects to the cluster service and syncs the clusters periodically. The connection
+// is retried until the clusters are listed at first.
 func (c *ClusterServiceProvider) Start(ctx context.Context) {
-	wait.Until(c.poll, 10*time.Minute, ctx.Done())
+	defer func() {
+		if connection := c.connection.Load(); connection != nil {
+			connection.Close()
+		}
+	}()
+
+	for {
+		if err := c.poll(ctx); err != nil {
+			klog.Errorf("failed to sync clusters from cluster service: %v", err)
+		}
+
+		interval := pollInterval
+		if !c.synced.Load() {
+			interval = retryInterval
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
 }
 
-func (c *ClusterServiceProvider) poll() {
-	logger, err := sdk.NewGoLoggerBuilder().
-		Debug(true).
-		Build()
-	if err != nil {
-		logger.Error(context.TODO(), err.Error())
-		return
+// connect returns the connection to the cluster service. The connection is kept for the lifetime
+// of the provider, the sdk refreshes the access token with the offline token when it expires.
+func (c *ClusterServiceProvider) connect(ctx context.Context) (*sdk.Connection, error) {
+	if connection := c.connection.Load(); connection != nil {
+		return connection, nil
 	}
 
-	// Create the connection, and remember to close it:
+	logger, err := sdk.NewGoLoggerBuilder().Build()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build logger of cluster service: %v", err)
+	}
 	connection, err := sdk.NewConnectionBuilder().
 		Logger(logger).
 		Tokens(c.token).
-		Build()
+		BuildContext(ctx)
 	if err != nil {
-		logger.Error(context.TODO(), err.Error())
-		return
+		return nil, fmt.Errorf("failed to connect to cluster service: %v", err)
 	}
-	defer connection.Close()
+	c.connection.Store(connection)
+	return connection, nil
+}
 
-	// Get the client for the resource that manages the collection of clusters:
-	collection := connection.ClustersMgmt().V1().Clusters()
-	clusterList, err := collection.List().Send()
+// poll lists all the clusters from the cluster service and compares them with the clusters
+// in the store by id and state. The handler is notified with the added, changed and removed
+// clusters.
+func (c *ClusterServiceProvider) poll(ctx context.Context) error {
+	connection, err := c.connect(ctx)
 	if err != nil {
-		logger.Error(context.TODO(), err.Error())
-		return
-	}
-
-	clusterList.Items().Each(func(cluster *clustersmgmtv1.Cluster) bool {
-		// convert cluster to ManagedCluster
-		mcl := &clusterapiv1.ManagedCluster{
-			ObjectMeta: metav1.ObjectMeta{
-				Name: cluster.Name(),
-				Annotations: map[string]string{
-					"id": cluster.ID(),
-				},
-			},
-		}
-		clusterCredential, err := collection.Cluster(cluster.ID()).Credentials().Get().Send()
+		return err
+	}
+
+	// refresh the tokens in advance, so the requests of this round do not fail on an expiring token.
+	if _, _, err := connection.TokensContext(ctx, time.Minute); err != nil {
+		return fmt.Errorf("failed to refresh the tokens of cluster service: %v", err)
+	}
+
+	clusters, err := listClusters(ctx, connection)
+	if err != nil {
+		return fmt.Errorf("failed to list clusters from cluster service: %v", err)
+	}
+
+	for name, cluster := range clusters {
+		mcl := toManagedCluster(cluster)
+		old, exists, err := c.store.GetByKey(name)
 		if err != nil {
-			logger.Error(context.TODO(), err.Error())
-			return true
+			klog.Errorf("failed to get cluster %s from store: %v", name, err)
+			continue
 		}
-		mcl.Annotations["kubeconfig"] = clusterCredential.Body().Kubeconfig()
-		if _, exists, _ := c.store.GetByKey(mcl.Name); exists {
-			return true
+
+		if !exists {
+			if err := c.store.Add(mcl); err != nil {
+				klog.Errorf("failed to add cluster %s to store: %v", name, err)
+				continue
+			}
+			c.handler.OnAdd(mcl, false)
+			continue
+		}
+
+		if !clusterChanged(old.(*clusterapiv1.ManagedCluster), mcl) {
+			continue
+		}
+		// the kubeconfig of a recreated cluster is changed
+		if err := c.credentials.Delete(ctx, c.ref(name)); err != nil {
+			klog.Errorf("failed to evict credentials of cluster %s: %v", name, err)
+			continue
 		}
-		if err := c.store.Add(mcl); err != nil {
-			logger.Error(context.TODO(), err.Error())
+		if err := c.store.Update(mcl); err != nil {
+			klog.Errorf("failed to update cluster %s in store: %v", name, err)
+			continue
+		}
+		c.handler.OnUpdate(old, mcl)
+	}
+
+	// clusters which are not listed any more are removed from the cluster service
+	for _, obj := range c.store.List() {
+		name, _ := clusterKey(obj)
+		if _, ok := clusters[name]; ok {
+			continue
+		}
+		if err := c.store.Delete(obj); err != nil {
+			klog.Errorf("failed to delete cluster %s from store: %v", name, err)
+			continue
+		}
+		c.handler.OnDelete(obj)
+		if err := c.credentials.Delete(ctx, c.ref(name)); err != nil {
+			klog.Errorf("failed to evict credentials of cluster %s: %v", name, err)
+		}
+	}
+	c.synced.Store(true)
+	return nil
+}
+
+// listedStates is the states of the clusters listed from the cluster service. A cluster is removed
+// from the store once it is not listed, so the search is not narrowed to the ready clusters only,
+// which would detach a cluster while it is hibernating or briefly in error. The clusters still
+// being installed are not listed since they are never imported, and the uninstalling clusters are
+// not listed so they are detached.
+var listedStates = []clustersmgmtv1.ClusterState{
+	clustersmgmtv1.ClusterStateReady,
+	clustersmgmtv1.ClusterStateHibernating,
+	clustersmgmtv1.ClusterStatePoweringDown,
+	clustersmgmtv1.ClusterStateResuming,
+	clustersmgmtv1.ClusterStateError,
+	clustersmgmtv1.ClusterStateUnknown,
+}
+
+// stateSearch returns the search of the clusters in the listed states
+func stateSearch() string {
+	states := make([]string, 0, len(listedStates))
+	for _, state := range listedStates {
+		states = append(states, fmt.Sprintf("'%s'", state))
+	}
+	return fmt.Sprintf("state in (%s)", strings.Join(states, ", "))
+}
+
+// listClusters lists all the pages of the clusters in the listed states, so that clusters on the
+// other pages or not ready for now are not treated as removed.
+func listClusters(ctx context.Context, connection *sdk.Connection) (map[string]*clustersmgmtv1.Cluster, error) {
+	collection := connection.ClustersMgmt().V1().Clusters()
+	clusters := map[string]*clustersmgmtv1.Cluster{}
+	for page := 1; ; page++ {
+		clusterList, err := collection.List().
+			Search(stateSearch()).
+			Page(page).
+			Size(pageSize).
+			SendContext(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		clusterList.Items().Each(func(cluster *clustersmgmtv1.Cluster) bool {
+			clusters[cluster.Name()] = cluster
 			return true
+		})
+
+		if clusterList.Size() < pageSize {
+			return clusters, nil
 		}
-		c.handler.OnAdd(mcl, false)
-		return true
-	})
+	}
+}
+
+// toManagedCluster converts the cluster to ManagedCluster
+func toManagedCluster(cluster *clustersmgmtv1.Cluster) *clusterapiv1.ManagedCluster {
+	return &clusterapiv1.ManagedCluster{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   cluster.Name(),
+			Labels: clusterLabels(cluster),
+			Annotations: map[string]string{
+				annotationID:    cluster.ID(),
+				annotationState: string(cluster.State()),
+			},
+		},
+	}
+}
+
+// clusterChanged returns true if the cluster is recreated with the same name or its state is changed.
+func clusterChanged(old, new *clusterapiv1.ManagedCluster) bool {
+	return old.Annotations[annotationID] != new.Annotations[annotationID] ||
+		old.Annotations[annotationState] != new.Annotations[annotationState]
+}
+
+// cloudProviders maps the cloud provider ids of cluster service to the cloud label values.
+var cloudProviders = map[string]string{
+	"aws": provider.CloudAmazon,
+	"gcp": provider.CloudGoogle,
+}
+
+func clusterLabels(cluster *clustersmgmtv1.Cluster) map[string]string {
+	labels := map[string]string{
+		provider.LabelCloud: provider.CloudOther,
+	}
+	if cloud, ok := cloudProviders[cluster.CloudProvider().ID()]; ok {
+		labels[provider.LabelCloud] = cloud
+	}
+	if region := cluster.Region().ID(); len(region) > 0 {
+		labels[provider.LabelRegion] = region
+	}
+	return labels
 }
 
 func clusterKey(obj interface{}) (string, error) {
 	accesor, err := meta.Accessor(obj)
-	return accesor.GetName(), err
+	if err != nil {
+		return "", err
+	}
+	return accesor.GetName(), nil
 }
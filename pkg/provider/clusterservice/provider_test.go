@@ -0,0 +1,219 @@
+package clusterservice
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/qiujian16/capi-importer/pkg/provider"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clusterapiv1 "open-cluster-management.io/api/cluster/v1"
+)
+
+func TestKey(t *testing.T) {
+	cases := []struct {
+		name     string
+		cluster  *clusterapiv1.ManagedCluster
+		expected []string
+	}{
+		{
+			name: "cluster",
+			cluster: &clusterapiv1.ManagedCluster{
+				ObjectMeta: metav1.ObjectMeta{Name: "cluster1"},
+			},
+			expected: []string{"clusterservice/cluster1"},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			p := NewClusterServiceProvider("", provider.NewMemoryCredentialStore(time.Hour))
+			keys := p.Key(c.cluster)
+			if !reflect.DeepEqual(keys, c.expected) {
+				t.Errorf("expected keys %v, but got %v", c.expected, keys)
+			}
+		})
+	}
+}
+
+func newCluster(id, state string) *clusterapiv1.ManagedCluster {
+	return &clusterapiv1.ManagedCluster{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   "cluster1",
+			Labels: map[string]string{provider.LabelCloud: provider.CloudAmazon},
+			Annotations: map[string]string{
+				annotationID:    id,
+				annotationState: state,
+			},
+		},
+	}
+}
+
+func TestClusterChanged(t *testing.T) {
+	cases := []struct {
+		name     string
+		old      *clusterapiv1.ManagedCluster
+		new      *clusterapiv1.ManagedCluster
+		expected bool
+	}{
+		{
+			name:     "not changed",
+			old:      newCluster("id1", "ready"),
+			new:      newCluster("id1", "ready"),
+			expected: false,
+		},
+		{
+			name:     "recreated",
+			old:      newCluster("id1", "ready"),
+			new:      newCluster("id2", "ready"),
+			expected: true,
+		},
+		{
+			name:     "state changed",
+			old:      newCluster("id1", "installing"),
+			new:      newCluster("id1", "ready"),
+			expected: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if changed := clusterChanged(c.old, c.new); changed != c.expected {
+				t.Errorf("expected changed %t, but got %t", c.expected, changed)
+			}
+		})
+	}
+}
+
+func TestDeleted(t *testing.T) {
+	cases := []struct {
+		name     string
+		synced   bool
+		cluster  *clusterapiv1.ManagedCluster
+		expected bool
+	}{
+		{
+			name: "clusters are not listed yet",
+		},
+		{
+			name:     "cluster is removed from the cluster service",
+			synced:   true,
+			expected: true,
+		},
+		{
+			name:     "cluster is being uninstalled",
+			synced:   true,
+			cluster:  newCluster("id1", "uninstalling"),
+			expected: true,
+		},
+		{
+			name:    "cluster is hibernating",
+			synced:  true,
+			cluster: newCluster("id1", "hibernating"),
+		},
+		{
+			name:    "cluster is ready",
+			synced:  true,
+			cluster: newCluster("id1", "ready"),
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			p := NewClusterServiceProvider("", provider.NewMemoryCredentialStore(time.Hour))
+			if c.cluster != nil {
+				if err := p.store.Add(c.cluster); err != nil {
+					t.Fatal(err)
+				}
+			}
+			p.synced.Store(c.synced)
+
+			deleted, err := p.Deleted(provider.ClusterRef{Provider: p.Name(), Name: "cluster1"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if deleted != c.expected {
+				t.Errorf("expected deleted %t, but got %t", c.expected, deleted)
+			}
+		})
+	}
+}
+
+func TestFirstListFailed(t *testing.T) {
+	// the connection fails to build without a token
+	p := NewClusterServiceProvider("", provider.NewMemoryCredentialStore(time.Hour))
+	if err := p.poll(context.TODO()); err == nil {
+		t.Fatalf("expected the clusters fail to be listed")
+	}
+
+	if !p.HasSynced() {
+		t.Errorf("expected the controller is not blocked by the cluster service")
+	}
+	deleted, err := p.Deleted(provider.ClusterRef{Provider: p.Name(), Name: "cluster1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted {
+		t.Errorf("expected the cluster is not deleted before the clusters are listed")
+	}
+}
+
+func TestLabels(t *testing.T) {
+	cases := []struct {
+		name        string
+		cluster     *clusterapiv1.ManagedCluster
+		expectedErr bool
+	}{
+		{
+			name:        "cluster does not exist",
+			expectedErr: true,
+		},
+		{
+			name:        "cluster is not ready",
+			cluster:     newCluster("id1", "hibernating"),
+			expectedErr: true,
+		},
+		{
+			name:    "cluster is ready",
+			cluster: newCluster("id1", "ready"),
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			p := NewClusterServiceProvider("", provider.NewMemoryCredentialStore(time.Hour))
+			if c.cluster != nil {
+				if err := p.store.Add(c.cluster); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			ref := provider.ClusterRef{Provider: p.Name(), Name: "cluster1"}
+			labels, err := p.Labels(ref)
+			if c.expectedErr {
+				if !errors.IsNotFound(err) {
+					t.Errorf("expected not found error, but got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(labels, c.cluster.Labels) {
+				t.Errorf("expected labels %v, but got %v", c.cluster.Labels, labels)
+			}
+			if id, err := p.ID(ref); err != nil || id != "id1" {
+				t.Errorf("expected id id1, but got %q, %v", id, err)
+			}
+		})
+	}
+}
+
+func TestStateSearch(t *testing.T) {
+	expected := "state in ('ready', 'hibernating', 'powering_down', 'resuming', 'error', 'unknown')"
+	if search := stateSearch(); search != expected {
+		t.Errorf("expected search %q, but got %q", expected, search)
+	}
+}
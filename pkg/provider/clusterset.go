@@ -0,0 +1,129 @@
+package provider
+
+import (
+	"sync/atomic"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+	clusterv1 "open-cluster-management.io/api/cluster/v1"
+)
+
+// ClusterSet keeps the clusters of the providers which list their clusters from a local source
+// instead of an informer, e.g. the files of a directory. Each listing is compared with the clusters
+// in the set, and the handler is notified with the clusters added, changed and removed. A cluster
+// missing from the listings is only removed after the grace period, so a file briefly removed by a
+// tool saving it with a backup and rename is not detached.
+type ClusterSet struct {
+	store       cache.Store
+	handler     cache.ResourceEventHandler
+	changed     func(old, new *clusterv1.ManagedCluster) bool
+	gracePeriod time.Duration
+	// missingSince is the time each cluster in the set is first missing from the listings
+	missingSince map[string]time.Time
+	synced       atomic.Bool
+	now          func() time.Time
+}
+
+// NewClusterSet returns a set of clusters, changed tells whether a listed cluster is updated.
+func NewClusterSet(changed func(old, new *clusterv1.ManagedCluster) bool, gracePeriod time.Duration) *ClusterSet {
+	return &ClusterSet{
+		store:        cache.NewStore(ClusterKey),
+		changed:      changed,
+		gracePeriod:  gracePeriod,
+		missingSince: map[string]time.Time{},
+		now:          time.Now,
+	}
+}
+
+func (s *ClusterSet) AddEventHandler(handler cache.ResourceEventHandler) {
+	s.handler = handler
+}
+
+// HasSynced returns true once the clusters of the first successful listing are added
+func (s *ClusterSet) HasSynced() bool {
+	return s.synced.Load()
+}
+
+// MarkSynced marks the set synced before its first successful listing, so the controllers are not
+// blocked if the source of the clusters is not available when the importer starts. The set has no
+// clusters until the source is listed.
+func (s *ClusterSet) MarkSynced() {
+	s.synced.Store(true)
+}
+
+// Get returns the cluster of the name in the set
+func (s *ClusterSet) Get(name string) (*clusterv1.ManagedCluster, bool, error) {
+	obj, exists, err := s.store.GetByKey(name)
+	if err != nil || !exists {
+		return nil, exists, err
+	}
+	return obj.(*clusterv1.ManagedCluster), true, nil
+}
+
+// Sync compares the listed clusters with the clusters in the set, it is only called with the result
+// of a successful listing. The clusters missing longer than the grace period are removed.
+func (s *ClusterSet) Sync(clusters map[string]*clusterv1.ManagedCluster) {
+	for name, cluster := range clusters {
+		delete(s.missingSince, name)
+
+		old, exists, err := s.Get(name)
+		if err != nil {
+			klog.Errorf("failed to get cluster %s from store: %v", name, err)
+			continue
+		}
+
+		if !exists {
+			if err := s.store.Add(cluster); err != nil {
+				klog.Errorf("failed to add cluster %s to store: %v", name, err)
+				continue
+			}
+			s.handler.OnAdd(cluster, false)
+			continue
+		}
+
+		if !s.changed(old, cluster) {
+			continue
+		}
+		if err := s.store.Update(cluster); err != nil {
+			klog.Errorf("failed to update cluster %s in store: %v", name, err)
+			continue
+		}
+		s.handler.OnUpdate(old, cluster)
+	}
+
+	now := s.now()
+	for _, obj := range s.store.List() {
+		name, _ := ClusterKey(obj)
+		if _, ok := clusters[name]; ok {
+			continue
+		}
+		missingSince, ok := s.missingSince[name]
+		if !ok {
+			missingSince = now
+			s.missingSince[name] = now
+		}
+		if now.Sub(missingSince) < s.gracePeriod {
+			continue
+		}
+
+		if err := s.store.Delete(obj); err != nil {
+			klog.Errorf("failed to delete cluster %s from store: %v", name, err)
+			continue
+		}
+		delete(s.missingSince, name)
+		s.handler.OnDelete(obj)
+	}
+
+	s.synced.Store(true)
+}
+
+// ClusterKey returns the name of the cluster as its key in the set
+func ClusterKey(obj interface{}) (string, error) {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return "", err
+	}
+	return accessor.GetName(), nil
+}
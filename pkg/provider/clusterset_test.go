@@ -0,0 +1,152 @@
+package provider
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+	clusterv1 "open-cluster-management.io/api/cluster/v1"
+)
+
+// eventRecorder records the events of the clusters notified to the handler
+type eventRecorder struct {
+	events []string
+}
+
+func (r *eventRecorder) handler() cache.ResourceEventHandler {
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			name, _ := ClusterKey(obj)
+			r.events = append(r.events, "add "+name)
+		},
+		UpdateFunc: func(_, obj interface{}) {
+			name, _ := ClusterKey(obj)
+			r.events = append(r.events, "update "+name)
+		},
+		DeleteFunc: func(obj interface{}) {
+			name, _ := ClusterKey(obj)
+			r.events = append(r.events, "delete "+name)
+		},
+	}
+}
+
+func (r *eventRecorder) reset() []string {
+	events := r.events
+	sort.Strings(events)
+	r.events = nil
+	return events
+}
+
+func listed(versions map[string]string) map[string]*clusterv1.ManagedCluster {
+	clusters := map[string]*clusterv1.ManagedCluster{}
+	for name, version := range versions {
+		clusters[name] = &clusterv1.ManagedCluster{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        name,
+				Annotations: map[string]string{"version": version},
+			},
+		}
+	}
+	return clusters
+}
+
+func TestClusterSet(t *testing.T) {
+	now := time.Now()
+	r := &eventRecorder{}
+	s := NewClusterSet(func(old, new *clusterv1.ManagedCluster) bool {
+		return old.Annotations["version"] != new.Annotations["version"]
+	}, time.Minute)
+	s.AddEventHandler(r.handler())
+	s.now = func() time.Time { return now }
+
+	if s.HasSynced() {
+		t.Errorf("expected not synced before the first listing")
+	}
+
+	steps := []struct {
+		name           string
+		elapsed        time.Duration
+		clusters       map[string]string
+		expectedEvents []string
+	}{
+		{
+			name:           "clusters are added",
+			clusters:       map[string]string{"cluster1": "v1", "cluster2": "v1"},
+			expectedEvents: []string{"add cluster1", "add cluster2"},
+		},
+		{
+			name:     "clusters are not changed",
+			clusters: map[string]string{"cluster1": "v1", "cluster2": "v1"},
+		},
+		{
+			name:           "cluster is changed",
+			clusters:       map[string]string{"cluster1": "v2", "cluster2": "v1"},
+			expectedEvents: []string{"update cluster1"},
+		},
+		{
+			name:     "cluster is missing",
+			clusters: map[string]string{"cluster1": "v2"},
+		},
+		{
+			name:     "cluster is back within the grace period",
+			elapsed:  30 * time.Second,
+			clusters: map[string]string{"cluster1": "v2", "cluster2": "v1"},
+		},
+		{
+			name:     "cluster is missing again",
+			elapsed:  time.Minute,
+			clusters: map[string]string{"cluster1": "v2"},
+		},
+		{
+			name:     "cluster is missing within the grace period",
+			elapsed:  90 * time.Second,
+			clusters: map[string]string{"cluster1": "v2"},
+		},
+		{
+			name:           "cluster is missing longer than the grace period",
+			elapsed:        2 * time.Minute,
+			clusters:       map[string]string{"cluster1": "v2"},
+			expectedEvents: []string{"delete cluster2"},
+		},
+	}
+
+	for _, step := range steps {
+		s.now = func() time.Time { return now.Add(step.elapsed) }
+		s.Sync(listed(step.clusters))
+		if events := r.reset(); !reflect.DeepEqual(events, step.expectedEvents) {
+			t.Errorf("%s: expected events %v, but got %v", step.name, step.expectedEvents, events)
+		}
+		if !s.HasSynced() {
+			t.Errorf("%s: expected synced", step.name)
+		}
+	}
+
+	if _, exists, _ := s.Get("cluster2"); exists {
+		t.Errorf("expected cluster2 is removed")
+	}
+	if cluster, exists, _ := s.Get("cluster1"); !exists || cluster.Annotations["version"] != "v2" {
+		t.Errorf("expected cluster1 of v2, but got %v", cluster)
+	}
+}
+
+func TestClusterSetMarkSynced(t *testing.T) {
+	r := &eventRecorder{}
+	s := NewClusterSet(func(_, _ *clusterv1.ManagedCluster) bool { return false }, time.Minute)
+	s.AddEventHandler(r.handler())
+
+	s.MarkSynced()
+	if !s.HasSynced() {
+		t.Errorf("expected synced")
+	}
+	if events := r.reset(); len(events) != 0 {
+		t.Errorf("expected no events, but got %v", events)
+	}
+
+	s.Sync(listed(map[string]string{"cluster1": "v1"}))
+	if events := r.reset(); !reflect.DeepEqual(events, []string{"add cluster1"}) {
+		t.Errorf("expected cluster1 is added, but got %v", events)
+	}
+}
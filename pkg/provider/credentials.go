@@ -0,0 +1,166 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// LabelCredentialProvider is set on the secrets of the credential store with the provider name
+	LabelCredentialProvider = "import.open-cluster-management.io/provider"
+
+	credentialKey = "kubeconfig"
+)
+
+var credentialResource = schema.GroupResource{Resource: "credentials"}
+
+// CredentialStore keeps the kubeconfigs fetched by providers, so the kubeconfigs are fetched
+// lazily when the cluster is imported and are never kept in the metadata of the objects.
+type CredentialStore interface {
+	// Get returns a NotFound error if the kubeconfig of the cluster is not in the store.
+	Get(ctx context.Context, ref ClusterRef) ([]byte, error)
+
+	Set(ctx context.Context, ref ClusterRef, kubeconfig []byte) error
+
+	Delete(ctx context.Context, ref ClusterRef) error
+}
+
+// CredentialEvicter is implemented by the providers which keep kubeconfigs in a CredentialStore,
+// the importer evicts the kubeconfig once it is done with the cluster.
+type CredentialEvicter interface {
+	Evict(ctx context.Context, ref ClusterRef) error
+}
+
+type credential struct {
+	kubeconfig []byte
+	expireAt   time.Time
+}
+
+// memoryCredentialStore keeps the kubeconfigs in memory, and drops them after the ttl.
+type memoryCredentialStore struct {
+	lock        sync.Mutex
+	ttl         time.Duration
+	credentials map[string]credential
+	now         func() time.Time
+}
+
+func NewMemoryCredentialStore(ttl time.Duration) CredentialStore {
+	return &memoryCredentialStore{
+		ttl:         ttl,
+		credentials: map[string]credential{},
+		now:         time.Now,
+	}
+}
+
+func (s *memoryCredentialStore) Get(_ context.Context, ref ClusterRef) ([]byte, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	c, ok := s.credentials[ref.Key()]
+	if !ok {
+		return nil, errors.NewNotFound(credentialResource, ref.Key())
+	}
+	if s.now().After(c.expireAt) {
+		delete(s.credentials, ref.Key())
+		return nil, errors.NewNotFound(credentialResource, ref.Key())
+	}
+	return c.kubeconfig, nil
+}
+
+func (s *memoryCredentialStore) Set(_ context.Context, ref ClusterRef, kubeconfig []byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.credentials[ref.Key()] = credential{
+		kubeconfig: kubeconfig,
+		expireAt:   s.now().Add(s.ttl),
+	}
+	return nil
+}
+
+func (s *memoryCredentialStore) Delete(_ context.Context, ref ClusterRef) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	delete(s.credentials, ref.Key())
+	return nil
+}
+
+// secretCredentialStore keeps the kubeconfigs in secrets on the hub, so they survive the
+// restart of the importer and are protected by the RBAC of the hub.
+type secretCredentialStore struct {
+	client    kubernetes.Interface
+	namespace string
+}
+
+func NewSecretCredentialStore(client kubernetes.Interface, namespace string) CredentialStore {
+	return &secretCredentialStore{
+		client:    client,
+		namespace: namespace,
+	}
+}
+
+func (s *secretCredentialStore) Get(ctx context.Context, ref ClusterRef) ([]byte, error) {
+	secret, err := s.client.CoreV1().Secrets(s.namespace).Get(ctx, credentialSecretName(ref), metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	kubeconfig, ok := secret.Data[credentialKey]
+	if !ok {
+		return nil, errors.NewNotFound(credentialResource, ref.Key())
+	}
+	return kubeconfig, nil
+}
+
+func (s *secretCredentialStore) Set(ctx context.Context, ref ClusterRef, kubeconfig []byte) error {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      credentialSecretName(ref),
+			Namespace: s.namespace,
+			Labels: map[string]string{
+				LabelCredentialProvider: ref.Provider,
+			},
+		},
+		Type: corev1.SecretTypeOpaque,
+		Data: map[string][]byte{
+			credentialKey: kubeconfig,
+		},
+	}
+
+	existing, err := s.client.CoreV1().Secrets(s.namespace).Get(ctx, secret.Name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		_, err = s.client.CoreV1().Secrets(s.namespace).Create(ctx, secret, metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	existing = existing.DeepCopy()
+	existing.Data = secret.Data
+	_, err = s.client.CoreV1().Secrets(s.namespace).Update(ctx, existing, metav1.UpdateOptions{})
+	return err
+}
+
+func (s *secretCredentialStore) Delete(ctx context.Context, ref ClusterRef) error {
+	err := s.client.CoreV1().Secrets(s.namespace).Delete(ctx, credentialSecretName(ref), metav1.DeleteOptions{})
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+func credentialSecretName(ref ClusterRef) string {
+	if len(ref.Namespace) == 0 {
+		return fmt.Sprintf("%s-%s-kubeconfig", ref.Provider, ref.Name)
+	}
+	return fmt.Sprintf("%s-%s-%s-kubeconfig", ref.Provider, ref.Namespace, ref.Name)
+}
@@ -0,0 +1,174 @@
+package provider
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+)
+
+func TestMemoryCredentialStore(t *testing.T) {
+	ref := ClusterRef{Provider: "clusterservice", Name: "cluster1"}
+	now := time.Now()
+
+	cases := []struct {
+		name          string
+		elapsed       time.Duration
+		deleted       bool
+		expectedFound bool
+	}{
+		{
+			name:          "credential is kept within ttl",
+			elapsed:       time.Minute,
+			expectedFound: true,
+		},
+		{
+			name:    "credential expires after ttl",
+			elapsed: 2 * time.Hour,
+		},
+		{
+			name:    "credential is deleted",
+			elapsed: time.Minute,
+			deleted: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			store := NewMemoryCredentialStore(time.Hour).(*memoryCredentialStore)
+			store.now = func() time.Time { return now }
+
+			if err := store.Set(context.TODO(), ref, []byte("kubeconfig")); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if c.deleted {
+				if err := store.Delete(context.TODO(), ref); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+
+			store.now = func() time.Time { return now.Add(c.elapsed) }
+			kubeconfig, err := store.Get(context.TODO(), ref)
+			if !c.expectedFound {
+				if !errors.IsNotFound(err) {
+					t.Errorf("expected not found error, but got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if string(kubeconfig) != "kubeconfig" {
+				t.Errorf("unexpected kubeconfig %q", kubeconfig)
+			}
+		})
+	}
+}
+
+func TestSecretCredentialStore(t *testing.T) {
+	ref := ClusterRef{Provider: "clusterservice", Name: "cluster1"}
+	newSecret := func(data map[string][]byte) *corev1.Secret {
+		return &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "clusterservice-cluster1-kubeconfig", Namespace: "ns1"},
+			Data:       data,
+		}
+	}
+
+	cases := []struct {
+		name               string
+		existing           []runtime.Object
+		kubeconfig         []byte
+		deleted            bool
+		expectedKubeConfig []byte
+		expectedActions    []string
+	}{
+		{
+			name:            "credential is not in the store",
+			expectedActions: []string{"get"},
+		},
+		{
+			name:            "secret without the kubeconfig",
+			existing:        []runtime.Object{newSecret(map[string][]byte{"foo": []byte("bar")})},
+			expectedActions: []string{"get"},
+		},
+		{
+			name:               "credential is stored",
+			kubeconfig:         []byte("kubeconfig"),
+			expectedKubeConfig: []byte("kubeconfig"),
+			expectedActions:    []string{"get", "create", "get"},
+		},
+		{
+			name:               "credential is updated",
+			existing:           []runtime.Object{newSecret(map[string][]byte{credentialKey: []byte("old")})},
+			kubeconfig:         []byte("kubeconfig"),
+			expectedKubeConfig: []byte("kubeconfig"),
+			expectedActions:    []string{"get", "update", "get"},
+		},
+		{
+			name:            "credential is deleted",
+			existing:        []runtime.Object{newSecret(map[string][]byte{credentialKey: []byte("old")})},
+			deleted:         true,
+			expectedActions: []string{"delete", "get"},
+		},
+		{
+			name:            "credential not in the store is deleted",
+			deleted:         true,
+			expectedActions: []string{"delete", "get"},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			client := kubefake.NewSimpleClientset(c.existing...)
+			store := NewSecretCredentialStore(client, "ns1")
+
+			if len(c.kubeconfig) > 0 {
+				if err := store.Set(context.TODO(), ref, c.kubeconfig); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+			if c.deleted {
+				if err := store.Delete(context.TODO(), ref); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+
+			kubeconfig, err := store.Get(context.TODO(), ref)
+			switch {
+			case len(c.expectedKubeConfig) == 0:
+				if !errors.IsNotFound(err) {
+					t.Errorf("expected not found error, but got %v", err)
+				}
+			case err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case string(kubeconfig) != string(c.expectedKubeConfig):
+				t.Errorf("expected kubeconfig %q, but got %q", c.expectedKubeConfig, kubeconfig)
+			}
+
+			var verbs []string
+			for _, action := range client.Actions() {
+				verbs = append(verbs, action.GetVerb())
+			}
+			if !reflect.DeepEqual(verbs, c.expectedActions) {
+				t.Errorf("expected actions %v, but got %v", c.expectedActions, verbs)
+			}
+
+			if len(c.kubeconfig) == 0 {
+				return
+			}
+			secret, err := client.CoreV1().Secrets("ns1").Get(context.TODO(), "clusterservice-cluster1-kubeconfig", metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if c.existing == nil && secret.Labels[LabelCredentialProvider] != ref.Provider {
+				t.Errorf("expected the secret is labeled with the provider, but got %v", secret.Labels)
+			}
+		})
+	}
+}
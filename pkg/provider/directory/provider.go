@@ -0,0 +1,229 @@
+package directory
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/qiujian16/capi-importer/pkg/provider"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/klog/v2"
+	clusterapiv1 "open-cluster-management.io/api/cluster/v1"
+)
+
+const (
+	annotationPath = "path"
+	annotationHash = "hash"
+
+	// resyncInterval is the interval to list the files again, so the clusters missing longer than
+	// the grace period are removed, and the files are still loaded if the directory is not watched.
+	resyncInterval = 10 * time.Second
+	// gracePeriod is how long a file is missing before its cluster is removed
+	gracePeriod = 30 * time.Second
+)
+
+var fileResource = schema.GroupResource{Resource: "kubeconfigfiles"}
+
+// DirectoryProvider imports the clusters of the kubeconfig files in a local directory, the name
+// of each file without its extension is the name of the cluster. It lets the importer run outside
+// of a cluster, e.g. against kind clusters in CI.
+type DirectoryProvider struct {
+	dir      string
+	clusters *provider.ClusterSet
+}
+
+func NewDirectoryProvider(dir string) *DirectoryProvider {
+	return &DirectoryProvider{
+		dir:      dir,
+		clusters: provider.NewClusterSet(clusterChanged, gracePeriod),
+	}
+}
+
+func (d *DirectoryProvider) AddEventHandler(handler cache.ResourceEventHandler) (cache.ResourceEventHandlerRegistration, error) {
+	d.clusters.AddEventHandler(handler)
+	return d, nil
+}
+
+// HasSynced returns true once the files in the directory are loaded
+func (d *DirectoryProvider) HasSynced() bool {
+	return d.clusters.HasSynced()
+}
+
+func (d *DirectoryProvider) Key(obj runtime.Object) []string {
+	name, err := provider.ClusterKey(obj)
+	if err != nil {
+		return []string{}
+	}
+	ref := provider.ClusterRef{
+		Provider: d.Name(),
+		Name:     name,
+	}
+	return []string{ref.Key()}
+}
+
+func (d *DirectoryProvider) KubeConfig(ref provider.ClusterRef) (clientcmd.ClientConfig, error) {
+	cluster, exist, err := d.clusters.Get(ref.Name)
+	if err != nil {
+		return nil, err
+	}
+	if !exist {
+		return nil, errors.NewNotFound(fileResource, ref.Name)
+	}
+
+	config, err := clientcmd.LoadFromFile(cluster.Annotations[annotationPath])
+	if os.IsNotExist(err) {
+		return nil, errors.NewNotFound(fileResource, ref.Name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// the certificate files referred by relative paths are next to the kubeconfig file
+	if err := clientcmd.ResolveLocalPaths(config); err != nil {
+		return nil, err
+	}
+	return clientcmd.NewDefaultClientConfig(*config, &clientcmd.ConfigOverrides{}), nil
+}
+
+func (d *DirectoryProvider) Labels(ref provider.ClusterRef) (map[string]string, error) {
+	_, exist, err := d.clusters.Get(ref.Name)
+	if err != nil {
+		return nil, err
+	}
+	if !exist {
+		return nil, errors.NewNotFound(fileResource, ref.Name)
+	}
+	return map[string]string{
+		provider.LabelInfrastructureKind: "KubeConfigFile",
+		provider.LabelCloud:              provider.CloudOther,
+	}, nil
+}
+
+func (d *DirectoryProvider) Deleted(ref provider.ClusterRef) (bool, error) {
+	_, exist, err := d.clusters.Get(ref.Name)
+	if err != nil {
+		return false, err
+	}
+	return !exist, nil
+}
+
+func (d *DirectoryProvider) Name() string {
+	return "directory"
+}
+
+// Start loads the files in the directory and reloads them on any change of the directory. The whole
+// directory is reloaded, since editors and tools usually write a file by renaming a temporary one.
+// The directory is polled if it can not be watched.
+func (d *DirectoryProvider) Start(ctx context.Context) {
+	var events <-chan fsnotify.Event
+	var watchErrors <-chan error
+	watcher, err := fsnotify.NewWatcher()
+	if err == nil {
+		defer watcher.Close()
+		err = watcher.Add(d.dir)
+	}
+	if err != nil {
+		klog.Errorf("failed to watch directory %s, poll it every %s: %v", d.dir, resyncInterval, err)
+	} else {
+		events, watchErrors = watcher.Events, watcher.Errors
+	}
+
+	ticker := time.NewTicker(resyncInterval)
+	defer ticker.Stop()
+
+	d.sync()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			d.sync()
+		case event, ok := <-events:
+			if !ok {
+				events = nil
+				continue
+			}
+			klog.V(4).Infof("file %s is changed: %s", event.Name, event.Op)
+			d.sync()
+		case err, ok := <-watchErrors:
+			if !ok {
+				watchErrors = nil
+				continue
+			}
+			klog.Errorf("failed to watch directory %s: %v", d.dir, err)
+		}
+	}
+}
+
+// sync lists the kubeconfig files in the directory and compares them with the clusters in the set,
+// the clusters are kept if the directory fails to be read. The set is synced without any cluster if
+// the directory is not readable when the importer starts, so the other providers are not blocked.
+func (d *DirectoryProvider) sync() {
+	clusters, err := d.listClusters()
+	if err != nil {
+		klog.Errorf("failed to list kubeconfig files in directory %s: %v", d.dir, err)
+		d.clusters.MarkSynced()
+		return
+	}
+	d.clusters.Sync(clusters)
+}
+
+// listClusters reads the regular files in the directory, hidden files and files whose names are
+// not valid cluster names are skipped.
+func (d *DirectoryProvider) listClusters() (map[string]*clusterapiv1.ManagedCluster, error) {
+	entries, err := os.ReadDir(d.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	clusters := map[string]*clusterapiv1.ManagedCluster{}
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		name := strings.TrimSuffix(entry.Name(), filepath.Ext(entry.Name()))
+		if errs := validation.IsDNS1123Label(name); len(errs) > 0 {
+			klog.Warningf("skip file %s, %s is not a valid cluster name: %s", entry.Name(), name, strings.Join(errs, ", "))
+			continue
+		}
+		if existing, ok := clusters[name]; ok {
+			klog.Warningf("skip file %s, cluster %s is loaded from %s", entry.Name(), name, existing.Annotations[annotationPath])
+			continue
+		}
+
+		path := filepath.Join(d.dir, entry.Name())
+		data, err := os.ReadFile(path)
+		if err != nil {
+			// the file may be removed after the directory is read
+			klog.Warningf("failed to read file %s: %v", path, err)
+			continue
+		}
+		hash := sha256.Sum256(data)
+		clusters[name] = &clusterapiv1.ManagedCluster{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: name,
+				Annotations: map[string]string{
+					annotationPath: path,
+					annotationHash: hex.EncodeToString(hash[:]),
+				},
+			},
+		}
+	}
+	return clusters, nil
+}
+
+// clusterChanged returns true if the file of the cluster or its content is changed
+func clusterChanged(old, new *clusterapiv1.ManagedCluster) bool {
+	return old.Annotations[annotationPath] != new.Annotations[annotationPath] ||
+		old.Annotations[annotationHash] != new.Annotations[annotationHash]
+}
@@ -0,0 +1,186 @@
+package directory
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/qiujian16/capi-importer/pkg/provider"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/tools/cache"
+	clusterapiv1 "open-cluster-management.io/api/cluster/v1"
+)
+
+type recorder struct {
+	events []string
+}
+
+func (r *recorder) handler() cache.ResourceEventHandler {
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			name, _ := provider.ClusterKey(obj)
+			r.events = append(r.events, "add "+name)
+		},
+		UpdateFunc: func(_, obj interface{}) {
+			name, _ := provider.ClusterKey(obj)
+			r.events = append(r.events, "update "+name)
+		},
+		DeleteFunc: func(obj interface{}) {
+			name, _ := provider.ClusterKey(obj)
+			r.events = append(r.events, "delete "+name)
+		},
+	}
+}
+
+func (r *recorder) reset() []string {
+	events := r.events
+	sort.Strings(events)
+	r.events = nil
+	return events
+}
+
+func writeFile(t *testing.T, dir, name, content string) {
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSync(t *testing.T) {
+	dir := t.TempDir()
+	r := &recorder{}
+	p := NewDirectoryProvider(dir)
+	// the removed files are tested without the grace period
+	p.clusters = provider.NewClusterSet(clusterChanged, 0)
+	if _, err := p.AddEventHandler(r.handler()); err != nil {
+		t.Fatal(err)
+	}
+
+	writeFile(t, dir, "cluster1.kubeconfig", "v1")
+	writeFile(t, dir, "cluster2", "v1")
+	writeFile(t, dir, ".hidden", "v1")
+	writeFile(t, dir, "Invalid_Name.yaml", "v1")
+	if err := os.Mkdir(filepath.Join(dir, "cluster3"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	p.sync()
+	if events := r.reset(); !reflect.DeepEqual(events, []string{"add cluster1", "add cluster2"}) {
+		t.Errorf("unexpected events %v", events)
+	}
+
+	p.sync()
+	if events := r.reset(); len(events) != 0 {
+		t.Errorf("unexpected events %v", events)
+	}
+
+	writeFile(t, dir, "cluster1.kubeconfig", "v2")
+	if err := os.Remove(filepath.Join(dir, "cluster2")); err != nil {
+		t.Fatal(err)
+	}
+	p.sync()
+	if events := r.reset(); !reflect.DeepEqual(events, []string{"delete cluster2", "update cluster1"}) {
+		t.Errorf("unexpected events %v", events)
+	}
+
+	if keys := p.Key(mustGet(t, p, "cluster1")); !reflect.DeepEqual(keys, []string{"directory/cluster1"}) {
+		t.Errorf("unexpected keys %v", keys)
+	}
+}
+
+func mustGet(t *testing.T, p *DirectoryProvider, name string) *clusterapiv1.ManagedCluster {
+	cluster, exists, err := p.clusters.Get(name)
+	if err != nil || !exists {
+		t.Fatalf("cluster %s is not found: %v", name, err)
+	}
+	return cluster
+}
+
+func TestSyncFailure(t *testing.T) {
+	dir := t.TempDir()
+	r := &recorder{}
+	p := NewDirectoryProvider(filepath.Join(dir, "clusters"))
+	if _, err := p.AddEventHandler(r.handler()); err != nil {
+		t.Fatal(err)
+	}
+
+	// the directory does not exist yet, the provider is synced without any cluster
+	p.sync()
+	if !p.HasSynced() {
+		t.Errorf("expected synced if the directory is missing at startup")
+	}
+	if events := r.reset(); len(events) != 0 {
+		t.Errorf("unexpected events %v", events)
+	}
+
+	if err := os.Mkdir(filepath.Join(dir, "clusters"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(dir, "clusters"), "cluster1", "v1")
+	p.sync()
+	if !p.HasSynced() {
+		t.Errorf("expected synced once the directory is read")
+	}
+	if events := r.reset(); !reflect.DeepEqual(events, []string{"add cluster1"}) {
+		t.Errorf("unexpected events %v", events)
+	}
+
+	// the clusters are kept when the directory fails to be read
+	if err := os.RemoveAll(filepath.Join(dir, "clusters")); err != nil {
+		t.Fatal(err)
+	}
+	p.sync()
+	if events := r.reset(); len(events) != 0 {
+		t.Errorf("unexpected events %v", events)
+	}
+}
+
+func TestKubeConfig(t *testing.T) {
+	dir := t.TempDir()
+	r := &recorder{}
+	p := NewDirectoryProvider(dir)
+	if _, err := p.AddEventHandler(r.handler()); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Mkdir(filepath.Join(dir, "certs"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(dir, "certs"), "ca.crt", "ca")
+	writeFile(t, dir, "cluster1.kubeconfig", `apiVersion: v1
+kind: Config
+clusters:
+- name: cluster1
+  cluster:
+    server: https://cluster1:6443
+    certificate-authority: certs/ca.crt
+users:
+- name: admin
+  user:
+    token: token1
+contexts:
+- name: cluster1
+  context:
+    cluster: cluster1
+    user: admin
+current-context: cluster1
+`)
+	p.sync()
+
+	kubeConfig, err := p.KubeConfig(provider.ClusterRef{Provider: "directory", Name: "cluster1"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	config, err := kubeConfig.ClientConfig()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// the relative path is resolved against the directory of the kubeconfig file
+	if expected := filepath.Join(dir, "certs", "ca.crt"); config.CAFile != expected {
+		t.Errorf("expected CA file %s, but got %s", expected, config.CAFile)
+	}
+
+	if _, err := p.KubeConfig(provider.ClusterRef{Provider: "directory", Name: "cluster2"}); !errors.IsNotFound(err) {
+		t.Errorf("expected not found error, but got %v", err)
+	}
+}
@@ -0,0 +1,120 @@
+package hive
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"github.com/qiujian16/capi-importer/pkg/provider"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// kubeConfigKey is the key of the kubeconfig in the admin kubeconfig secret of hive
+const kubeConfigKey = "kubeconfig"
+
+// HiveProvider imports the OpenShift clusters installed by hive ClusterDeployments with the admin
+// kubeconfig of the cluster.
+type HiveProvider struct {
+	*provider.ObjectProvider
+	kubeClient kubernetes.Interface
+}
+
+var gvr = schema.GroupVersionResource{
+	Group:    "hive.openshift.io",
+	Version:  "v1",
+	Resource: "clusterdeployments",
+}
+
+func NewHiveProvider(kubeconfig *rest.Config) *HiveProvider {
+	return &HiveProvider{
+		ObjectProvider: provider.NewObjectProvider("hive", gvr, dynamic.NewForConfigOrDie(kubeconfig), isInstalled),
+		kubeClient:     kubernetes.NewForConfigOrDie(kubeconfig),
+	}
+}
+
+func (h *HiveProvider) Labels(ref provider.ClusterRef) (map[string]string, error) {
+	cd, err := h.Get(ref)
+	if err != nil {
+		return nil, err
+	}
+	return platformLabels(cd), nil
+}
+
+func (h *HiveProvider) KubeConfig(ref provider.ClusterRef) (clientcmd.ClientConfig, error) {
+	cd, err := h.Get(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	// the admin kubeconfig is only set once the cluster is installed, return not found so the
+	// controller waits for the next event of the ClusterDeployment.
+	name, _, _ := unstructured.NestedString(cd.Object, "spec", "clusterMetadata", "adminKubeconfigSecretRef", "name")
+	if !isInstalled(cd) || len(name) == 0 {
+		return nil, apierrors.NewNotFound(gvr.GroupResource(), cd.GetName())
+	}
+
+	secret, err := h.kubeClient.CoreV1().Secrets(cd.GetNamespace()).Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	data, ok := secret.Data[kubeConfigKey]
+	if !ok {
+		return nil, errors.Errorf("missing key %q in secret %s/%s", kubeConfigKey, secret.Namespace, secret.Name)
+	}
+	return clientcmd.NewClientConfigFromBytes(data)
+}
+
+func isInstalled(cd *unstructured.Unstructured) bool {
+	installed, _, _ := unstructured.NestedBool(cd.Object, "spec", "installed")
+	return installed
+}
+
+// platform describes a platform of hive by its key in the spec.platform of the ClusterDeployment
+type platform struct {
+	cloud string
+	// hasRegion is true if the platform has the region field
+	hasRegion bool
+}
+
+var platforms = map[string]platform{
+	"aws":            {cloud: provider.CloudAmazon, hasRegion: true},
+	"azure":          {cloud: provider.CloudAzure, hasRegion: true},
+	"gcp":            {cloud: provider.CloudGoogle, hasRegion: true},
+	"ibmcloud":       {cloud: provider.CloudIBM, hasRegion: true},
+	"powervs":        {cloud: provider.CloudIBM, hasRegion: true},
+	"vsphere":        {cloud: provider.CloudVSphere},
+	"openstack":      {cloud: provider.CloudOpenStack},
+	"baremetal":      {cloud: provider.CloudBareMetal},
+	"agentBareMetal": {cloud: provider.CloudBareMetal},
+}
+
+// platformLabels builds the labels from the platform set in the spec of the ClusterDeployment
+func platformLabels(cd *unstructured.Unstructured) map[string]string {
+	labels := map[string]string{
+		provider.LabelInfrastructureKind: "ClusterDeployment",
+		provider.LabelCloud:              provider.CloudOther,
+	}
+
+	spec, _, _ := unstructured.NestedMap(cd.Object, "spec", "platform")
+	for key := range spec {
+		p, ok := platforms[key]
+		if !ok {
+			continue
+		}
+		labels[provider.LabelCloud] = p.cloud
+		if !p.hasRegion {
+			continue
+		}
+		if region, _, _ := unstructured.NestedString(spec, key, "region"); len(region) > 0 {
+			labels[provider.LabelRegion] = region
+		}
+	}
+	return labels
+}
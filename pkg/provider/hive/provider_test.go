@@ -0,0 +1,82 @@
+package hive
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/qiujian16/capi-importer/pkg/provider"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func newClusterDeployment(installed bool, platform map[string]interface{}) *unstructured.Unstructured {
+	return &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": "hive.openshift.io/v1",
+			"kind":       "ClusterDeployment",
+			"metadata": map[string]interface{}{
+				"name":      "cluster1",
+				"namespace": "cluster1",
+			},
+			"spec": map[string]interface{}{
+				"installed": installed,
+				"platform":  platform,
+			},
+		},
+	}
+}
+
+func TestPlatformLabels(t *testing.T) {
+	cases := []struct {
+		name     string
+		platform map[string]interface{}
+		expected map[string]string
+	}{
+		{
+			name: "no platform",
+			expected: map[string]string{
+				provider.LabelInfrastructureKind: "ClusterDeployment",
+				provider.LabelCloud:              provider.CloudOther,
+			},
+		},
+		{
+			name: "aws",
+			platform: map[string]interface{}{
+				"aws": map[string]interface{}{"region": "us-east-1"},
+			},
+			expected: map[string]string{
+				provider.LabelInfrastructureKind: "ClusterDeployment",
+				provider.LabelCloud:              provider.CloudAmazon,
+				provider.LabelRegion:             "us-east-1",
+			},
+		},
+		{
+			name: "vsphere",
+			platform: map[string]interface{}{
+				"vsphere": map[string]interface{}{"vCenter": "vcenter.example.com"},
+			},
+			expected: map[string]string{
+				provider.LabelInfrastructureKind: "ClusterDeployment",
+				provider.LabelCloud:              provider.CloudVSphere,
+			},
+		},
+		{
+			name: "unknown platform",
+			platform: map[string]interface{}{
+				"nutanix": map[string]interface{}{},
+			},
+			expected: map[string]string{
+				provider.LabelInfrastructureKind: "ClusterDeployment",
+				provider.LabelCloud:              provider.CloudOther,
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			labels := platformLabels(newClusterDeployment(true, c.platform))
+			if !reflect.DeepEqual(labels, c.expected) {
+				t.Errorf("expected labels %v, but got %v", c.expected, labels)
+			}
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package hypershift
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"github.com/qiujian16/capi-importer/pkg/provider"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	operatorv1 "open-cluster-management.io/api/operator/v1"
+)
+
+const (
+	// kubeConfigKey is the key of the kubeconfig in the secret of status.kubeConfig
+	kubeConfigKey = "kubeconfig"
+
+	conditionAvailable = "Available"
+)
+
+// HyperShiftProvider imports the HostedClusters of HyperShift. The guest clusters only run the
+// workloads, so the klusterlets are deployed on the management cluster in a hosted mode by default.
+type HyperShiftProvider struct {
+	*provider.ObjectProvider
+	kubeClient        kubernetes.Interface
+	mode              operatorv1.InstallMode
+	hostingKubeConfig clientcmd.ClientConfig
+}
+
+var gvr = schema.GroupVersionResource{
+	Group:    "hypershift.openshift.io",
+	Version:  "v1beta1",
+	Resource: "hostedclusters",
+}
+
+// NewHyperShiftProvider watches the HostedClusters on the management cluster of the kubeconfig, the
+// klusterlets are deployed in the mode on the hosting cluster, which is usually the management cluster.
+func NewHyperShiftProvider(
+	kubeconfig *rest.Config, mode operatorv1.InstallMode, hostingKubeConfig clientcmd.ClientConfig) *HyperShiftProvider {
+	return &HyperShiftProvider{
+		ObjectProvider:    provider.NewObjectProvider("hypershift", gvr, dynamic.NewForConfigOrDie(kubeconfig), isAvailable),
+		kubeClient:        kubernetes.NewForConfigOrDie(kubeconfig),
+		mode:              mode,
+		hostingKubeConfig: hostingKubeConfig,
+	}
+}
+
+func (h *HyperShiftProvider) Labels(ref provider.ClusterRef) (map[string]string, error) {
+	hc, err := h.Get(ref)
+	if err != nil {
+		return nil, err
+	}
+	return platformLabels(hc), nil
+}
+
+func (h *HyperShiftProvider) KubeConfig(ref provider.ClusterRef) (clientcmd.ClientConfig, error) {
+	hc, err := h.Get(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	// the kubeconfig is published once the control plane is up, return not found so the
+	// controller waits for the next event of the HostedCluster.
+	name, _, _ := unstructured.NestedString(hc.Object, "status", "kubeConfig", "name")
+	if len(name) == 0 {
+		return nil, apierrors.NewNotFound(gvr.GroupResource(), hc.GetName())
+	}
+
+	secret, err := h.kubeClient.CoreV1().Secrets(hc.GetNamespace()).Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	data, ok := secret.Data[kubeConfigKey]
+	if !ok {
+		return nil, errors.Errorf("missing key %q in secret %s/%s", kubeConfigKey, secret.Namespace, secret.Name)
+	}
+	return clientcmd.NewClientConfigFromBytes(data)
+}
+
+// HostedKlusterlet deploys the klusterlets of the HostedClusters on the hosting cluster
+func (h *HyperShiftProvider) HostedKlusterlet(_ provider.ClusterRef) (operatorv1.InstallMode, clientcmd.ClientConfig) {
+	return h.mode, h.hostingKubeConfig
+}
+
+// isAvailable checks the Available condition of the HostedCluster, which is true once the
+// apiserver of the guest cluster is reachable.
+func isAvailable(hc *unstructured.Unstructured) bool {
+	conditions, _, _ := unstructured.NestedSlice(hc.Object, "status", "conditions")
+	for _, c := range conditions {
+		condition, ok := c.(map[string]interface{})
+		if !ok || condition["type"] != conditionAvailable {
+			continue
+		}
+		return condition["status"] == string(metav1.ConditionTrue)
+	}
+	return false
+}
+
+// platform describes a platform of HyperShift by the spec.platform.type of the HostedCluster
+type platform struct {
+	cloud string
+	// regionPath is the field path of the region under spec.platform, it is empty if the
+	// platform has no notion of region.
+	regionPath []string
+}
+
+var platforms = map[string]platform{
+	"AWS":       {cloud: provider.CloudAmazon, regionPath: []string{"aws", "region"}},
+	"Azure":     {cloud: provider.CloudAzure, regionPath: []string{"azure", "location"}},
+	"PowerVS":   {cloud: provider.CloudIBM, regionPath: []string{"powervs", "region"}},
+	"IBMCloud":  {cloud: provider.CloudIBM},
+	"OpenStack": {cloud: provider.CloudOpenStack},
+	"Agent":     {cloud: provider.CloudBareMetal},
+}
+
+// platformLabels builds the labels from the platform in the spec of the HostedCluster
+func platformLabels(hc *unstructured.Unstructured) map[string]string {
+	labels := map[string]string{
+		provider.LabelInfrastructureKind: "HostedCluster",
+		provider.LabelCloud:              provider.CloudOther,
+	}
+
+	platformType, _, _ := unstructured.NestedString(hc.Object, "spec", "platform", "type")
+	p, ok := platforms[platformType]
+	if !ok {
+		return labels
+	}
+	labels[provider.LabelCloud] = p.cloud
+	if len(p.regionPath) == 0 {
+		return labels
+	}
+	path := append([]string{"spec", "platform"}, p.regionPath...)
+	if region, _, _ := unstructured.NestedString(hc.Object, path...); len(region) > 0 {
+		labels[provider.LabelRegion] = region
+	}
+	return labels
+}
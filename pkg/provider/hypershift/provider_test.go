@@ -0,0 +1,110 @@
+package hypershift
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/qiujian16/capi-importer/pkg/provider"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func newHostedCluster(available string, platform map[string]interface{}) *unstructured.Unstructured {
+	hc := &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": "hypershift.openshift.io/v1beta1",
+			"kind":       "HostedCluster",
+			"metadata": map[string]interface{}{
+				"name":      "cluster1",
+				"namespace": "clusters",
+			},
+			"spec": map[string]interface{}{
+				"platform": platform,
+			},
+		},
+	}
+	if len(available) > 0 {
+		hc.Object["status"] = map[string]interface{}{
+			"conditions": []interface{}{
+				map[string]interface{}{"type": "Degraded", "status": "False"},
+				map[string]interface{}{"type": conditionAvailable, "status": available},
+			},
+		}
+	}
+	return hc
+}
+
+func TestIsAvailable(t *testing.T) {
+	cases := []struct {
+		name     string
+		hc       *unstructured.Unstructured
+		expected bool
+	}{
+		{
+			name: "no conditions",
+			hc:   newHostedCluster("", nil),
+		},
+		{
+			name: "not available",
+			hc:   newHostedCluster("False", nil),
+		},
+		{
+			name:     "available",
+			hc:       newHostedCluster("True", nil),
+			expected: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if available := isAvailable(c.hc); available != c.expected {
+				t.Errorf("expected available %v, but got %v", c.expected, available)
+			}
+		})
+	}
+}
+
+func TestPlatformLabels(t *testing.T) {
+	cases := []struct {
+		name     string
+		platform map[string]interface{}
+		expected map[string]string
+	}{
+		{
+			name:     "none",
+			platform: map[string]interface{}{"type": "None"},
+			expected: map[string]string{
+				provider.LabelInfrastructureKind: "HostedCluster",
+				provider.LabelCloud:              provider.CloudOther,
+			},
+		},
+		{
+			name: "aws",
+			platform: map[string]interface{}{
+				"type": "AWS",
+				"aws":  map[string]interface{}{"region": "us-east-2"},
+			},
+			expected: map[string]string{
+				provider.LabelInfrastructureKind: "HostedCluster",
+				provider.LabelCloud:              provider.CloudAmazon,
+				provider.LabelRegion:             "us-east-2",
+			},
+		},
+		{
+			name:     "agent",
+			platform: map[string]interface{}{"type": "Agent"},
+			expected: map[string]string{
+				provider.LabelInfrastructureKind: "HostedCluster",
+				provider.LabelCloud:              provider.CloudBareMetal,
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			labels := platformLabels(newHostedCluster("True", c.platform))
+			if !reflect.DeepEqual(labels, c.expected) {
+				t.Errorf("expected labels %v, but got %v", c.expected, labels)
+			}
+		})
+	}
+}
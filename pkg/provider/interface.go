@@ -2,31 +2,130 @@ package provider
 
 import (
 	"context"
-	"fmt"
-	"strings"
 
 	"github.com/openshift/library-go/pkg/controller/factory"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/tools/clientcmd"
+	operatorv1 "open-cluster-management.io/api/operator/v1"
+)
+
+// FinalizerDetach is added on the source cluster by the importer, so the cluster is not
+// removed before it is detached from the hub.
+const FinalizerDetach = "import.open-cluster-management.io/detach"
+
+const (
+	// LabelCloud is the cloud the cluster runs on, the values follow the ones used by
+	// the cluster claims of open-cluster-management, e.g. Amazon, Azure, Google.
+	LabelCloud = "cloud"
+
+	// LabelInfrastructureKind is the kind of the infrastructure which provisions the cluster.
+	LabelInfrastructureKind = "import.open-cluster-management.io/infrastructure-kind"
+
+	// LabelRegion is the region of the cloud the cluster runs in.
+	LabelRegion = "region.open-cluster-management.io"
+)
+
+// LabelKeys is the keys of the labels owned by the providers, the labels are removed from the
+// ManagedCluster once the provider does not return them any more.
+var LabelKeys = []string{LabelCloud, LabelInfrastructureKind, LabelRegion}
+
+const (
+	CloudAmazon    = "Amazon"
+	CloudAzure     = "Azure"
+	CloudGoogle    = "Google"
+	CloudVSphere   = "VSphere"
+	CloudOpenStack = "OpenStack"
+	CloudIBM       = "IBM"
+	CloudBareMetal = "BareMetal"
+	CloudOther     = "Other"
 )
 
 type ClusterProvider interface {
 	factory.Informer
 
-	// key is formated as providerName/namespace/name
+	// Key returns the keys of the object encoded by ClusterRef.Key
 	Key(obj runtime.Object) []string
 
-	KubeConfig(clusterKey string) (clientcmd.ClientConfig, error)
+	KubeConfig(ref ClusterRef) (clientcmd.ClientConfig, error)
+
+	// Labels returns the labels describing the infrastructure of the cluster, they
+	// are set on the ManagedCluster so placements can select clusters with them.
+	Labels(ref ClusterRef) (map[string]string, error)
+
+	// Deleted returns true when the cluster is removed from the provider or is being deleted,
+	// the importer then detaches the cluster from the hub.
+	Deleted(ref ClusterRef) (bool, error)
 
 	Name() string
 
 	Start(ctx context.Context)
 }
 
-func ParseKey(key string) (string, string, error) {
-	s := strings.SplitN(key, "/", 1)
-	if len(s) < 2 {
-		return "", "", fmt.Errorf("key %s format is not correct", key)
+// Finalizer is implemented by the providers which are able to hold the deletion of the
+// cluster until the importer detaches it from the hub.
+type Finalizer interface {
+	AddFinalizer(ctx context.Context, ref ClusterRef) error
+
+	RemoveFinalizer(ctx context.Context, ref ClusterRef) error
+}
+
+// ObjectGetter is implemented by the providers whose clusters are kubernetes objects, the labels
+// and annotations of the object are used to select the ImportConfig of the cluster.
+type ObjectGetter interface {
+	Object(ref ClusterRef) (metav1.Object, error)
+}
+
+// Identifier is implemented by the providers whose clusters have an id besides the name, e.g. a
+// cluster recreated with the same name has a new id in the provider. The cluster is imported again
+// once its id changes.
+type Identifier interface {
+	ID(ref ClusterRef) (string, error)
+}
+
+// KlusterletHost is implemented by the providers whose clusters are imported with a klusterlet in
+// a hosted mode by default, e.g. the clusters whose control planes run on a management cluster.
+// The ImportConfig of the cluster still takes precedence.
+type KlusterletHost interface {
+	// HostedKlusterlet returns the hosted install mode of the klusterlet of the cluster, and the
+	// kubeconfig of the cluster to deploy the klusterlet on.
+	HostedKlusterlet(ref ClusterRef) (operatorv1.InstallMode, clientcmd.ClientConfig)
+}
+
+const (
+	// AnnotationImportPhase is the phase the import of the cluster is in, it is set on the source
+	// cluster by the providers implementing StatusReporter.
+	AnnotationImportPhase = "import.open-cluster-management.io/import-phase"
+
+	// AnnotationImportReason is the reason of the condition of the import phase.
+	AnnotationImportReason = "import.open-cluster-management.io/import-reason"
+
+	// AnnotationImportMessage is the message of the condition of the import phase.
+	AnnotationImportMessage = "import.open-cluster-management.io/import-message"
+)
+
+// ImportStatus is the status of the import of a cluster, mirrored from the conditions of the ManagedCluster
+type ImportStatus struct {
+	// Phase is the first phase of the import which is not done yet, or ManagedClusterJoined once the
+	// agent registers to the hub.
+	Phase string
+	// Reason is the reason of the condition of the phase
+	Reason string
+	// Message is the message of the condition of the phase
+	Message string
+}
+
+// StatusReporter is implemented by the providers which are able to show the import status on the
+// source cluster, so users of the provider see the progress without switching to the hub.
+type StatusReporter interface {
+	ReportStatus(ctx context.Context, ref ClusterRef, status ImportStatus) error
+}
+
+// Annotations returns the annotations of the status to set on the source cluster
+func (s ImportStatus) Annotations() map[string]string {
+	return map[string]string{
+		AnnotationImportPhase:   s.Phase,
+		AnnotationImportReason:  s.Reason,
+		AnnotationImportMessage: s.Message,
 	}
-	return s[0], s[1], nil
 }
@@ -0,0 +1,281 @@
+package kubeconfig
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/qiujian16/capi-importer/pkg/provider"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	"k8s.io/klog/v2"
+	clusterapiv1 "open-cluster-management.io/api/cluster/v1"
+)
+
+const (
+	annotationContext = "context"
+	annotationHash    = "hash"
+
+	// resyncInterval is the interval to load the kubeconfig again, so the clusters missing longer
+	// than the grace period are removed, and the kubeconfig is still loaded if it is not watched.
+	resyncInterval = 10 * time.Second
+	// gracePeriod is how long a context is missing before its cluster is removed
+	gracePeriod = 30 * time.Second
+)
+
+var contextResource = schema.GroupResource{Resource: "contexts"}
+
+var invalidNameChars = regexp.MustCompile("[^a-z0-9-]+")
+
+// ContextSelector selects the contexts of the kubeconfig to import, all the contexts are selected
+// if neither the names nor the regex is set.
+type ContextSelector struct {
+	// Names is the allowlist of the names of the contexts
+	Names []string
+	// Regex matches the names of the contexts
+	Regex *regexp.Regexp
+}
+
+// Matches returns true if the context is in the allowlist or matches the regex
+func (s ContextSelector) Matches(name string) bool {
+	if len(s.Names) == 0 && s.Regex == nil {
+		return true
+	}
+	if sets.New[string](s.Names...).Has(name) {
+		return true
+	}
+	return s.Regex != nil && s.Regex.MatchString(name)
+}
+
+// KubeConfigProvider imports a cluster for each selected context of a kubeconfig file. The name of
+// the cluster is the name of the context, lower cased with the invalid characters replaced by "-".
+// The file is reloaded when it changes.
+type KubeConfigProvider struct {
+	path     string
+	selector ContextSelector
+	clusters *provider.ClusterSet
+}
+
+func NewKubeConfigProvider(path string, selector ContextSelector) *KubeConfigProvider {
+	return &KubeConfigProvider{
+		path:     path,
+		selector: selector,
+		clusters: provider.NewClusterSet(clusterChanged, gracePeriod),
+	}
+}
+
+func (k *KubeConfigProvider) AddEventHandler(handler cache.ResourceEventHandler) (cache.ResourceEventHandlerRegistration, error) {
+	k.clusters.AddEventHandler(handler)
+	return k, nil
+}
+
+// HasSynced returns true once the contexts of the kubeconfig are loaded
+func (k *KubeConfigProvider) HasSynced() bool {
+	return k.clusters.HasSynced()
+}
+
+func (k *KubeConfigProvider) Key(obj runtime.Object) []string {
+	name, err := provider.ClusterKey(obj)
+	if err != nil {
+		return []string{}
+	}
+	ref := provider.ClusterRef{
+		Provider: k.Name(),
+		Name:     name,
+	}
+	return []string{ref.Key()}
+}
+
+// KubeConfig returns the client config scoped to the context of the cluster
+func (k *KubeConfigProvider) KubeConfig(ref provider.ClusterRef) (clientcmd.ClientConfig, error) {
+	cluster, exist, err := k.clusters.Get(ref.Name)
+	if err != nil {
+		return nil, err
+	}
+	if !exist {
+		return nil, errors.NewNotFound(contextResource, ref.Name)
+	}
+
+	contextName := cluster.Annotations[annotationContext]
+	config, err := clientcmd.LoadFromFile(k.path)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := config.Contexts[contextName]; !ok {
+		return nil, errors.NewNotFound(contextResource, contextName)
+	}
+	// the certificate files referred by relative paths are next to the kubeconfig file
+	if err := clientcmd.ResolveLocalPaths(config); err != nil {
+		return nil, err
+	}
+	return clientcmd.NewNonInteractiveClientConfig(*config, contextName, &clientcmd.ConfigOverrides{}, nil), nil
+}
+
+func (k *KubeConfigProvider) Labels(ref provider.ClusterRef) (map[string]string, error) {
+	_, exist, err := k.clusters.Get(ref.Name)
+	if err != nil {
+		return nil, err
+	}
+	if !exist {
+		return nil, errors.NewNotFound(contextResource, ref.Name)
+	}
+	return map[string]string{
+		provider.LabelInfrastructureKind: "KubeConfigContext",
+		provider.LabelCloud:              provider.CloudOther,
+	}, nil
+}
+
+func (k *KubeConfigProvider) Deleted(ref provider.ClusterRef) (bool, error) {
+	_, exist, err := k.clusters.Get(ref.Name)
+	if err != nil {
+		return false, err
+	}
+	return !exist, nil
+}
+
+func (k *KubeConfigProvider) Name() string {
+	return "kubeconfig"
+}
+
+// Start loads the contexts of the kubeconfig and reloads them when the file changes. The directory
+// of the file is watched, since the file is usually replaced instead of written in place. The file is
+// polled if it can not be watched.
+func (k *KubeConfigProvider) Start(ctx context.Context) {
+	var events <-chan fsnotify.Event
+	var watchErrors <-chan error
+	watcher, err := fsnotify.NewWatcher()
+	if err == nil {
+		defer watcher.Close()
+		err = watcher.Add(filepath.Dir(k.path))
+	}
+	if err != nil {
+		klog.Errorf("failed to watch kubeconfig %s, poll it every %s: %v", k.path, resyncInterval, err)
+	} else {
+		events, watchErrors = watcher.Events, watcher.Errors
+	}
+
+	ticker := time.NewTicker(resyncInterval)
+	defer ticker.Stop()
+
+	k.sync()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			k.sync()
+		case event, ok := <-events:
+			if !ok {
+				events = nil
+				continue
+			}
+			if filepath.Clean(event.Name) != filepath.Clean(k.path) {
+				continue
+			}
+			klog.V(4).Infof("kubeconfig %s is changed: %s", event.Name, event.Op)
+			k.sync()
+		case err, ok := <-watchErrors:
+			if !ok {
+				watchErrors = nil
+				continue
+			}
+			klog.Errorf("failed to watch kubeconfig %s: %v", k.path, err)
+		}
+	}
+}
+
+// sync loads the selected contexts of the kubeconfig and compares them with the clusters in the set.
+// The clusters are kept if the kubeconfig fails to load, and the clusters missing from a kubeconfig
+// without contexts are only removed after the grace period, since the file may be truncated while it
+// is being written. The set is synced without any cluster if the kubeconfig fails to load when the
+// importer starts, so the other providers are not blocked.
+func (k *KubeConfigProvider) sync() {
+	config, err := clientcmd.LoadFromFile(k.path)
+	if err != nil {
+		klog.Errorf("failed to load kubeconfig %s: %v", k.path, err)
+		k.clusters.MarkSynced()
+		return
+	}
+	if len(config.Contexts) == 0 {
+		klog.Warningf("kubeconfig %s has no contexts", k.path)
+	}
+	k.clusters.Sync(k.listClusters(config))
+}
+
+// listClusters returns the clusters of the selected contexts in the order of the names of the
+// contexts, a context is skipped if its cluster name is taken by another context.
+func (k *KubeConfigProvider) listClusters(config *clientcmdapi.Config) map[string]*clusterapiv1.ManagedCluster {
+	clusters := map[string]*clusterapiv1.ManagedCluster{}
+	for _, contextName := range sets.List(sets.KeySet(config.Contexts)) {
+		if !k.selector.Matches(contextName) {
+			continue
+		}
+
+		name := clusterName(contextName)
+		if errs := validation.IsDNS1123Label(name); len(errs) > 0 {
+			klog.Warningf("skip context %s, %s is not a valid cluster name: %s", contextName, name, strings.Join(errs, ", "))
+			continue
+		}
+		if existing, ok := clusters[name]; ok {
+			klog.Warningf("skip context %s, cluster %s is loaded from context %s",
+				contextName, name, existing.Annotations[annotationContext])
+			continue
+		}
+
+		hash, err := contextHash(config, contextName)
+		if err != nil {
+			klog.Warningf("skip context %s: %v", contextName, err)
+			continue
+		}
+		clusters[name] = &clusterapiv1.ManagedCluster{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: name,
+				Annotations: map[string]string{
+					annotationContext: contextName,
+					annotationHash:    hash,
+				},
+			},
+		}
+	}
+	return clusters
+}
+
+// contextHash returns the hash of the context with its cluster and user, so a change of the other
+// contexts in the file does not update the cluster.
+func contextHash(config *clientcmdapi.Config, contextName string) (string, error) {
+	minified := config.DeepCopy()
+	minified.CurrentContext = contextName
+	if err := clientcmdapi.MinifyConfig(minified); err != nil {
+		return "", err
+	}
+	data, err := clientcmd.Write(*minified)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:]), nil
+}
+
+// clusterName converts the name of the context to a cluster name, e.g. admin-cluster1 for admin@Cluster1.
+func clusterName(contextName string) string {
+	name := invalidNameChars.ReplaceAllString(strings.ToLower(contextName), "-")
+	return strings.Trim(name, "-")
+}
+
+// clusterChanged returns true if the context of the cluster or its content is changed
+func clusterChanged(old, new *clusterapiv1.ManagedCluster) bool {
+	return old.Annotations[annotationContext] != new.Annotations[annotationContext] ||
+		old.Annotations[annotationHash] != new.Annotations[annotationHash]
+}
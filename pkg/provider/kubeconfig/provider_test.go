@@ -0,0 +1,238 @@
+package kubeconfig
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/qiujian16/capi-importer/pkg/provider"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+func newConfig(server string, contexts ...string) *clientcmdapi.Config {
+	config := clientcmdapi.NewConfig()
+	for _, name := range contexts {
+		config.Clusters[name] = &clientcmdapi.Cluster{Server: server}
+		config.AuthInfos[name] = &clientcmdapi.AuthInfo{Token: "token"}
+		config.Contexts[name] = &clientcmdapi.Context{Cluster: name, AuthInfo: name}
+	}
+	return config
+}
+
+func TestListClusters(t *testing.T) {
+	cases := []struct {
+		name     string
+		selector ContextSelector
+		contexts []string
+		expected map[string]string
+	}{
+		{
+			name:     "all contexts",
+			contexts: []string{"kind-cluster1", "admin@Cluster2"},
+			expected: map[string]string{"kind-cluster1": "kind-cluster1", "admin-cluster2": "admin@Cluster2"},
+		},
+		{
+			name:     "allowlist and regex",
+			selector: ContextSelector{Names: []string{"prod"}, Regex: regexp.MustCompile("^kind-")},
+			contexts: []string{"kind-cluster1", "prod", "dev"},
+			expected: map[string]string{"kind-cluster1": "kind-cluster1", "prod": "prod"},
+		},
+		{
+			name:     "invalid and duplicated names",
+			contexts: []string{"@@@", "cluster1", "Cluster1"},
+			expected: map[string]string{"cluster1": "Cluster1"},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			p := NewKubeConfigProvider("", c.selector)
+			clusters := p.listClusters(newConfig("https://api.example.com", c.contexts...))
+			contexts := map[string]string{}
+			for name, cluster := range clusters {
+				contexts[name] = cluster.Annotations[annotationContext]
+			}
+			if !reflect.DeepEqual(contexts, c.expected) {
+				t.Errorf("expected clusters %v, but got %v", c.expected, contexts)
+			}
+		})
+	}
+}
+
+func TestContextHash(t *testing.T) {
+	config := newConfig("https://api1.example.com", "cluster1", "cluster2")
+	hash1, err := contextHash(config, "cluster1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the other contexts do not change the hash
+	config.Clusters["cluster2"].Server = "https://api3.example.com"
+	hash2, err := contextHash(config, "cluster1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash1 != hash2 {
+		t.Errorf("expected the hash not changed")
+	}
+
+	config.Clusters["cluster1"].Server = "https://api2.example.com"
+	hash3, err := contextHash(config, "cluster1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash1 == hash3 {
+		t.Errorf("expected the hash changed")
+	}
+}
+
+func TestSync(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "kubeconfig")
+	var events []string
+	p := NewKubeConfigProvider(path, ContextSelector{})
+	// the removed contexts are tested with a short grace period
+	gracePeriod := 100 * time.Millisecond
+	p.clusters = provider.NewClusterSet(clusterChanged, gracePeriod)
+	_, err := p.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			name, _ := provider.ClusterKey(obj)
+			events = append(events, "add "+name)
+		},
+		UpdateFunc: func(_, obj interface{}) {
+			name, _ := provider.ClusterKey(obj)
+			events = append(events, "update "+name)
+		},
+		DeleteFunc: func(obj interface{}) {
+			name, _ := provider.ClusterKey(obj)
+			events = append(events, "delete "+name)
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		name           string
+		write          func() error
+		wait           time.Duration
+		expectedSynced bool
+		expectedEvents []string
+	}{
+		{
+			name: "kubeconfig does not exist",
+			write: func() error {
+				return nil
+			},
+			expectedSynced: true,
+		},
+		{
+			name: "contexts are loaded",
+			write: func() error {
+				return clientcmd.WriteToFile(*newConfig("https://api.example.com", "cluster1", "cluster2"), path)
+			},
+			expectedSynced: true,
+			expectedEvents: []string{"add cluster1", "add cluster2"},
+		},
+		{
+			name: "kubeconfig is truncated",
+			write: func() error {
+				return os.WriteFile(path, []byte{}, 0600)
+			},
+			expectedSynced: true,
+		},
+		{
+			name: "kubeconfig is partially written",
+			write: func() error {
+				return os.WriteFile(path, []byte("apiVersion: v1\nclusters:\n- cluster:\n    server: ["), 0600)
+			},
+			expectedSynced: true,
+		},
+		{
+			name: "context is removed",
+			write: func() error {
+				return clientcmd.WriteToFile(*newConfig("https://api.example.com", "cluster1"), path)
+			},
+			wait:           gracePeriod,
+			expectedSynced: true,
+			expectedEvents: []string{"delete cluster2"},
+		},
+		{
+			name: "contexts are emptied",
+			write: func() error {
+				return clientcmd.WriteToFile(*newConfig("https://api.example.com"), path)
+			},
+			expectedSynced: true,
+		},
+		{
+			name: "contexts are emptied longer than the grace period",
+			write: func() error {
+				return nil
+			},
+			wait:           gracePeriod,
+			expectedSynced: true,
+			expectedEvents: []string{"delete cluster1"},
+		},
+	}
+
+	for _, step := range steps {
+		if err := step.write(); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(step.wait)
+		p.sync()
+		if p.HasSynced() != step.expectedSynced {
+			t.Errorf("%s: expected synced %t", step.name, step.expectedSynced)
+		}
+		sort.Strings(events)
+		if !reflect.DeepEqual(events, step.expectedEvents) {
+			t.Errorf("%s: expected events %v, but got %v", step.name, step.expectedEvents, events)
+		}
+		events = nil
+	}
+}
+
+func TestKubeConfig(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "kubeconfig")
+	if err := os.Mkdir(filepath.Join(dir, "certs"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "certs", "ca.crt"), []byte("ca"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	config := newConfig("https://api.example.com", "cluster1")
+	config.Clusters["cluster1"].CertificateAuthority = "certs/ca.crt"
+	if err := clientcmd.WriteToFile(*config, path); err != nil {
+		t.Fatal(err)
+	}
+
+	p := NewKubeConfigProvider(path, ContextSelector{})
+	if _, err := p.AddEventHandler(cache.ResourceEventHandlerFuncs{}); err != nil {
+		t.Fatal(err)
+	}
+	p.sync()
+
+	kubeConfig, err := p.KubeConfig(provider.ClusterRef{Provider: "kubeconfig", Name: "cluster1"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	restConfig, err := kubeConfig.ClientConfig()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// the relative path is resolved against the directory of the kubeconfig
+	if expected := filepath.Join(dir, "certs", "ca.crt"); restConfig.CAFile != expected {
+		t.Errorf("expected CA file %s, but got %s", expected, restConfig.CAFile)
+	}
+
+	if _, err := p.KubeConfig(provider.ClusterRef{Provider: "kubeconfig", Name: "cluster2"}); !errors.IsNotFound(err) {
+		t.Errorf("expected not found error, but got %v", err)
+	}
+}
@@ -0,0 +1,184 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/openshift/library-go/pkg/operator/resource/resourcemerge"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+)
+
+// ObjectProvider is the base of the providers whose source clusters are kubernetes objects, like the
+// CAPI Clusters or the hive ClusterDeployments. It watches the objects of the resource, and owns the
+// finalizer and the import status on the objects. The providers embedding it only build the labels
+// and the kubeconfig of the clusters.
+type ObjectProvider struct {
+	name          string
+	gvr           schema.GroupVersionResource
+	informer      dynamicinformer.DynamicSharedInformerFactory
+	lister        cache.GenericLister
+	dynamicClient dynamic.Interface
+	// ready returns true once the cluster of the object is ready to be imported
+	ready func(obj *unstructured.Unstructured) bool
+}
+
+func NewObjectProvider(name string, gvr schema.GroupVersionResource, dynamicClient dynamic.Interface,
+	ready func(obj *unstructured.Unstructured) bool) *ObjectProvider {
+	dynamicInformer := dynamicinformer.NewDynamicSharedInformerFactory(dynamicClient, 30*time.Minute)
+	return &ObjectProvider{
+		name:          name,
+		gvr:           gvr,
+		informer:      dynamicInformer,
+		lister:        dynamicInformer.ForResource(gvr).Lister(),
+		dynamicClient: dynamicClient,
+		ready:         ready,
+	}
+}
+
+func (o *ObjectProvider) AddEventHandler(handler cache.ResourceEventHandler) (cache.ResourceEventHandlerRegistration, error) {
+	return o.informer.ForResource(o.gvr).Informer().AddEventHandler(handler)
+}
+
+func (o *ObjectProvider) HasSynced() bool {
+	return o.informer.ForResource(o.gvr).Informer().HasSynced()
+}
+
+// Key only returns the key of the object when its cluster is ready to be imported or is being
+// deleted, so the controller is not triggered by clusters which are still provisioning.
+func (o *ObjectProvider) Key(obj runtime.Object) []string {
+	cluster, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return []string{}
+	}
+	if cluster.GetDeletionTimestamp() == nil && !o.ready(cluster) {
+		return []string{}
+	}
+	ref := ClusterRef{
+		Provider:  o.name,
+		Namespace: cluster.GetNamespace(),
+		Name:      cluster.GetName(),
+	}
+	return []string{ref.Key()}
+}
+
+func (o *ObjectProvider) Name() string {
+	return o.name
+}
+
+func (o *ObjectProvider) Start(ctx context.Context) {
+	o.informer.Start(ctx.Done())
+}
+
+func (o *ObjectProvider) Deleted(ref ClusterRef) (bool, error) {
+	cluster, err := o.Get(ref)
+	if apierrors.IsNotFound(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return cluster.GetDeletionTimestamp() != nil, nil
+}
+
+func (o *ObjectProvider) Object(ref ClusterRef) (metav1.Object, error) {
+	return o.Get(ref)
+}
+
+// AddFinalizer adds FinalizerDetach to the object of the cluster, the finalizer is not added once
+// the object is being deleted.
+func (o *ObjectProvider) AddFinalizer(ctx context.Context, ref ClusterRef) error {
+	cluster, err := o.Get(ref)
+	if err != nil {
+		return err
+	}
+	if cluster.GetDeletionTimestamp() != nil {
+		return nil
+	}
+
+	finalizers := cluster.GetFinalizers()
+	for _, f := range finalizers {
+		if f == FinalizerDetach {
+			return nil
+		}
+	}
+
+	cluster = cluster.DeepCopy()
+	cluster.SetFinalizers(append(finalizers, FinalizerDetach))
+	_, err = o.dynamicClient.Resource(o.gvr).Namespace(ref.Namespace).Update(ctx, cluster, metav1.UpdateOptions{})
+	return err
+}
+
+// RemoveFinalizer removes FinalizerDetach from the object of the cluster, it is done if the object
+// is removed already.
+func (o *ObjectProvider) RemoveFinalizer(ctx context.Context, ref ClusterRef) error {
+	cluster, err := o.Get(ref)
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var finalizers []string
+	for _, f := range cluster.GetFinalizers() {
+		if f != FinalizerDetach {
+			finalizers = append(finalizers, f)
+		}
+	}
+	if len(finalizers) == len(cluster.GetFinalizers()) {
+		return nil
+	}
+
+	cluster = cluster.DeepCopy()
+	cluster.SetFinalizers(finalizers)
+	_, err = o.dynamicClient.Resource(o.gvr).Namespace(ref.Namespace).Update(ctx, cluster, metav1.UpdateOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// ReportStatus mirrors the import status to the annotations of the object of the cluster, the status
+// of the object is owned by the controllers of its provider.
+func (o *ObjectProvider) ReportStatus(ctx context.Context, ref ClusterRef, status ImportStatus) error {
+	cluster, err := o.Get(ref)
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	modified := false
+	cluster = cluster.DeepCopy()
+	annotations := cluster.GetAnnotations()
+	resourcemerge.MergeMap(&modified, &annotations, status.Annotations())
+	if !modified {
+		return nil
+	}
+
+	cluster.SetAnnotations(annotations)
+	_, err = o.dynamicClient.Resource(o.gvr).Namespace(ref.Namespace).Update(ctx, cluster, metav1.UpdateOptions{})
+	return err
+}
+
+// Get returns the object of the cluster from the informer cache
+func (o *ObjectProvider) Get(ref ClusterRef) (*unstructured.Unstructured, error) {
+	obj, err := o.lister.ByNamespace(ref.Namespace).Get(ref.Name)
+	if err != nil {
+		return nil, err
+	}
+	cluster, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return nil, fmt.Errorf("%s %s is not an unstructured object", o.gvr.Resource, ref)
+	}
+	return cluster, nil
+}
@@ -0,0 +1,262 @@
+package provider
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+var testGVR = schema.GroupVersionResource{Group: "test.io", Version: "v1", Resource: "clusters"}
+
+func newObject(ready bool) *unstructured.Unstructured {
+	return &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": "test.io/v1",
+			"kind":       "Cluster",
+			"metadata": map[string]interface{}{
+				"name":      "cluster1",
+				"namespace": "ns1",
+			},
+			"status": map[string]interface{}{
+				"ready": ready,
+			},
+		},
+	}
+}
+
+func deletingObject(obj *unstructured.Unstructured) *unstructured.Unstructured {
+	now := metav1.Now()
+	obj.SetDeletionTimestamp(&now)
+	obj.SetFinalizers([]string{FinalizerDetach})
+	return obj
+}
+
+func withFinalizers(obj *unstructured.Unstructured, finalizers ...string) *unstructured.Unstructured {
+	obj.SetFinalizers(finalizers)
+	return obj
+}
+
+// newTestObjectProvider returns a provider of the objects, which are added to both the informer
+// cache and the fake client.
+func newTestObjectProvider(t *testing.T, objs ...*unstructured.Unstructured) (*ObjectProvider, *dynamicfake.FakeDynamicClient) {
+	var runtimeObjs []runtime.Object
+	for _, obj := range objs {
+		runtimeObjs = append(runtimeObjs, obj)
+	}
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{testGVR: "ClusterList"}, runtimeObjs...)
+
+	p := NewObjectProvider("test", testGVR, client, func(obj *unstructured.Unstructured) bool {
+		ready, _, _ := unstructured.NestedBool(obj.Object, "status", "ready")
+		return ready
+	})
+	for _, obj := range objs {
+		if err := p.informer.ForResource(testGVR).Informer().GetIndexer().Add(obj); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return p, client
+}
+
+func updatedObject(t *testing.T, client *dynamicfake.FakeDynamicClient) *unstructured.Unstructured {
+	var updated *unstructured.Unstructured
+	for _, action := range client.Actions() {
+		if update, ok := action.(clienttesting.UpdateAction); ok {
+			updated = update.GetObject().(*unstructured.Unstructured)
+		}
+	}
+	if updated == nil {
+		t.Fatalf("expected the object is updated, but got actions %v", client.Actions())
+	}
+	return updated
+}
+
+func TestObjectProviderKey(t *testing.T) {
+	cases := []struct {
+		name     string
+		obj      runtime.Object
+		expected []string
+	}{
+		{
+			name:     "not an unstructured object",
+			obj:      &metav1.Status{},
+			expected: []string{},
+		},
+		{
+			name:     "cluster not ready",
+			obj:      newObject(false),
+			expected: []string{},
+		},
+		{
+			name:     "deleting cluster",
+			obj:      deletingObject(newObject(false)),
+			expected: []string{"test/ns1/cluster1"},
+		},
+		{
+			name:     "ready cluster",
+			obj:      newObject(true),
+			expected: []string{"test/ns1/cluster1"},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			p, _ := newTestObjectProvider(t)
+			keys := p.Key(c.obj)
+			if !reflect.DeepEqual(keys, c.expected) {
+				t.Errorf("expected keys %v, but got %v", c.expected, keys)
+			}
+		})
+	}
+}
+
+func TestObjectProviderDeleted(t *testing.T) {
+	cases := []struct {
+		name     string
+		objs     []*unstructured.Unstructured
+		expected bool
+	}{
+		{
+			name:     "cluster is removed",
+			expected: true,
+		},
+		{
+			name:     "cluster is being deleted",
+			objs:     []*unstructured.Unstructured{deletingObject(newObject(true))},
+			expected: true,
+		},
+		{
+			name: "cluster exists",
+			objs: []*unstructured.Unstructured{newObject(true)},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			p, _ := newTestObjectProvider(t, c.objs...)
+			deleted, err := p.Deleted(ClusterRef{Provider: "test", Namespace: "ns1", Name: "cluster1"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if deleted != c.expected {
+				t.Errorf("expected deleted %v, but got %v", c.expected, deleted)
+			}
+		})
+	}
+}
+
+func TestObjectProviderFinalizer(t *testing.T) {
+	ref := ClusterRef{Provider: "test", Namespace: "ns1", Name: "cluster1"}
+
+	cases := []struct {
+		name               string
+		objs               []*unstructured.Unstructured
+		remove             bool
+		expectedUpdated    bool
+		expectedFinalizers []string
+	}{
+		{
+			name:               "add finalizer",
+			objs:               []*unstructured.Unstructured{withFinalizers(newObject(true), "foo")},
+			expectedUpdated:    true,
+			expectedFinalizers: []string{"foo", FinalizerDetach},
+		},
+		{
+			name: "finalizer exists",
+			objs: []*unstructured.Unstructured{withFinalizers(newObject(true), FinalizerDetach)},
+		},
+		{
+			name: "cluster is being deleted",
+			objs: []*unstructured.Unstructured{withFinalizers(deletingObject(newObject(true)))},
+		},
+		{
+			name:               "remove finalizer",
+			objs:               []*unstructured.Unstructured{withFinalizers(deletingObject(newObject(true)), FinalizerDetach, "foo")},
+			remove:             true,
+			expectedUpdated:    true,
+			expectedFinalizers: []string{"foo"},
+		},
+		{
+			name:   "remove finalizer of a removed cluster",
+			remove: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			p, client := newTestObjectProvider(t, c.objs...)
+			var err error
+			if c.remove {
+				err = p.RemoveFinalizer(context.TODO(), ref)
+			} else {
+				err = p.AddFinalizer(context.TODO(), ref)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !c.expectedUpdated {
+				if len(client.Actions()) > 0 {
+					t.Errorf("expected no actions, but got %v", client.Actions())
+				}
+				return
+			}
+			if finalizers := updatedObject(t, client).GetFinalizers(); !reflect.DeepEqual(finalizers, c.expectedFinalizers) {
+				t.Errorf("expected finalizers %v, but got %v", c.expectedFinalizers, finalizers)
+			}
+		})
+	}
+}
+
+func TestObjectProviderReportStatus(t *testing.T) {
+	ref := ClusterRef{Provider: "test", Namespace: "ns1", Name: "cluster1"}
+	status := ImportStatus{Phase: "KlusterletApplied", Reason: "Applied", Message: "klusterlet is applied"}
+
+	reported := newObject(true)
+	reported.SetAnnotations(status.Annotations())
+
+	cases := []struct {
+		name            string
+		objs            []*unstructured.Unstructured
+		expectedUpdated bool
+	}{
+		{
+			name: "cluster is removed",
+		},
+		{
+			name:            "report status",
+			objs:            []*unstructured.Unstructured{newObject(true)},
+			expectedUpdated: true,
+		},
+		{
+			name: "status is reported",
+			objs: []*unstructured.Unstructured{reported},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			p, client := newTestObjectProvider(t, c.objs...)
+			if err := p.ReportStatus(context.TODO(), ref, status); err != nil {
+				t.Fatal(err)
+			}
+
+			if !c.expectedUpdated {
+				if len(client.Actions()) > 0 {
+					t.Errorf("expected no actions, but got %v", client.Actions())
+				}
+				return
+			}
+			if annotations := updatedObject(t, client).GetAnnotations(); !reflect.DeepEqual(annotations, status.Annotations()) {
+				t.Errorf("expected annotations %v, but got %v", status.Annotations(), annotations)
+			}
+		})
+	}
+}
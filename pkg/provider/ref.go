@@ -0,0 +1,75 @@
+package provider
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// AnnotationClusterRef is set on the ManagedCluster with the key of the cluster in
+// its provider, so events of the ManagedCluster can be mapped back to the provider.
+const AnnotationClusterRef = "import.open-cluster-management.io/cluster-ref"
+
+// AnnotationClusterID is set on the ManagedCluster with the id of the cluster in its provider,
+// for the providers implementing Identifier.
+const AnnotationClusterID = "import.open-cluster-management.io/cluster-id"
+
+// ClusterRef refers to a cluster in a provider. The name of the ManagedCluster on the
+// hub is the name of the ClusterRef.
+type ClusterRef struct {
+	Provider  string
+	Namespace string
+	Name      string
+}
+
+// Key encodes the ref as providerName/namespace/name, or providerName/name if the
+// cluster is not namespaced.
+func (r ClusterRef) Key() string {
+	if len(r.Namespace) == 0 {
+		return fmt.Sprintf("%s/%s", r.Provider, r.Name)
+	}
+	return fmt.Sprintf("%s/%s/%s", r.Provider, r.Namespace, r.Name)
+}
+
+func (r ClusterRef) String() string {
+	return r.Key()
+}
+
+// ParseKey decodes the key built by ClusterRef.Key
+func ParseKey(key string) (ClusterRef, error) {
+	s := strings.Split(key, "/")
+	for _, part := range s {
+		if len(part) == 0 {
+			return ClusterRef{}, fmt.Errorf("key %s format is not correct", key)
+		}
+	}
+
+	switch len(s) {
+	case 2:
+		return ClusterRef{Provider: s[0], Name: s[1]}, nil
+	case 3:
+		return ClusterRef{Provider: s[0], Namespace: s[1], Name: s[2]}, nil
+	default:
+		return ClusterRef{}, fmt.Errorf("key %s format is not correct", key)
+	}
+}
+
+// RefFromAnnotation returns the ClusterRef recorded in the AnnotationClusterRef
+// annotation of the object.
+func RefFromAnnotation(obj runtime.Object) (ClusterRef, bool) {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return ClusterRef{}, false
+	}
+	key, ok := accessor.GetAnnotations()[AnnotationClusterRef]
+	if !ok {
+		return ClusterRef{}, false
+	}
+	ref, err := ParseKey(key)
+	if err != nil {
+		return ClusterRef{}, false
+	}
+	return ref, true
+}
@@ -0,0 +1,137 @@
+package provider
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clusterv1 "open-cluster-management.io/api/cluster/v1"
+)
+
+func TestClusterRefKey(t *testing.T) {
+	cases := []struct {
+		name     string
+		ref      ClusterRef
+		expected string
+	}{
+		{
+			name:     "namespaced cluster",
+			ref:      ClusterRef{Provider: "capi", Namespace: "ns1", Name: "cluster1"},
+			expected: "capi/ns1/cluster1",
+		},
+		{
+			name:     "cluster scoped cluster",
+			ref:      ClusterRef{Provider: "clusterservice", Name: "cluster1"},
+			expected: "clusterservice/cluster1",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if key := c.ref.Key(); key != c.expected {
+				t.Errorf("expected key %q, but got %q", c.expected, key)
+			}
+		})
+	}
+}
+
+func TestParseKey(t *testing.T) {
+	cases := []struct {
+		name        string
+		key         string
+		expected    ClusterRef
+		expectedErr bool
+	}{
+		{
+			name:     "namespaced cluster",
+			key:      "capi/ns1/cluster1",
+			expected: ClusterRef{Provider: "capi", Namespace: "ns1", Name: "cluster1"},
+		},
+		{
+			name:     "cluster scoped cluster",
+			key:      "clusterservice/cluster1",
+			expected: ClusterRef{Provider: "clusterservice", Name: "cluster1"},
+		},
+		{
+			name:        "no provider",
+			key:         "cluster1",
+			expectedErr: true,
+		},
+		{
+			name:        "empty key",
+			key:         "",
+			expectedErr: true,
+		},
+		{
+			name:        "empty part",
+			key:         "capi//cluster1",
+			expectedErr: true,
+		},
+		{
+			name:        "too many parts",
+			key:         "capi/ns1/cluster1/extra",
+			expectedErr: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ref, err := ParseKey(c.key)
+			if c.expectedErr {
+				if err == nil {
+					t.Errorf("expected error, but got ref %v", ref)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if ref != c.expected {
+				t.Errorf("expected ref %v, but got %v", c.expected, ref)
+			}
+			if ref.Key() != c.key {
+				t.Errorf("expected key %q to round trip, but got %q", c.key, ref.Key())
+			}
+		})
+	}
+}
+
+func TestRefFromAnnotation(t *testing.T) {
+	cases := []struct {
+		name        string
+		annotations map[string]string
+		expected    ClusterRef
+		expectedOK  bool
+	}{
+		{
+			name: "no annotation",
+		},
+		{
+			name:        "invalid annotation",
+			annotations: map[string]string{AnnotationClusterRef: "cluster1"},
+		},
+		{
+			name:        "valid annotation",
+			annotations: map[string]string{AnnotationClusterRef: "capi/ns1/cluster1"},
+			expected:    ClusterRef{Provider: "capi", Namespace: "ns1", Name: "cluster1"},
+			expectedOK:  true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			cluster := &clusterv1.ManagedCluster{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "cluster1",
+					Annotations: c.annotations,
+				},
+			}
+			ref, ok := RefFromAnnotation(cluster)
+			if ok != c.expectedOK {
+				t.Fatalf("expected ok %t, but got %t", c.expectedOK, ok)
+			}
+			if ref != c.expected {
+				t.Errorf("expected ref %v, but got %v", c.expected, ref)
+			}
+		})
+	}
+}
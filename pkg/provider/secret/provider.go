@@ -0,0 +1,299 @@
+package secret
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/openshift/library-go/pkg/operator/resource/resourcemerge"
+	"github.com/pkg/errors"
+	"github.com/qiujian16/capi-importer/pkg/provider"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// LabelKubeConfig selects the secrets on the hub holding the kubeconfigs of the clusters to import
+	LabelKubeConfig = "import.open-cluster-management.io/kubeconfig"
+
+	// AnnotationClusterName is the name of the ManagedCluster of the kubeconfig secret, it defaults
+	// to the name of the secret.
+	AnnotationClusterName = "import.open-cluster-management.io/cluster-name"
+
+	// kubeConfigKey is the key of the kubeconfig in the secret
+	kubeConfigKey = "kubeconfig"
+
+	byClusterName = "by-cluster-name"
+)
+
+// SecretProvider imports the clusters whose kubeconfigs are kept in the labeled secrets on the hub,
+// e.g. clusters not managed by any API, or sealed secrets committed to a GitOps repository.
+type SecretProvider struct {
+	informer   informers.SharedInformerFactory
+	indexer    cache.Indexer
+	kubeClient kubernetes.Interface
+}
+
+func NewSecretProvider(kubeClient kubernetes.Interface) *SecretProvider {
+	informer := informers.NewSharedInformerFactoryWithOptions(kubeClient, 30*time.Minute,
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.LabelSelector = LabelKubeConfig + "=true"
+		}))
+	secretInformer := informer.Core().V1().Secrets().Informer()
+	// the secrets are looked up by the name of the cluster, which is not the name of the secret
+	// if it is annotated.
+	utilruntime.Must(secretInformer.AddIndexers(cache.Indexers{byClusterName: indexByClusterName}))
+
+	return &SecretProvider{
+		informer:   informer,
+		indexer:    secretInformer.GetIndexer(),
+		kubeClient: kubeClient,
+	}
+}
+
+func (s *SecretProvider) AddEventHandler(handler cache.ResourceEventHandler) (cache.ResourceEventHandlerRegistration, error) {
+	return s.informer.Core().V1().Secrets().Informer().AddEventHandler(handler)
+}
+
+func (s *SecretProvider) HasSynced() bool {
+	return s.informer.Core().V1().Secrets().Informer().HasSynced()
+}
+
+func (s *SecretProvider) Key(obj runtime.Object) []string {
+	secret, ok := obj.(*corev1.Secret)
+	if !ok {
+		return []string{}
+	}
+	name, err := clusterName(secret)
+	if err != nil {
+		klog.Warningf("skip secret %s/%s: %v", secret.Namespace, secret.Name, err)
+		return []string{}
+	}
+	ref := provider.ClusterRef{
+		Provider:  s.Name(),
+		Namespace: secret.Namespace,
+		Name:      name,
+	}
+	return []string{ref.Key()}
+}
+
+func (s *SecretProvider) Name() string {
+	return "secret"
+}
+
+func (s *SecretProvider) Start(ctx context.Context) {
+	s.informer.Start(ctx.Done())
+}
+
+// Labels returns the cloud and region labels set on the secret, so the clusters are able to be
+// selected by placements as the clusters of the other providers.
+func (s *SecretProvider) Labels(ref provider.ClusterRef) (map[string]string, error) {
+	secret, err := s.getSecret(ref)
+	if err != nil {
+		return nil, err
+	}
+	return secretLabels(secret), nil
+}
+
+func (s *SecretProvider) KubeConfig(ref provider.ClusterRef) (clientcmd.ClientConfig, error) {
+	secret, err := s.getSecret(ref)
+	if err != nil {
+		return nil, err
+	}
+	data, ok := secret.Data[kubeConfigKey]
+	if !ok {
+		return nil, errors.Errorf("missing key %q in secret %s/%s", kubeConfigKey, secret.Namespace, secret.Name)
+	}
+	return clientcmd.NewClientConfigFromBytes(data)
+}
+
+func (s *SecretProvider) Deleted(ref provider.ClusterRef) (bool, error) {
+	secret, err := s.getSecret(ref)
+	if apierrors.IsNotFound(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return secret.DeletionTimestamp != nil, nil
+}
+
+func (s *SecretProvider) Object(ref provider.ClusterRef) (metav1.Object, error) {
+	return s.getSecret(ref)
+}
+
+func (s *SecretProvider) AddFinalizer(ctx context.Context, ref provider.ClusterRef) error {
+	secret, err := s.getSecret(ref)
+	if err != nil {
+		return err
+	}
+	if secret.DeletionTimestamp != nil {
+		return nil
+	}
+	for _, f := range secret.Finalizers {
+		if f == provider.FinalizerDetach {
+			return nil
+		}
+	}
+
+	secret = secret.DeepCopy()
+	secret.Finalizers = append(secret.Finalizers, provider.FinalizerDetach)
+	_, err = s.kubeClient.CoreV1().Secrets(secret.Namespace).Update(ctx, secret, metav1.UpdateOptions{})
+	return err
+}
+
+// RemoveFinalizer removes FinalizerDetach from the secret of the cluster. The secret is looked up
+// from the apiserver if it is not in the informer cache, since the cache only has the labeled secrets
+// and the secret whose label is removed still has the finalizer.
+func (s *SecretProvider) RemoveFinalizer(ctx context.Context, ref provider.ClusterRef) error {
+	secret, err := s.getSecret(ref)
+	if apierrors.IsNotFound(err) {
+		secret, err = s.finalizedSecret(ctx, ref)
+	}
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var finalizers []string
+	for _, f := range secret.Finalizers {
+		if f != provider.FinalizerDetach {
+			finalizers = append(finalizers, f)
+		}
+	}
+	if len(finalizers) == len(secret.Finalizers) {
+		return nil
+	}
+
+	secret = secret.DeepCopy()
+	secret.Finalizers = finalizers
+	_, err = s.kubeClient.CoreV1().Secrets(secret.Namespace).Update(ctx, secret, metav1.UpdateOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// ReportStatus mirrors the import status to the annotations of the secret
+func (s *SecretProvider) ReportStatus(ctx context.Context, ref provider.ClusterRef, status provider.ImportStatus) error {
+	secret, err := s.getSecret(ref)
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	modified := false
+	secret = secret.DeepCopy()
+	resourcemerge.MergeMap(&modified, &secret.Annotations, status.Annotations())
+	if !modified {
+		return nil
+	}
+	_, err = s.kubeClient.CoreV1().Secrets(secret.Namespace).Update(ctx, secret, metav1.UpdateOptions{})
+	return err
+}
+
+// getSecret returns the secret of the cluster, the names of the clusters in a namespace must be
+// unique, or the cluster is not imported.
+func (s *SecretProvider) getSecret(ref provider.ClusterRef) (*corev1.Secret, error) {
+	objs, err := s.indexer.ByIndex(byClusterName, fmt.Sprintf("%s/%s", ref.Namespace, ref.Name))
+	if err != nil {
+		return nil, err
+	}
+	switch len(objs) {
+	case 0:
+		return nil, apierrors.NewNotFound(corev1.Resource("secrets"), ref.Name)
+	case 1:
+	default:
+		return nil, fmt.Errorf("cluster %s is referred by %d secrets", ref, len(objs))
+	}
+
+	secret, ok := objs[0].(*corev1.Secret)
+	if !ok {
+		return nil, fmt.Errorf("object of cluster %s is not a secret", ref)
+	}
+	return secret, nil
+}
+
+// finalizedSecret returns the secret of the cluster with FinalizerDetach from the apiserver. The
+// name of the secret is not known once it is out of the informer cache, so the secrets in the
+// namespace are listed and matched by the name of the cluster.
+func (s *SecretProvider) finalizedSecret(ctx context.Context, ref provider.ClusterRef) (*corev1.Secret, error) {
+	secrets, err := s.kubeClient.CoreV1().Secrets(ref.Namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	var found []*corev1.Secret
+	for i := range secrets.Items {
+		secret := &secrets.Items[i]
+		if name, err := clusterName(secret); err != nil || name != ref.Name {
+			continue
+		}
+		for _, f := range secret.Finalizers {
+			if f == provider.FinalizerDetach {
+				found = append(found, secret)
+				break
+			}
+		}
+	}
+	switch len(found) {
+	case 0:
+		return nil, apierrors.NewNotFound(corev1.Resource("secrets"), ref.Name)
+	case 1:
+		return found[0], nil
+	default:
+		return nil, fmt.Errorf("cluster %s is referred by %d secrets", ref, len(found))
+	}
+}
+
+func indexByClusterName(obj interface{}) ([]string, error) {
+	secret, ok := obj.(*corev1.Secret)
+	if !ok {
+		return []string{}, nil
+	}
+	name, err := clusterName(secret)
+	if err != nil {
+		return []string{}, nil
+	}
+	return []string{fmt.Sprintf("%s/%s", secret.Namespace, name)}, nil
+}
+
+// clusterName returns the name of the cluster in the annotation of the secret, or the name of the
+// secret. An error is returned if the name is not a valid name of a ManagedCluster.
+func clusterName(secret *corev1.Secret) (string, error) {
+	name := secret.Name
+	if annotated := secret.Annotations[AnnotationClusterName]; len(annotated) > 0 {
+		name = annotated
+	}
+	if errs := validation.IsDNS1123Label(name); len(errs) > 0 {
+		return "", errors.Errorf("%s is not a valid cluster name: %s", name, strings.Join(errs, ", "))
+	}
+	return name, nil
+}
+
+func secretLabels(secret *corev1.Secret) map[string]string {
+	labels := map[string]string{
+		provider.LabelInfrastructureKind: "Secret",
+		provider.LabelCloud:              provider.CloudOther,
+	}
+	for _, key := range []string{provider.LabelCloud, provider.LabelRegion} {
+		if value := secret.Labels[key]; len(value) > 0 {
+			labels[key] = value
+		}
+	}
+	return labels
+}
@@ -0,0 +1,311 @@
+package secret
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/qiujian16/capi-importer/pkg/provider"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
+)
+
+func newSecret(name, cluster string) *corev1.Secret {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "ns1",
+			Labels:    map[string]string{LabelKubeConfig: "true"},
+		},
+	}
+	if len(cluster) > 0 {
+		secret.Annotations = map[string]string{AnnotationClusterName: cluster}
+	}
+	return secret
+}
+
+func deletingSecret(secret *corev1.Secret) *corev1.Secret {
+	now := metav1.NewTime(time.Now())
+	secret.DeletionTimestamp = &now
+	return secret
+}
+
+func withFinalizers(secret *corev1.Secret, finalizers ...string) *corev1.Secret {
+	secret.Finalizers = finalizers
+	return secret
+}
+
+// newTestSecretProvider returns a provider whose informer cache has the labeled secrets, all the
+// secrets are on the fake apiserver.
+func newTestSecretProvider(t *testing.T, secrets ...*corev1.Secret) (*SecretProvider, *kubefake.Clientset) {
+	objs := []runtime.Object{}
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{byClusterName: indexByClusterName})
+	for _, secret := range secrets {
+		objs = append(objs, secret)
+		if secret.Labels[LabelKubeConfig] != "true" {
+			continue
+		}
+		if err := indexer.Add(secret); err != nil {
+			t.Fatal(err)
+		}
+	}
+	kubeClient := kubefake.NewSimpleClientset(objs...)
+	return &SecretProvider{indexer: indexer, kubeClient: kubeClient}, kubeClient
+}
+
+func updatedSecret(t *testing.T, kubeClient *kubefake.Clientset) *corev1.Secret {
+	var updated *corev1.Secret
+	for _, action := range kubeClient.Actions() {
+		if action.GetVerb() == "update" {
+			updated = action.(clienttesting.UpdateAction).GetObject().(*corev1.Secret)
+		}
+	}
+	return updated
+}
+
+func TestKey(t *testing.T) {
+	cases := []struct {
+		name     string
+		secret   *corev1.Secret
+		expected []string
+	}{
+		{
+			name:     "name of the secret",
+			secret:   newSecret("cluster1", ""),
+			expected: []string{"secret/ns1/cluster1"},
+		},
+		{
+			name:     "name in the annotation",
+			secret:   newSecret("kubeconfig1", "cluster1"),
+			expected: []string{"secret/ns1/cluster1"},
+		},
+		{
+			name:     "invalid name in the annotation",
+			secret:   newSecret("kubeconfig1", "Cluster_1"),
+			expected: []string{},
+		},
+		{
+			name:     "invalid name of the secret",
+			secret:   newSecret("cluster1.example.com", ""),
+			expected: []string{},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			p := &SecretProvider{}
+			keys := p.Key(c.secret)
+			if !reflect.DeepEqual(keys, c.expected) {
+				t.Errorf("expected keys %v, but got %v", c.expected, keys)
+			}
+		})
+	}
+}
+
+func TestGetSecret(t *testing.T) {
+	cases := []struct {
+		name        string
+		secrets     []*corev1.Secret
+		expected    string
+		expectedErr func(error) bool
+	}{
+		{
+			name:        "no secret",
+			secrets:     []*corev1.Secret{newSecret("cluster2", "")},
+			expectedErr: apierrors.IsNotFound,
+		},
+		{
+			name:     "annotated secret",
+			secrets:  []*corev1.Secret{newSecret("kubeconfig1", "cluster1"), newSecret("cluster2", "")},
+			expected: "kubeconfig1",
+		},
+		{
+			name:        "duplicated cluster name",
+			secrets:     []*corev1.Secret{newSecret("kubeconfig1", "cluster1"), newSecret("cluster1", "")},
+			expectedErr: func(err error) bool { return err != nil && !apierrors.IsNotFound(err) },
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{byClusterName: indexByClusterName})
+			for _, secret := range c.secrets {
+				if err := indexer.Add(secret); err != nil {
+					t.Fatal(err)
+				}
+			}
+			p := &SecretProvider{indexer: indexer}
+
+			secret, err := p.getSecret(provider.ClusterRef{Provider: "secret", Namespace: "ns1", Name: "cluster1"})
+			if c.expectedErr != nil {
+				if !c.expectedErr(err) {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if secret.Name != c.expected {
+				t.Errorf("expected secret %s, but got %s", c.expected, secret.Name)
+			}
+		})
+	}
+}
+
+var testRef = provider.ClusterRef{Provider: "secret", Namespace: "ns1", Name: "cluster1"}
+
+func TestDeleted(t *testing.T) {
+	cases := []struct {
+		name     string
+		secret   *corev1.Secret
+		expected bool
+	}{
+		{
+			name:   "secret exists",
+			secret: newSecret("kubeconfig1", "cluster1"),
+		},
+		{
+			name:     "secret is deleting",
+			secret:   deletingSecret(newSecret("kubeconfig1", "cluster1")),
+			expected: true,
+		},
+		{
+			name:     "secret is removed",
+			secret:   newSecret("kubeconfig2", "cluster2"),
+			expected: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			p, _ := newTestSecretProvider(t, c.secret)
+			deleted, err := p.Deleted(testRef)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if deleted != c.expected {
+				t.Errorf("expected deleted %v, but got %v", c.expected, deleted)
+			}
+		})
+	}
+}
+
+func TestFinalizer(t *testing.T) {
+	unlabeled := func(secret *corev1.Secret) *corev1.Secret {
+		secret.Labels = nil
+		return secret
+	}
+	cases := []struct {
+		name               string
+		secret             *corev1.Secret
+		remove             bool
+		expectedFinalizers []string
+	}{
+		{
+			name:               "add the finalizer",
+			secret:             withFinalizers(newSecret("kubeconfig1", "cluster1"), "other"),
+			expectedFinalizers: []string{"other", provider.FinalizerDetach},
+		},
+		{
+			name:   "finalizer is added already",
+			secret: withFinalizers(newSecret("kubeconfig1", "cluster1"), provider.FinalizerDetach),
+		},
+		{
+			name:   "do not add the finalizer to the deleting secret",
+			secret: deletingSecret(newSecret("kubeconfig1", "cluster1")),
+		},
+		{
+			name:               "remove the finalizer",
+			secret:             withFinalizers(deletingSecret(newSecret("kubeconfig1", "cluster1")), "other", provider.FinalizerDetach),
+			remove:             true,
+			expectedFinalizers: []string{"other"},
+		},
+		{
+			name:               "remove the finalizer of the secret whose label is removed",
+			secret:             withFinalizers(unlabeled(newSecret("kubeconfig1", "cluster1")), provider.FinalizerDetach),
+			remove:             true,
+			expectedFinalizers: []string{},
+		},
+		{
+			name:   "secret without the finalizer is not updated",
+			secret: unlabeled(newSecret("kubeconfig1", "cluster1")),
+			remove: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			p, kubeClient := newTestSecretProvider(t, c.secret)
+			var err error
+			if c.remove {
+				err = p.RemoveFinalizer(context.TODO(), testRef)
+			} else {
+				err = p.AddFinalizer(context.TODO(), testRef)
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			updated := updatedSecret(t, kubeClient)
+			if c.expectedFinalizers == nil {
+				if updated != nil {
+					t.Errorf("expected the secret is not updated, but got %v", updated.Finalizers)
+				}
+				return
+			}
+			if updated == nil {
+				t.Fatalf("expected the secret is updated")
+			}
+			if len(updated.Finalizers) != len(c.expectedFinalizers) ||
+				(len(updated.Finalizers) > 0 && !reflect.DeepEqual(updated.Finalizers, c.expectedFinalizers)) {
+				t.Errorf("expected finalizers %v, but got %v", c.expectedFinalizers, updated.Finalizers)
+			}
+		})
+	}
+}
+
+func TestReportStatus(t *testing.T) {
+	status := provider.ImportStatus{Phase: "Joined", Reason: "ClusterJoined", Message: "cluster is joined"}
+
+	p, kubeClient := newTestSecretProvider(t, newSecret("kubeconfig1", "cluster1"))
+	if err := p.ReportStatus(context.TODO(), testRef, status); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	updated := updatedSecret(t, kubeClient)
+	if updated == nil {
+		t.Fatalf("expected the status is reported on the secret")
+	}
+	for key, value := range status.Annotations() {
+		if updated.Annotations[key] != value {
+			t.Errorf("expected annotation %s=%s, but got %v", key, value, updated.Annotations)
+		}
+	}
+	if updated.Annotations[AnnotationClusterName] != "cluster1" {
+		t.Errorf("expected the annotations of the secret are kept, but got %v", updated.Annotations)
+	}
+
+	// the same status is not reported again
+	p, kubeClient = newTestSecretProvider(t, updated)
+	if err := p.ReportStatus(context.TODO(), testRef, status); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if updated := updatedSecret(t, kubeClient); updated != nil {
+		t.Errorf("expected the secret is not updated, but got %v", updated.Annotations)
+	}
+
+	// the status of the removed secret is not reported
+	p, kubeClient = newTestSecretProvider(t)
+	if err := p.ReportStatus(context.TODO(), testRef, status); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(kubeClient.Actions()) > 0 {
+		t.Errorf("expected no actions, but got %v", kubeClient.Actions())
+	}
+}